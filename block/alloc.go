@@ -0,0 +1,270 @@
+package block
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// Cells are aligned to 8 bytes and can not be smaller than that
+	HCellAlignment = 8
+	// HBins are allocated in multiples of 4096 bytes
+	HBinAlignment = 4096
+)
+
+// Allocator places cells in hbins data and releases them, the hbins
+// size stored in the base block is kept in sync when hbins are appended
+type Allocator struct {
+	BaseBlock *BaseBlock
+	HBins     *HBinData
+}
+
+func NewAllocator(bb *BaseBlock, hbins *HBinData) *Allocator {
+	return &Allocator{BaseBlock: bb, HBins: hbins}
+}
+
+// Allocate places the cell in the first unallocated cell large enough
+// to hold it, splitting the unallocated cell if possible, or in a new
+// HBin appended at the end, and returns its offset from the start of
+// hbins data, cell size is set to its marshaled size aligned to 8
+func (a *Allocator) Allocate(hc HCell) (int32, error) {
+	data, err := hc.marshal()
+	if err != nil {
+		return 0, err
+	}
+	size := alignCellSize(int32(len(data)))
+
+	hbins := a.HBins
+	for i := range *hbins {
+		if ok, err := (*hbins)[i].allocate(hc, size); ok || err != nil {
+			return hc.AbsoluteOffset(), err
+		}
+	}
+
+	hb := hbins.appendHBin(size)
+	if a.BaseBlock != nil {
+		a.BaseBlock.HBinSize = hbins.TotalSize()
+	}
+	if _, err := hb.allocate(hc, size); err != nil {
+		return 0, err
+	}
+	return hc.AbsoluteOffset(), nil
+}
+
+// Append places the cell at the end of hbins data, in the trailing
+// unallocated cell of the last HBin or in a new HBin appended at the
+// end, so that cells appended one after another are laid out contiguously
+func (a *Allocator) Append(hc HCell) (int32, error) {
+	data, err := hc.marshal()
+	if err != nil {
+		return 0, err
+	}
+	size := alignCellSize(int32(len(data)))
+
+	hbins := a.HBins
+	if n := len(*hbins); n > 0 {
+		hb := &(*hbins)[n-1]
+		j := len(hb.Cells) - 1
+		if j >= 0 && !hb.Cells[j].Allocated() && hb.Cells[j].Size() >= size {
+			if err := hb.place(j, hc, size); err != nil {
+				return 0, err
+			}
+			return hc.AbsoluteOffset(), nil
+		}
+	}
+
+	hb := hbins.appendHBin(size)
+	if a.BaseBlock != nil {
+		a.BaseBlock.HBinSize = hbins.TotalSize()
+	}
+	if err := hb.place(0, hc, size); err != nil {
+		return 0, err
+	}
+	return hc.AbsoluteOffset(), nil
+}
+
+// allocate places the cell in the first unallocated cell of the
+// HBin that can hold size bytes, returns false if there is none
+func (hb *HBin) allocate(hc HCell, size int32) (bool, error) {
+	for j, free := range hb.Cells {
+		if !free.Allocated() && free.Size() >= size {
+			return true, hb.place(j, hc, size)
+		}
+	}
+	return false, nil
+}
+
+// place puts the cell in place of the unallocated cell at index j
+// which is split if the remainder can hold another cell
+func (hb *HBin) place(j int, hc HCell, size int32) error {
+	free := hb.Cells[j]
+	cells := []HCell{hc}
+	if free.Size()-size >= HCellAlignment {
+		remainder, err := splitFreeCell(free, size)
+		if err != nil {
+			return err
+		}
+		cells = append(cells, remainder)
+	} else {
+		size = free.Size()
+	}
+	if err := resizeCell(hc, size); err != nil {
+		return err
+	}
+	hc.setOffset(free.Offset())
+
+	hb.Cells = append(hb.Cells[:j:j], append(cells, hb.Cells[j+1:]...)...)
+	for _, c := range cells {
+		c.setParentHBin(hb)
+	}
+	return nil
+}
+
+// Free marks the cell at offset as unallocated and merges it with
+// adjacent unallocated cells of the same HBin, content of the merged
+// cells is kept in the resulting unallocated cell
+func (a *Allocator) Free(offset int32) error {
+	hc, err := a.HBins.CellAt(offset)
+	if err != nil {
+		return err
+	}
+	if !hc.Allocated() {
+		return fmt.Errorf("cell at offset %#x is not allocated", offset)
+	}
+
+	hb := hc.cellData().ParentHBin
+	j := -1
+	for i, c := range hb.Cells {
+		if c == hc {
+			j = i
+			break
+		}
+	}
+	if j < 0 {
+		return fmt.Errorf("cell at offset %#x not found in its hbin", offset)
+	}
+
+	first, last := j, j
+	if first > 0 && !hb.Cells[first-1].Allocated() {
+		first--
+	}
+	if last+1 < len(hb.Cells) && !hb.Cells[last+1].Allocated() {
+		last++
+	}
+
+	var data []byte
+	for _, c := range hb.Cells[first : last+1] {
+		content, err := cellContent(c)
+		if err != nil {
+			return err
+		}
+		size := make([]byte, HCellSizeLength)
+		binary.LittleEndian.PutUint32(size, uint32(c.Size()))
+		data = append(append(data, size...), content...)
+	}
+	free := &DataRecord{
+		HCellData: HCellData{BlockSize: int32(len(data))},
+		Data:      data[HCellSizeLength:],
+	}
+	free.setOffset(hb.Cells[first].Offset())
+	free.setParentHBin(hb)
+
+	hb.Cells = append(hb.Cells[:first:first], append([]HCell{free}, hb.Cells[last+1:]...)...)
+	return nil
+}
+
+// appendHBin appends an HBin that can hold a cell of the provided
+// size, the HBin contains a single unallocated cell
+func (hbins *HBinData) appendHBin(cellSize int32) *HBin {
+	var offset int32
+	var timestamp uint64
+	if n := len(*hbins); n > 0 {
+		last := (*hbins)[n-1]
+		offset = last.HBinDataOffset + last.HBinSize
+		timestamp = last.Timestamp
+	}
+
+	size := (HBinHeaderSize + cellSize + HBinAlignment - 1) &^ (HBinAlignment - 1)
+	free := &DataRecord{
+		HCellData: HCellData{BlockSize: size - HBinHeaderSize},
+		Data:      make([]byte, size-HBinHeaderSize-HCellSizeLength),
+	}
+	free.setOffset(HBinHeaderSize)
+
+	*hbins = append(*hbins, HBin{
+		HBinHeader: HBinHeader{
+			HBinSignature:  HBinHeaderSignature,
+			HBinDataOffset: offset,
+			HBinSize:       size,
+			Timestamp:      timestamp,
+		},
+		Cells: []HCell{free},
+	})
+	hbins.relink()
+	return &(*hbins)[len(*hbins)-1]
+}
+
+// TotalSize returns the sum of all HBin sizes as
+// stored in the HBinSize field of the base block
+func (hbins *HBinData) TotalSize() uint32 {
+	var size uint32
+	for _, hb := range *hbins {
+		size += uint32(hb.HBinSize)
+	}
+	return size
+}
+
+// splitFreeCell returns the unallocated remainder of the free cell
+// after the first size bytes, remainder keeps the original content
+func splitFreeCell(free HCell, size int32) (*DataRecord, error) {
+	data, err := cellContent(free)
+	if err != nil {
+		return nil, err
+	}
+
+	remainder := &DataRecord{
+		HCellData: HCellData{BlockSize: free.Size() - size},
+		Data:      data[size:],
+	}
+	remainder.setOffset(free.Offset() + size)
+	return remainder, nil
+}
+
+// resizeCell pads the cell to the provided size and marks it allocated
+func resizeCell(hc HCell, size int32) error {
+	data, err := hc.marshal()
+	if err != nil {
+		return err
+	}
+	if int32(len(data)) > size {
+		return fmt.Errorf("cell of size %d does not fit in %d bytes", len(data), size)
+	}
+
+	pad := make([]byte, size-int32(len(data)))
+	if dr, ok := hc.(*DataRecord); ok {
+		dr.Data = append(dr.Data[:len(dr.Data):len(dr.Data)], pad...)
+	} else {
+		hcd := hc.cellData()
+		hcd.Padding = append(hcd.Padding[:len(hcd.Padding):len(hcd.Padding)], pad...)
+	}
+	hc.cellData().BlockSize = -size
+	return nil
+}
+
+// cellContent returns a copy of cell bytes following the size field
+// spanning the whole cell, missing bytes are filled with zeros
+func cellContent(hc HCell) ([]byte, error) {
+	data, err := hc.marshal()
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, hc.Size()-HCellSizeLength)
+	if len(data) > HCellSizeLength {
+		copy(content, data[HCellSizeLength:])
+	}
+	return content, nil
+}
+
+func alignCellSize(size int32) int32 {
+	return (size + HCellAlignment - 1) &^ (HCellAlignment - 1)
+}
@@ -0,0 +1,250 @@
+package block
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testAllocHBins returns a single hbin holding a 16 byte
+// data cell followed by an unallocated cell of the remaining size
+func testAllocHBins(t *testing.T) *HBinData {
+	data := make([]byte, HBinAlignment)
+	copy(data, "hbin")
+	binary.LittleEndian.PutUint32(data[8:], HBinAlignment)
+	binary.LittleEndian.PutUint32(data[32:], 0xfffffff0)
+	binary.LittleEndian.PutUint32(data[48:], HBinAlignment-48)
+	copy(data[52:], "old")
+
+	hbins := &HBinData{}
+	if err := hbins.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal hbins: %v", err)
+	}
+	return hbins
+}
+
+func TestAllocatorAllocate(t *testing.T) {
+	hbins := testAllocHBins(t)
+	bb := &BaseBlock{HBinSize: HBinAlignment}
+	a := NewAllocator(bb, hbins)
+
+	dr := &DataRecord{Data: []byte("class")}
+	offset, err := a.Allocate(dr)
+	if err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+	if got, want := offset, int32(0x30); got != want {
+		t.Errorf("cell offset mismatch: got %#x, want %#x", got, want)
+	}
+	if got, want := dr.BlockSize, int32(-16); got != want {
+		t.Errorf("cell size mismatch: got %d, want %d", got, want)
+	}
+
+	free, err := hbins.CellAt(0x40)
+	if err != nil {
+		t.Fatalf("failed to find split cell: %v", err)
+	}
+	if got, want := free.Size(), int32(HBinAlignment-64); got != want || free.Allocated() {
+		t.Errorf("split cell mismatch: got %d, want unallocated %d", got, want)
+	}
+
+	big := &DataRecord{Data: make([]byte, HBinAlignment)}
+	offset, err = a.Allocate(big)
+	if err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+	if got, want := offset, int32(HBinAlignment+HBinHeaderSize); got != want {
+		t.Errorf("appended cell offset mismatch: got %#x, want %#x", got, want)
+	}
+	if got, want := len(*hbins), 2; got != want {
+		t.Fatalf("hbin count mismatch: got %d, want %d", got, want)
+	}
+	if got, want := hbins.TotalSize(), uint32(3*HBinAlignment); got != want {
+		t.Errorf("hbins total size mismatch: got %d, want %d", got, want)
+	}
+	if got, want := bb.HBinSize, uint32(3*HBinAlignment); got != want {
+		t.Errorf("base block hbins size mismatch: got %d, want %d", got, want)
+	}
+
+	data, err := hbins.marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+	reloaded := &HBinData{}
+	if err := reloaded.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal allocated hbins: %v", err)
+	}
+	hc, err := reloaded.CellAt(0x30)
+	if err != nil {
+		t.Fatalf("failed to find allocated cell: %v", err)
+	}
+	if got, want := hc.(*DataRecord).Data[:5], []byte("class"); !reflect.DeepEqual(got, want) {
+		t.Errorf("allocated cell data mismatch: got %q, want %q", got, want)
+	}
+}
+
+func TestAllocatorAllocateWholeCell(t *testing.T) {
+	hbins := testAllocHBins(t)
+
+	dr := &DataRecord{Data: make([]byte, HBinAlignment-48-HCellSizeLength)}
+	if _, err := NewAllocator(nil, hbins).Allocate(dr); err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+	if got, want := dr.Size(), int32(HBinAlignment-48); got != want {
+		t.Errorf("cell size mismatch: got %d, want %d", got, want)
+	}
+	if got, want := len((*hbins)[0].Cells), 2; got != want {
+		t.Errorf("cell count mismatch: got %d, want %d", got, want)
+	}
+}
+
+func TestAllocatorFree(t *testing.T) {
+	hbins := testAllocHBins(t)
+	a := NewAllocator(nil, hbins)
+
+	// keep the cell following the freed one allocated
+	if _, err := a.Allocate(&DataRecord{Data: []byte("next")}); err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+	if err := a.Free(0x20); err != nil {
+		t.Fatalf("failed to free cell: %v", err)
+	}
+	hc, err := hbins.CellAt(0x20)
+	if err != nil {
+		t.Fatalf("failed to find freed cell: %v", err)
+	}
+	if got, want := hc.(*DataRecord).BlockSize, int32(16); got != want {
+		t.Errorf("freed cell size mismatch: got %d, want %d", got, want)
+	}
+	if err := a.Free(0x20); err == nil {
+		t.Errorf("expected error when freeing unallocated cell")
+	}
+}
+
+func TestAllocatorFreeCoalesces(t *testing.T) {
+	hbins := testAllocHBins(t)
+	a := NewAllocator(nil, hbins)
+
+	// unallocated cells at 0x20 and 0x38 surround the allocated cell
+	if _, err := a.Allocate(&DataRecord{Data: []byte("next")}); err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+	if err := a.Free(0x20); err != nil {
+		t.Fatalf("failed to free cell: %v", err)
+	}
+	if err := a.Free(0x30); err != nil {
+		t.Fatalf("failed to free cell: %v", err)
+	}
+	cells := (*hbins)[0].Cells
+	if len(cells) != 1 || cells[0].Size() != HBinAlignment-HBinHeaderSize || cells[0].Allocated() {
+		t.Fatalf("hbin should hold a single unallocated cell, got %d cells", len(cells))
+	}
+
+	// content of merged cells is kept, including their size fields
+	data := cells[0].(*DataRecord).Data
+	if got, want := binary.LittleEndian.Uint32(data[12:]), uint32(8); got != want {
+		t.Errorf("merged cell size field mismatch: got %d, want %d", got, want)
+	}
+	if got, want := string(data[16:20]), "next"; got != want {
+		t.Errorf("merged cell content mismatch: got %q, want %q", got, want)
+	}
+	if got, want := binary.LittleEndian.Uint32(data[20:]), uint32(HBinAlignment-HBinHeaderSize-24); got != want {
+		t.Errorf("merged cell size field mismatch: got %d, want %d", got, want)
+	}
+}
+
+func TestAllocatorNeverStraddlesHBins(t *testing.T) {
+	hbins := testAllocHBins(t)
+	bb := &BaseBlock{HBinSize: HBinAlignment}
+	a := NewAllocator(bb, hbins)
+	rnd := rand.New(rand.NewSource(1))
+
+	var offsets []int32
+	for i := 0; i < 500; i++ {
+		if len(offsets) > 0 && rnd.Intn(3) == 0 {
+			j := rnd.Intn(len(offsets))
+			if err := a.Free(offsets[j]); err != nil {
+				t.Fatalf("failed to free cell at %#x: %v", offsets[j], err)
+			}
+			offsets = append(offsets[:j], offsets[j+1:]...)
+			continue
+		}
+
+		size := rnd.Intn(64) + 1
+		if rnd.Intn(20) == 0 {
+			size = rnd.Intn(3*HBinAlignment) + 1
+		}
+		offset, err := a.Allocate(&DataRecord{Data: make([]byte, size)})
+		if err != nil {
+			t.Fatalf("failed to allocate %d bytes: %v", size, err)
+		}
+		if offset%HCellAlignment != 0 {
+			t.Fatalf("cell offset %#x is not aligned", offset)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	if got, want := bb.HBinSize, hbins.TotalSize(); got != want {
+		t.Errorf("base block hbins size mismatch: got %d, want %d", got, want)
+	}
+	var hbinOffset int32
+	for _, hb := range *hbins {
+		if hb.HBinDataOffset != hbinOffset || hb.HBinSize%HBinAlignment != 0 {
+			t.Fatalf("hbin at %#x of size %d is misplaced, expected offset %#x", hb.HBinDataOffset, hb.HBinSize, hbinOffset)
+		}
+		hbinOffset += hb.HBinSize
+
+		next := int32(HBinHeaderSize)
+		for i, hc := range hb.Cells {
+			if hc.Offset() != next || hc.Size()%HCellAlignment != 0 || hc.Offset()+hc.Size() > hb.HBinSize {
+				t.Fatalf("cell at %#x of size %d straddles hbin at %#x", hc.AbsoluteOffset(), hc.Size(), hb.HBinDataOffset)
+			}
+			if i > 0 && !hc.Allocated() && !hb.Cells[i-1].Allocated() {
+				t.Errorf("adjacent unallocated cells at %#x were not merged", hc.AbsoluteOffset())
+			}
+			next += hc.Size()
+		}
+		if next != hb.HBinSize {
+			t.Fatalf("cells of hbin at %#x end at %#x, hbin size is %#x", hb.HBinDataOffset, next, hb.HBinSize)
+		}
+	}
+
+	data, err := hbins.marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+	reloaded := &HBinData{}
+	if err := reloaded.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal allocated hbins: %v", err)
+	}
+	for _, offset := range offsets {
+		if hc, err := reloaded.CellAt(offset); err != nil || !hc.Allocated() {
+			t.Errorf("allocated cell at %#x not found after reload: %v", offset, err)
+		}
+	}
+}
+
+func TestAllocatorAppend(t *testing.T) {
+	hbins := &HBinData{}
+	bb := &BaseBlock{}
+	a := NewAllocator(bb, hbins)
+
+	var offsets []int32
+	for _, size := range []int{100, 3000, 1000, 20, 5000} {
+		offset, err := a.Append(&DataRecord{Data: make([]byte, size)})
+		if err != nil {
+			t.Fatalf("failed to append cell of %d bytes: %v", size, err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	// cells are never placed in unallocated space before the last cell
+	want := []int32{0x20, 0x88, 0x1020, 0x1410, 0x2020}
+	if !reflect.DeepEqual(offsets, want) {
+		t.Errorf("appended cell offsets mismatch: got %#x, want %#x", offsets, want)
+	}
+	if got, want := bb.HBinSize, uint32(4*HBinAlignment); got != want {
+		t.Errorf("base block hbins size mismatch: got %d, want %d", got, want)
+	}
+}
@@ -5,11 +5,31 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
 const (
 	BaseBlockSize = 4096
+	// "regf" read as a little endian uint32
+	BaseBlockSignature = 0x66676572
+	// Checksum covers base block bytes preceding the checksum field
+	BaseBlockChecksumLength = 508
+)
+
+const (
+	// Set in Flags of the base block when the hive has pending transactions
+	BaseBlockPendingTransactions = 0x1
+	// Set in Flags of the base block when the hive data was reorganized
+	BaseBlockDefragmented = 0x2
+)
+
+const (
+	// Types of the last reorganization stored in the
+	// lowest 2 bits of LastRTimestamp of the base block
+	ReorganizationDefragmented      = 0x1
+	ReorganizationAccessBitsCleared = 0x2
+	ReorganizationTypeMask          = 0x3
 )
 
 var (
@@ -135,9 +155,60 @@ func (b *BaseBlock) Signature() string {
 	return string(uint32toba(b.RegfHeader))
 }
 
+// ComputeChecksum returns the XOR-32 checksum of the
+// first 508 bytes of the base block
+func (b *BaseBlock) ComputeChecksum() (uint32, error) {
+	data, err := b.marshal()
+	if err != nil {
+		return 0, err
+	}
+	return BaseBlockChecksum(data), nil
+}
+
+// ValidChecksum reports whether the stored checksum matches the computed one
+func (b *BaseBlock) ValidChecksum() bool {
+	checksum, err := b.ComputeChecksum()
+	return err == nil && checksum == b.Checksum
+}
+
+// UpdateChecksum stores the computed checksum in the base block
+func (b *BaseBlock) UpdateChecksum() error {
+	checksum, err := b.ComputeChecksum()
+	if err != nil {
+		return err
+	}
+	b.Checksum = checksum
+	return nil
+}
+
+// BaseBlockChecksum computes the XOR-32 checksum of raw base block
+// data, 0 is stored as 1 and 0xffffffff is stored as 0xfffffffe
+func BaseBlockChecksum(data []byte) uint32 {
+	var checksum uint32
+	for i := 0; i+4 <= BaseBlockChecksumLength && i+4 <= len(data); i += 4 {
+		checksum ^= binary.LittleEndian.Uint32(data[i:])
+	}
+
+	switch checksum {
+	case 0:
+		return 1
+	case 0xffffffff:
+		return 0xfffffffe
+	}
+	return checksum
+}
+
+// ParseFiletime converts a FILETIME as stored in timestamps, number
+// of 100ns intervals since 1601, to time keeping its full precision
 func ParseFiletime(ft uint64) time.Time {
-	// From https://github.com/Velocidex/regparser/blob/8e74df808b0a4609952bbf8643cbb3bab6b6a438/helpers.go#L17-L19
-	return time.Unix(int64(((ft - 11644473600000*10000) / 10000000)), 0)
+	intervals := int64(ft) - 11644473600000*10000
+	return time.Unix(intervals/10000000, intervals%10000000*100)
+}
+
+// Filetime converts the time to a FILETIME as stored in
+// timestamps, number of 100ns intervals since 1601
+func Filetime(t time.Time) uint64 {
+	return uint64(t.Unix()*10000000 + int64(t.Nanosecond()/100) + 11644473600000*10000)
 }
 
 func binaryRead(data []byte, s any) error {
@@ -182,3 +253,27 @@ func binaryBufferWrite(buf *bytes.Buffer, s any) error {
 func uint32toba(u uint32) []byte {
 	return []byte{uint8(u >> 24), uint8(u >> 16), uint8(u >> 8), uint8(u & 0xff)}
 }
+
+type flagName struct {
+	flag uint64
+	name string
+}
+
+// flagString lists names of flags set in value separated by |,
+// unknown bits are appended in hexadecimal form
+func flagString(value uint64, names []flagName) string {
+	var set []string
+	for _, fn := range names {
+		if value&fn.flag != 0 {
+			set = append(set, fn.name)
+			value &^= fn.flag
+		}
+	}
+	if value != 0 {
+		set = append(set, fmt.Sprintf("%#x", value))
+	}
+	if len(set) == 0 {
+		return "0"
+	}
+	return strings.Join(set, "|")
+}
@@ -1,8 +1,10 @@
 package block
 
 import (
+	"encoding/binary"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestBaseBlockMarshaling(t *testing.T) {
@@ -157,4 +159,62 @@ func TestBaseBlockMarshaling(t *testing.T) {
 	if got, want := data, recordBaseBlock[:]; !reflect.DeepEqual(got, want) {
 		t.Errorf("marshal bytes not equal to expected (got|want):\n%v\n%v", got, want)
 	}
+
+	if !bb.ValidChecksum() {
+		t.Errorf("base block checksum should be valid")
+	}
+}
+
+func TestBaseBlockChecksum(t *testing.T) {
+	bb := &BaseBlock{RegfHeader: BaseBlockSignature, Sequence1: 3}
+	if err := bb.UpdateChecksum(); err != nil {
+		t.Fatalf("failed to update checksum: %v", err)
+	}
+	if got, want := bb.Checksum, uint32(BaseBlockSignature^3); got != want {
+		t.Errorf("checksum mismatch: got %#x, want %#x", got, want)
+	}
+
+	bb.Sequence2 = 7
+	if bb.ValidChecksum() {
+		t.Errorf("checksum should be invalid after modification")
+	}
+
+	testCases := []struct {
+		Data     []uint32
+		Checksum uint32
+	}{
+		{[]uint32{0x12345678, 0x12345678}, 1},
+		{[]uint32{0xffff0000, 0x0000ffff}, 0xfffffffe},
+		{[]uint32{0xff, 0xff00}, 0xffff},
+	}
+	for _, tc := range testCases {
+		data := make([]byte, BaseBlockSize)
+		for i, v := range tc.Data {
+			binary.LittleEndian.PutUint32(data[i*4:], v)
+		}
+		// bytes past the checksum field are not covered
+		data[BaseBlockChecksumLength] = 0xff
+		if got, want := BaseBlockChecksum(data), tc.Checksum; got != want {
+			t.Errorf("checksum of %x mismatch: got %#x, want %#x", tc.Data, got, want)
+		}
+	}
+}
+
+func TestFiletime(t *testing.T) {
+	// 2009-07-14 04:34:28 UTC as stored in Windows 7 hives
+	ts := time.Date(2009, 7, 14, 4, 34, 28, 0, time.UTC)
+	ft := Filetime(ts)
+	if got, want := ft, uint64(0x01ca043c5f7c2200); got != want {
+		t.Errorf("filetime mismatch: got %#x, want %#x", got, want)
+	}
+	if got := ParseFiletime(ft); !got.Equal(ts) {
+		t.Errorf("parsed filetime mismatch: got %v, want %v", got, ts)
+	}
+
+	// 100ns precision is kept in both directions
+	for _, ft := range []uint64{0x01ca043c5f7c2201, 0x01d9a1b2c3d4e5f7, 0} {
+		if got := Filetime(ParseFiletime(ft)); got != ft {
+			t.Errorf("filetime %#x changed to %#x", ft, got)
+		}
+	}
 }
@@ -1,5 +1,12 @@
 package block
 
+const (
+	// Maximum number of bytes stored in a single big data segment
+	BigDataSegmentSize = 16344
+	// Minor version of the format that introduced big data cells
+	BigDataMinorVersion = 4
+)
+
 type BigData struct {
 	HCellData
 	DataOffset int32
@@ -28,3 +35,13 @@ func (bd *BigData) unmarshal(data []byte) error {
 	bd.Padding = data[dEnd:bd.Size()]
 	return nil
 }
+
+func (bd *BigData) SegmentCount() uint16 {
+	return bd.Metadata
+}
+
+// UsesBigData reports whether value data of the provided size is stored
+// in big data segments in a hive of the provided minor format version
+func UsesBigData(minor uint32, size uint32) bool {
+	return minor >= BigDataMinorVersion && size > BigDataSegmentSize
+}
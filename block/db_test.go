@@ -43,3 +43,21 @@ func TestBigDataMarshaling(t *testing.T) {
 		t.Errorf("db records not equals: got|want\n%+v\n%+v", got, want)
 	}
 }
+
+func TestUsesBigData(t *testing.T) {
+	testCases := []struct {
+		Minor uint32
+		Size  uint32
+		Big   bool
+	}{
+		{3, BigDataSegmentSize + 1, false},
+		{4, BigDataSegmentSize, false},
+		{4, BigDataSegmentSize + 1, true},
+		{6, 0x100000, true},
+	}
+	for _, tc := range testCases {
+		if got, want := UsesBigData(tc.Minor, tc.Size), tc.Big; got != want {
+			t.Errorf("big data for 1.%d with size %d mismatch: got %v, want %v", tc.Minor, tc.Size, got, want)
+		}
+	}
+}
@@ -2,11 +2,19 @@ package block
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"sort"
 )
 
 const (
 	HBinHeaderSize = 32
+	// "hbin" read as a little endian uint32
+	HBinHeaderSignature = 0x6e696268
+)
+
+var (
+	ErrCellNotFound = errors.New("no cell found at offset")
 )
 
 type HBinHeader struct {
@@ -56,18 +64,60 @@ func (hbins *HBinData) marshal() ([]byte, error) {
 }
 
 func (hbins *HBinData) unmarshal(data []byte) error {
-	hb := &HBin{}
 	*hbins = make(HBinData, 0)
-	for start := int32(0); start < int32(len(data)); start += hb.HBinHeader.HBinSize {
+	for start := int32(0); start < int32(len(data)); {
+		hb := &HBin{}
+		if err := hb.HBinHeader.unmarshal(data[start:]); err != nil {
+			return err
+		}
+		if hb.HBinHeader.HBinSize < HBinHeaderSize || start+hb.HBinHeader.HBinSize > int32(len(data)) {
+			return fmt.Errorf("hbin at offset %#x has invalid size %d", start, hb.HBinHeader.HBinSize)
+		}
 		if err := hb.unmarshal(data[start : start+hb.HBinHeader.HBinSize]); err != nil {
 			return err
 		}
-		hb.HBinDataPtr = hbins
 		*hbins = append(*hbins, *hb)
+		start += hb.HBinHeader.HBinSize
 	}
+	hbins.relink()
 	return nil
 }
 
+// relink points every HBin and HCell back to their
+// parent structures in the current backing array
+func (hbins *HBinData) relink() {
+	for i := range *hbins {
+		hb := &(*hbins)[i]
+		hb.HBinDataPtr = hbins
+		for _, hc := range hb.Cells {
+			hc.setParentHBin(hb)
+		}
+	}
+}
+
+// CellAt returns the cell located at offset relative to the
+// start of hbins data, which is how cells reference each other
+// Lookup relies on hbins and cells being ordered by offset
+func (hbins *HBinData) CellAt(offset int32) (HCell, error) {
+	data := *hbins
+	i := sort.Search(len(data), func(i int) bool {
+		return data[i].HBinDataOffset+data[i].HBinSize > offset
+	})
+	if i == len(data) || data[i].HBinDataOffset > offset {
+		return nil, fmt.Errorf("%w %#x", ErrCellNotFound, offset)
+	}
+
+	cells := data[i].Cells
+	cellOffset := offset - data[i].HBinDataOffset
+	j := sort.Search(len(cells), func(j int) bool {
+		return cells[j].Offset() >= cellOffset
+	})
+	if j == len(cells) || cells[j].Offset() != cellOffset {
+		return nil, fmt.Errorf("%w %#x", ErrCellNotFound, offset)
+	}
+	return cells[j], nil
+}
+
 func (hbins *HBinData) Size() int32 {
 	return int32(len(*hbins))
 }
@@ -130,6 +180,9 @@ func (hb *HBin) unmarshal(data []byte) error {
 		if cellSize < 0 {
 			cellSize *= -1
 		}
+		if cellSize < HCellSizeLength || i+cellSize > hb.HBinHeader.HBinSize {
+			return fmt.Errorf("cell at hbin offset %#x has invalid size %d", i, cellSize)
+		}
 
 		cell, err := UnmarshalHCell(data[i : i+cellSize])
 		if err != nil {
@@ -1,6 +1,8 @@
 package block
 
 import (
+	"encoding/binary"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -136,3 +138,54 @@ func TestHBinMarshaling(t *testing.T) {
 		t.Errorf("hbin data not equal (got|want):\n%+v\n%+v", got, want)
 	}
 }
+
+func TestHBinDataCellAt(t *testing.T) {
+	// two hbins, each with a 16 byte data cell followed by a free cell
+	data := make([]byte, 2*BaseBlockSize)
+	for i := 0; i < 2; i++ {
+		hb := data[i*BaseBlockSize:]
+		copy(hb, "hbin")
+		binary.LittleEndian.PutUint32(hb[4:], uint32(i*BaseBlockSize))
+		binary.LittleEndian.PutUint32(hb[8:], BaseBlockSize)
+		binary.LittleEndian.PutUint32(hb[32:], 0xfffffff0)
+		hb[36] = byte(0x41 + i)
+		binary.LittleEndian.PutUint32(hb[48:], BaseBlockSize-48)
+	}
+
+	hbins := &HBinData{}
+	if err := hbins.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal hbins: %v", err)
+	}
+
+	testCases := []struct {
+		Offset int32
+		Size   int32
+		First  byte
+	}{
+		{0x20, 16, 0x41},
+		{0x30, BaseBlockSize - 48, 0},
+		{0x1020, 16, 0x42},
+		{0x1030, BaseBlockSize - 48, 0},
+	}
+	for _, tc := range testCases {
+		hc, err := hbins.CellAt(tc.Offset)
+		if err != nil {
+			t.Fatalf("failed to find cell at %#x: %v", tc.Offset, err)
+		}
+		if got, want := hc.AbsoluteOffset(), tc.Offset; got != want {
+			t.Errorf("absolute offset mismatch: got %#x, want %#x", got, want)
+		}
+		if got, want := hc.Size(), tc.Size; got != want {
+			t.Errorf("cell size at %#x mismatch: got %d, want %d", tc.Offset, got, want)
+		}
+		if got, want := hc.(*DataRecord).Data[0], tc.First; got != want {
+			t.Errorf("cell data at %#x mismatch: got %#x, want %#x", tc.Offset, got, want)
+		}
+	}
+
+	for _, offset := range []int32{0, 0x24, 0x1000, 0x2000, -1} {
+		if _, err := hbins.CellAt(offset); !errors.Is(err, ErrCellNotFound) {
+			t.Errorf("expected ErrCellNotFound at %#x, got %v", offset, err)
+		}
+	}
+}
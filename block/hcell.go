@@ -1,9 +1,14 @@
 package block
 
 import (
+	"errors"
 	"fmt"
 )
 
+var (
+	ErrCellTooSmall = errors.New("cell in hbin is too small")
+)
+
 const (
 	HCellSizeLength     = 4
 	HCellDataSize       = 8
@@ -15,8 +20,11 @@ const (
 
 type HCell interface {
 	RegistryBlock
+	AbsoluteOffset() int32
+	Allocated() bool
 	setParentHBin(hbin *HBin)
 	setOffset(offset int32)
+	cellData() *HCellData
 }
 
 type HCellData struct {
@@ -60,6 +68,10 @@ func (hcd *HCellData) setOffset(offset int32) {
 	hcd.ParentHBinOffset = offset
 }
 
+func (hcd *HCellData) cellData() *HCellData {
+	return hcd
+}
+
 func (hcd *HCellData) assertPayloadDataSize(data []byte) error {
 	if int32(len(data)) < hcd.Size() {
 		return fmt.Errorf("provided data of size %d, expected at least %d", len(data), hcd.Size())
@@ -67,6 +79,31 @@ func (hcd *HCellData) assertPayloadDataSize(data []byte) error {
 	return nil
 }
 
+// canFit returns an error if the cell is placed in an hbin and a
+// payload of the provided size, including the size field, would grow it
+func (hcd *HCellData) canFit(payload int) error {
+	if hcd.ParentHBin != nil && int32(payload) > hcd.Size() {
+		return fmt.Errorf("%w: payload of %d bytes exceeds cell of %d bytes", ErrCellTooSmall, payload, hcd.Size())
+	}
+	return nil
+}
+
+// fit sets padding so that the cell holds a payload of the provided
+// size, including the size field, the cell size is kept if payload
+// fits, otherwise it is grown to the next 8 byte boundary
+func (hcd *HCellData) fit(payload int) {
+	size := hcd.Size()
+	if int32(payload) > size {
+		size = int32(payload+7) &^ 7
+	}
+	hcd.Padding = make([]byte, size-int32(payload))
+	if hcd.BlockSize > 0 {
+		hcd.BlockSize = size
+	} else {
+		hcd.BlockSize = -size
+	}
+}
+
 func (hcd *HCellData) Size() int32 {
 	size := hcd.BlockSize
 	// if size is >0 then the cell is unallocated
@@ -76,10 +113,25 @@ func (hcd *HCellData) Size() int32 {
 	return size
 }
 
+// Allocated reports whether the cell is in use, unallocated
+// cells are stored with a positive size
+func (hcd *HCellData) Allocated() bool {
+	return hcd.BlockSize < 0
+}
+
 func (hcd *HCellData) Offset() int32 {
 	return hcd.ParentHBinOffset
 }
 
+// AbsoluteOffset returns the offset of this cell from
+// the start of hbins data, as referenced by other cells
+func (hcd *HCellData) AbsoluteOffset() int32 {
+	if hcd.ParentHBin == nil {
+		return hcd.ParentHBinOffset
+	}
+	return hcd.ParentHBin.HBinDataOffset + hcd.ParentHBinOffset
+}
+
 func (hcd *HCellData) Signature() string {
 	return fmt.Sprintf("%s", hcd.HCellSignature)
 }
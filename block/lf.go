@@ -35,3 +35,41 @@ func (fl *FastLeaf) unmarshal(data []byte) error {
 	fl.Padding = make([]byte, endPos)
 	return binaryBufferRead(reader, &fl.Padding)
 }
+
+// NameHint returns the first four characters of a key name
+// as stored in the name field of fast leaf elements
+// Characters that are not ASCII are stored as zero
+func NameHint(name string) [4]byte {
+	var hint [4]byte
+	i := 0
+	for _, c := range name {
+		if i == len(hint) {
+			break
+		}
+		if c < 0x80 {
+			hint[i] = byte(c)
+		}
+		i++
+	}
+	return hint
+}
+
+// NameHintMatches reports whether the name might be the one
+// described by hint, a case insensitive comparison is made
+// only for ASCII characters since the others are not stored
+func NameHintMatches(hint [4]byte, name string) bool {
+	nameHint := NameHint(UpcaseName(name))
+	for i := range hint {
+		h, n := hint[i], nameHint[i]
+		if h == 0 || n == 0 {
+			continue
+		}
+		if h >= 'a' && h <= 'z' {
+			h -= 'a' - 'A'
+		}
+		if h != n {
+			return false
+		}
+	}
+	return true
+}
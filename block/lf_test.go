@@ -59,3 +59,32 @@ func TestFastLeafMarshaling(t *testing.T) {
 		t.Errorf("fl records not equals: got|want\n%+v\n%+v", got, want)
 	}
 }
+
+func TestNameHint(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Hint    [4]byte
+		Matches []string
+		Differs []string
+	}{
+		{"Software", [4]byte{'S', 'o', 'f', 't'}, []string{"SOFTWARE", "software"}, []string{"System"}},
+		{"ab", [4]byte{'a', 'b', 0, 0}, []string{"AB", "abcd"}, []string{"ac"}},
+		{"été", [4]byte{0, 't', 0, 0}, []string{"ÉTÉ"}, []string{"ea"}},
+	}
+	for _, tc := range testCases {
+		hint := NameHint(tc.Name)
+		if got, want := hint, tc.Hint; got != want {
+			t.Errorf("hint of %q mismatch: got %v, want %v", tc.Name, got, want)
+		}
+		for _, name := range tc.Matches {
+			if !NameHintMatches(hint, name) {
+				t.Errorf("hint of %q should match %q", tc.Name, name)
+			}
+		}
+		for _, name := range tc.Differs {
+			if NameHintMatches(hint, name) {
+				t.Errorf("hint of %q should not match %q", tc.Name, name)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package block
 
 import (
 	"bytes"
+	"unicode/utf16"
 )
 
 type HashLeaf struct {
@@ -35,3 +36,13 @@ func (hl *HashLeaf) unmarshal(data []byte) error {
 	hl.Padding = make([]byte, endPos)
 	return binaryBufferRead(reader, &hl.Padding)
 }
+
+// NameHash calculates the hash of a key name
+// as stored in the name field of hash leaf elements
+func NameHash(name string) uint32 {
+	var hash uint32
+	for _, c := range utf16.Encode([]rune(UpcaseName(name))) {
+		hash = 37*hash + uint32(c)
+	}
+	return hash
+}
@@ -61,3 +61,22 @@ func TestHashLeafMarshaling(t *testing.T) {
 		t.Errorf("hl records not equals: got|want\n%+v\n%+v", got, want)
 	}
 }
+
+func TestNameHash(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Hash uint32
+	}{
+		{"", 0},
+		{"A", 0x41},
+		{"ab", 0x41*37 + 0x42},
+		{"Software", 0xe9fe1463},
+		{"SOFTWARE", 0xe9fe1463},
+		{"é", 0xc9},
+	}
+	for _, tc := range testCases {
+		if got, want := NameHash(tc.Name), tc.Hash; got != want {
+			t.Errorf("hash of %q mismatch: got %#x, want %#x", tc.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// Size of the partial base block copy at the start of a log file
+	LogBaseBlockSize = 512
+	// Log entries and dirty pages are aligned to log sectors
+	LogSectorSize      = 512
+	LogEntryHeaderSize = 40
+	DirtyPageRefSize   = 8
+	LogEntrySignature  = "HvLE"
+	// Each dirty vector bit marks a sector of hbins data
+	DirtyVectorSignature = "DIRT"
+	logEntryHash2Length  = 32
+)
+
+type LogEntryHeader struct {
+	// "HvLE"
+	LogEntrySignature [4]byte
+	// Size of this log entry, multiple of 512
+	EntrySize uint32
+	// Partial copy of base block flags
+	Flags uint32
+	// Sequence number of this log entry
+	Sequence uint32
+	// Copy of base block HBinSize after this entry is applied
+	HBinSize        uint32
+	DirtyPagesCount uint32
+	// Marvin32 hash of the entry data following the header
+	Hash1 uint64
+	// Marvin32 hash of the first 32 bytes of the entry
+	Hash2 uint64
+}
+
+// DirtyPageRef locates a dirty page in hbins data
+type DirtyPageRef struct {
+	// Offset of the page from the start of hbins data
+	Offset uint32
+	// Size of the page in bytes
+	Size uint32
+}
+
+// LogEntry is a single HvLE entry of a new format transaction log
+type LogEntry struct {
+	LogEntryHeader
+	Refs []DirtyPageRef
+	// Dirty pages data in the same order as references
+	Pages [][]byte
+	// Bytes between the last page and the end of the entry
+	Padding []byte
+}
+
+func (le *LogEntry) marshal() ([]byte, error) {
+	data, err := binaryWriteAll(le.LogEntryHeader, le.Refs)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, append(bytes.Join(le.Pages, nil), le.Padding...)...), nil
+}
+
+func (le *LogEntry) unmarshal(data []byte) error {
+	if len(data) < LogEntryHeaderSize {
+		return fmt.Errorf("log entry of size %d, expected at least %d", len(data), LogEntryHeaderSize)
+	}
+	if err := binaryRead(data, &le.LogEntryHeader); err != nil {
+		return err
+	}
+	if string(le.LogEntrySignature[:]) != LogEntrySignature {
+		return fmt.Errorf("log entry signature %q, expected %q", le.LogEntrySignature, LogEntrySignature)
+	}
+	if le.EntrySize < LogEntryHeaderSize || le.EntrySize%LogSectorSize != 0 || int(le.EntrySize) > len(data) {
+		return fmt.Errorf("log entry has invalid size %d", le.EntrySize)
+	}
+	data = data[:le.EntrySize]
+
+	start := uint64(LogEntryHeaderSize) + uint64(le.DirtyPagesCount)*DirtyPageRefSize
+	if start > uint64(len(data)) {
+		return fmt.Errorf("log entry with %d dirty pages is out of bounds", le.DirtyPagesCount)
+	}
+	le.Refs = make([]DirtyPageRef, le.DirtyPagesCount)
+	if err := binaryRead(data[LogEntryHeaderSize:], le.Refs); err != nil {
+		return err
+	}
+
+	le.Pages = make([][]byte, 0, len(le.Refs))
+	for _, ref := range le.Refs {
+		end := start + uint64(ref.Size)
+		if end > uint64(len(data)) {
+			return fmt.Errorf("dirty page at offset %#x of size %d is out of bounds", ref.Offset, ref.Size)
+		}
+		le.Pages = append(le.Pages, data[start:end])
+		start = end
+	}
+	le.Padding = data[start:]
+	return nil
+}
+
+// Verify checks both hashes stored in the log entry header
+func (le *LogEntry) Verify() bool {
+	hash1, hash2, err := le.hashes()
+	return err == nil && le.Hash1 == hash1 && le.Hash2 == hash2
+}
+
+// UpdateHashes recomputes both hashes stored in the log entry header
+func (le *LogEntry) UpdateHashes() error {
+	hash1, _, err := le.hashes()
+	if err != nil {
+		return err
+	}
+	le.Hash1 = hash1
+	_, le.Hash2, err = le.hashes()
+	return err
+}
+
+func (le *LogEntry) hashes() (uint64, uint64, error) {
+	data, err := le.marshal()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(data) != int(le.EntrySize) {
+		return 0, 0, fmt.Errorf("log entry data size %d does not match entry size %d", len(data), le.EntrySize)
+	}
+	return Marvin32(data[LogEntryHeaderSize:], LogEntryHashSeed), Marvin32(data[:logEntryHash2Length], LogEntryHashSeed), nil
+}
+
+func (le *LogEntry) Size() int32 {
+	return int32(le.EntrySize)
+}
+
+// Offset of a log entry is not known, entries
+// are located by walking the log file
+func (le *LogEntry) Offset() int32 {
+	return 0
+}
+
+func (le *LogEntry) Signature() string {
+	return string(le.LogEntrySignature[:])
+}
+
+// DirtyVector is the bitmap of dirty hbins data sectors stored in
+// legacy transaction logs, dirty sectors follow the vector aligned
+// to the next log sector in the order of set bits
+type DirtyVector struct {
+	// "DIRT"
+	DirtyVectorSignature [4]byte
+	// One bit per hbins data sector, least significant bit first
+	Bitmap []byte
+}
+
+// NewDirtyVector returns an empty dirty vector for hbins data of the
+// provided size, unmarshaling reads a bitmap of the same length
+func NewDirtyVector(hbinSize uint32) *DirtyVector {
+	sectors := (hbinSize + LogSectorSize - 1) / LogSectorSize
+	return &DirtyVector{
+		DirtyVectorSignature: [4]byte{'D', 'I', 'R', 'T'},
+		Bitmap:               make([]byte, (sectors+7)/8),
+	}
+}
+
+func (dv *DirtyVector) marshal() ([]byte, error) {
+	return binaryWriteAll(dv.DirtyVectorSignature, dv.Bitmap)
+}
+
+func (dv *DirtyVector) unmarshal(data []byte) error {
+	if len(data) < int(dv.Size()) {
+		return fmt.Errorf("dirty vector of size %d, expected at least %d", len(data), dv.Size())
+	}
+	if string(data[:4]) != DirtyVectorSignature {
+		return fmt.Errorf("dirty vector signature %q, expected %q", data[:4], DirtyVectorSignature)
+	}
+	copy(dv.DirtyVectorSignature[:], data)
+	copy(dv.Bitmap, data[4:])
+	return nil
+}
+
+// DirtyPages returns references to runs of dirty sectors
+func (dv *DirtyVector) DirtyPages() []DirtyPageRef {
+	var refs []DirtyPageRef
+	for i := 0; i < len(dv.Bitmap)*8; i++ {
+		if dv.Bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		offset := uint32(i * LogSectorSize)
+		if n := len(refs); n > 0 && refs[n-1].Offset+refs[n-1].Size == offset {
+			refs[n-1].Size += LogSectorSize
+		} else {
+			refs = append(refs, DirtyPageRef{Offset: offset, Size: LogSectorSize})
+		}
+	}
+	return refs
+}
+
+// SetDirty marks hbins data sectors in the provided range as dirty
+func (dv *DirtyVector) SetDirty(offset, size uint32) {
+	for i := offset / LogSectorSize; i < (offset+size+LogSectorSize-1)/LogSectorSize && int(i/8) < len(dv.Bitmap); i++ {
+		dv.Bitmap[i/8] |= 1 << (i % 8)
+	}
+}
+
+func (dv *DirtyVector) Size() int32 {
+	return int32(len(dv.DirtyVectorSignature) + len(dv.Bitmap))
+}
+
+func (dv *DirtyVector) Offset() int32 {
+	return LogBaseBlockSize
+}
+
+func (dv *DirtyVector) Signature() string {
+	return string(dv.DirtyVectorSignature[:])
+}
@@ -0,0 +1,84 @@
+package block
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogEntryMarshalCycle(t *testing.T) {
+	le := &LogEntry{
+		LogEntryHeader: LogEntryHeader{
+			LogEntrySignature: [4]byte{'H', 'v', 'L', 'E'},
+			EntrySize:         2 * LogSectorSize,
+			Sequence:          7,
+			HBinSize:          HBinAlignment,
+			DirtyPagesCount:   1,
+		},
+		Refs:    []DirtyPageRef{{Offset: LogSectorSize, Size: LogSectorSize}},
+		Pages:   [][]byte{make([]byte, LogSectorSize)},
+		Padding: make([]byte, LogSectorSize-LogEntryHeaderSize-DirtyPageRefSize),
+	}
+	le.Pages[0][0] = 0x41
+	if err := le.UpdateHashes(); err != nil {
+		t.Fatalf("failed to update hashes: %v", err)
+	}
+
+	data, err := le.marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal log entry: %v", err)
+	}
+	parsed := &LogEntry{}
+	if err := parsed.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal log entry: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, le) {
+		t.Errorf("log entries not equal (got|want):\n%+v\n%+v", parsed, le)
+	}
+	if !parsed.Verify() {
+		t.Errorf("log entry hashes should be valid")
+	}
+
+	parsed.Pages[0][1] = 0x42
+	if parsed.Verify() {
+		t.Errorf("modified log entry hashes should be invalid")
+	}
+
+	data[4] = 0x10
+	if err := parsed.unmarshal(data); err == nil {
+		t.Errorf("expected error for misaligned entry size")
+	}
+}
+
+func TestDirtyVector(t *testing.T) {
+	dv := NewDirtyVector(8 * HBinAlignment)
+	if got, want := len(dv.Bitmap), 8; got != want {
+		t.Fatalf("bitmap length mismatch: got %d, want %d", got, want)
+	}
+	dv.SetDirty(0, 1)
+	dv.SetDirty(2*LogSectorSize, 2*LogSectorSize)
+	dv.SetDirty(7*LogSectorSize+1, LogSectorSize)
+
+	expect := []DirtyPageRef{
+		{Offset: 0, Size: LogSectorSize},
+		{Offset: 2 * LogSectorSize, Size: 2 * LogSectorSize},
+		{Offset: 7 * LogSectorSize, Size: 2 * LogSectorSize},
+	}
+	if got := dv.DirtyPages(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("dirty pages mismatch (got|want):\n%+v\n%+v", got, expect)
+	}
+
+	data, err := dv.marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal dirty vector: %v", err)
+	}
+	parsed := NewDirtyVector(8 * HBinAlignment)
+	if err := parsed.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal dirty vector: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, dv) {
+		t.Errorf("dirty vectors not equal (got|want):\n%+v\n%+v", parsed, dv)
+	}
+	if err := parsed.unmarshal(data[:4]); err == nil {
+		t.Errorf("expected error for truncated dirty vector")
+	}
+}
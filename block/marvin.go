@@ -0,0 +1,48 @@
+package block
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	// Seed used for Marvin32 hashes of transaction log entries
+	LogEntryHashSeed = 0x82ef4d887a4e55c5
+)
+
+// Marvin32 computes the Marvin32 hash of data using the provided seed
+func Marvin32(data []byte, seed uint64) uint64 {
+	p0, p1 := uint32(seed), uint32(seed>>32)
+
+	for ; len(data) >= 4; data = data[4:] {
+		p0 += binary.LittleEndian.Uint32(data)
+		p0, p1 = marvinBlock(p0, p1)
+	}
+
+	final := uint32(0x80)
+	switch len(data) {
+	case 1:
+		final = 0x8000 | uint32(data[0])
+	case 2:
+		final = 0x800000 | uint32(binary.LittleEndian.Uint16(data))
+	case 3:
+		final = 0x80000000 | uint32(data[2])<<16 | uint32(binary.LittleEndian.Uint16(data))
+	}
+	p0 += final
+	p0, p1 = marvinBlock(p0, p1)
+	p0, p1 = marvinBlock(p0, p1)
+
+	return uint64(p1)<<32 | uint64(p0)
+}
+
+func marvinBlock(p0, p1 uint32) (uint32, uint32) {
+	p1 ^= p0
+	p0 = bits.RotateLeft32(p0, 20)
+	p0 += p1
+	p1 = bits.RotateLeft32(p1, 9)
+	p1 ^= p0
+	p0 = bits.RotateLeft32(p0, 27)
+	p0 += p1
+	p1 = bits.RotateLeft32(p1, 19)
+	return p0, p1
+}
@@ -0,0 +1,29 @@
+package block
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMarvin32(t *testing.T) {
+	// test vectors from the .NET runtime Marvin implementation
+	const seed = 0x004fb61a001bdbcc
+	testCases := []struct {
+		Data string
+		Hash uint64
+	}{
+		{"", 0x30ed35c100cd3c7d},
+		{"af", 0x48e73fc77d75ddc1},
+		{"e70f", 0xb5f6e1fc485dbff8},
+		{"37f495", 0xf0b07c789b8cf7e8},
+		{"8642dc59", 0x7008f2e87e9cf556},
+		{"153fb79826", 0xe6c08c6da2afa997},
+		{"0932e6246c47", 0x6f04bf1a5ea24060},
+	}
+	for _, tc := range testCases {
+		data, _ := hex.DecodeString(tc.Data)
+		if got, want := Marvin32(data, seed), tc.Hash; got != want {
+			t.Errorf("hash of %s mismatch: got %#x, want %#x", tc.Data, got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package block
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// UpcaseName converts a key or value name to upper case the way
+// Windows does when comparing names, characters are converted
+// one by one and only within the basic multilingual plane
+func UpcaseName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0xffff {
+			return r
+		}
+		if u := unicode.ToUpper(r); u <= 0xffff {
+			return u
+		}
+		return r
+	}, name)
+}
+
+// EqualNames reports whether two key or value names
+// are considered equal by Windows
+func EqualNames(a, b string) bool {
+	return UpcaseName(a) == UpcaseName(b)
+}
+
+// DecodeUTF16 decodes UTF-16LE data in which names and string
+// values are stored, a trailing odd byte is ignored
+func DecodeUTF16(data []byte) string {
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// EncodeUTF16 encodes the string to UTF-16LE without a terminator
+func EncodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	data := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(data[i*2:], c)
+	}
+	return data
+}
+
+// DecodeName decodes a key or value name stored either
+// in compressed Latin-1 form or in UTF-16LE
+func DecodeName(raw []byte, compressed bool) string {
+	if !compressed {
+		return DecodeUTF16(raw)
+	}
+
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// EncodeName encodes a key or value name in compressed Latin-1
+// form when all characters allow it, otherwise in UTF-16LE
+func EncodeName(name string) ([]byte, bool) {
+	raw := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xff {
+			return EncodeUTF16(name), false
+		}
+		raw = append(raw, byte(r))
+	}
+	return raw, true
+}
@@ -0,0 +1,77 @@
+package block
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpcaseName(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Upcase string
+	}{
+		{"", ""},
+		{"ControlSet001", "CONTROLSET001"},
+		{"Größe", "GRÖßE"},
+		{"ÿ", "Ÿ"},
+		{"\U00010428", "\U00010428"},
+	}
+	for _, tc := range testCases {
+		if got, want := UpcaseName(tc.Name), tc.Upcase; got != want {
+			t.Errorf("upcase of %q mismatch: got %q, want %q", tc.Name, got, want)
+		}
+	}
+
+	if !EqualNames("services", "SERVICES") {
+		t.Errorf("names should be equal")
+	}
+	if EqualNames("services", "service") {
+		t.Errorf("names should not be equal")
+	}
+}
+
+func TestUTF16(t *testing.T) {
+	testCases := []struct {
+		Data []byte
+		Text string
+	}{
+		{[]byte{}, ""},
+		{[]byte{0x41, 0x00, 0x42, 0x00}, "AB"},
+		{[]byte{0xe9, 0x00, 0x3d, 0xd8, 0x00, 0xde}, "é\U0001f600"},
+	}
+	for _, tc := range testCases {
+		if got, want := DecodeUTF16(tc.Data), tc.Text; got != want {
+			t.Errorf("decode mismatch: got %q, want %q", got, want)
+		}
+		if got, want := EncodeUTF16(tc.Text), tc.Data; !reflect.DeepEqual(got, want) {
+			t.Errorf("encode mismatch: got %v, want %v", got, want)
+		}
+	}
+
+	if got, want := DecodeUTF16([]byte{0x41, 0x00, 0x42}), "A"; got != want {
+		t.Errorf("decode with odd length mismatch: got %q, want %q", got, want)
+	}
+}
+
+func TestNameEncoding(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Raw        []byte
+		Compressed bool
+	}{
+		{"", []byte{}, true},
+		{"Select", []byte("Select"), true},
+		{"Größe", []byte{0x47, 0x72, 0xf6, 0xdf, 0x65}, true},
+		{"日本", []byte{0xe5, 0x65, 0x2c, 0x67}, false},
+		{"aÿĀ", []byte{0x61, 0x00, 0xff, 0x00, 0x00, 0x01}, false},
+	}
+	for _, tc := range testCases {
+		raw, compressed := EncodeName(tc.Name)
+		if !reflect.DeepEqual(raw, tc.Raw) || compressed != tc.Compressed {
+			t.Errorf("encoded %q mismatch: got %v %v, want %v %v", tc.Name, raw, compressed, tc.Raw, tc.Compressed)
+		}
+		if got, want := DecodeName(tc.Raw, tc.Compressed), tc.Name; got != want {
+			t.Errorf("decoded name mismatch: got %q, want %q", got, want)
+		}
+	}
+}
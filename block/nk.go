@@ -4,10 +4,11 @@ import (
 	"fmt"
 )
 
+// KeyNodeFlag is stored in the Metadata field of key nodes
 type KeyNodeFlag uint16
 
 const (
-	KeyVolatile KeyNodeFlag = iota << 1
+	KeyVolatile KeyNodeFlag = 1 << iota
 	KeyHiveExit
 	KeyHiveEntry
 	KeyNoDelete
@@ -19,6 +20,104 @@ const (
 	KeyVirtualStore
 )
 
+var keyNodeFlagNames = []flagName{
+	{uint64(KeyVolatile), "KEY_VOLATILE"},
+	{uint64(KeyHiveExit), "KEY_HIVE_EXIT"},
+	{uint64(KeyHiveEntry), "KEY_HIVE_ENTRY"},
+	{uint64(KeyNoDelete), "KEY_NO_DELETE"},
+	{uint64(KeySymLink), "KEY_SYM_LINK"},
+	{uint64(KeyCompName), "KEY_COMP_NAME"},
+	{uint64(KeyPredefHandle), "KEY_PREDEF_HANDLE"},
+	{uint64(KeyVirtMirrored), "KEY_VIRT_MIRRORED"},
+	{uint64(KeyVirtTarget), "KEY_VIRT_TARGET"},
+	{uint64(KeyVirtualStore), "KEY_VIRTUAL_STORE"},
+}
+
+func (f KeyNodeFlag) Has(flag KeyNodeFlag) bool {
+	return f&flag == flag
+}
+
+func (f KeyNodeFlag) String() string {
+	return flagString(uint64(f), keyNodeFlagNames)
+}
+
+// KeyAccessFlag is stored in the AccessBits field of key nodes
+type KeyAccessFlag uint32
+
+const (
+	// Key was accessed before the registry was initialized
+	KeyAccessedBeforeInit KeyAccessFlag = 1 << iota
+	// Key was accessed after the registry was initialized
+	KeyAccessedAfterInit
+)
+
+var keyAccessFlagNames = []flagName{
+	{uint64(KeyAccessedBeforeInit), "ACCESSED_BEFORE_INIT"},
+	{uint64(KeyAccessedAfterInit), "ACCESSED_AFTER_INIT"},
+}
+
+func (f KeyAccessFlag) Has(flag KeyAccessFlag) bool {
+	return f&flag == flag
+}
+
+func (f KeyAccessFlag) String() string {
+	return flagString(uint64(f), keyAccessFlagNames)
+}
+
+// KeyVirtControlFlag is stored in bits 16-19 of the
+// LSubkeyNameLength field of key nodes
+type KeyVirtControlFlag uint8
+
+const (
+	_ KeyVirtControlFlag = 1 << iota
+	KeyDontVirtualize
+	KeyDontSilentFail
+	KeyRecurseFlag
+)
+
+var keyVirtControlFlagNames = []flagName{
+	{uint64(KeyDontVirtualize), "REG_KEY_DONT_VIRTUALIZE"},
+	{uint64(KeyDontSilentFail), "REG_KEY_DONT_SILENT_FAIL"},
+	{uint64(KeyRecurseFlag), "REG_KEY_RECURSE_FLAG"},
+}
+
+func (f KeyVirtControlFlag) Has(flag KeyVirtControlFlag) bool {
+	return f&flag == flag
+}
+
+func (f KeyVirtControlFlag) String() string {
+	return flagString(uint64(f), keyVirtControlFlagNames)
+}
+
+// KeyUserFlag is stored in bits 20-23 of the LSubkeyNameLength
+// field of key nodes, older formats used bits 12-15 of key node flags
+type KeyUserFlag uint8
+
+const (
+	// Key was created through the 32-bit registry view
+	KeyUser32Bit KeyUserFlag = 1 << iota
+	// Key was created by registry reflection
+	KeyUserReflected
+	// Registry reflection is disabled for this key
+	KeyUserDisableReflection
+	KeyUserExtended
+)
+
+var keyUserFlagNames = []flagName{
+	{uint64(KeyUser32Bit), "KEY_USER_32BIT"},
+	{uint64(KeyUserReflected), "KEY_USER_REFLECTED"},
+	{uint64(KeyUserDisableReflection), "KEY_USER_DISABLE_REFLECTION"},
+	{uint64(KeyUserExtended), "KEY_USER_EXTENDED"},
+}
+
+func (f KeyUserFlag) Has(flag KeyUserFlag) bool {
+	return f&flag == flag
+}
+
+func (f KeyUserFlag) String() string {
+	return flagString(uint64(f), keyUserFlagNames)
+}
+
 type KeyNodeData struct {
 	LastWTimestamp         uint64
 	AccessBits             uint32
@@ -74,11 +173,66 @@ func (kn *KeyNode) unmarshal(data []byte) error {
 	}
 
 	const keyNodeDataEnd = HCellDataSize + KeyNodeDataSize
-	dEnd := keyNodeDataEnd + int(kn.KeyNameLength)
-	if dEnd > len(data) {
+	dEnd := keyNodeDataEnd + int(uint16(kn.KeyNameLength))
+	if dEnd > int(kn.Size()) {
 		return fmt.Errorf("key name length out of bounds: name %d len %d", kn.KeyNameLength, len(data))
 	}
 	kn.KeyName = data[keyNodeDataEnd:dEnd]
 	kn.Padding = data[dEnd:kn.Size()]
 	return nil
 }
+
+// Name decodes the key name which is stored either in
+// Latin-1 when KeyCompName flag is set or in UTF-16LE
+func (kn *KeyNode) Name() string {
+	return DecodeName(kn.KeyName, kn.Flags().Has(KeyCompName))
+}
+
+// SetName encodes the key name, in compressed form if possible,
+// and updates the name length and flags, cell size is kept if the
+// name fits, otherwise the cell grows unless it is placed in an hbin
+func (kn *KeyNode) SetName(name string) error {
+	raw, compressed := EncodeName(name)
+	if err := kn.canFit(HCellDataSize + KeyNodeDataSize + len(raw)); err != nil {
+		return err
+	}
+	if compressed {
+		kn.Metadata |= uint16(KeyCompName)
+	} else {
+		kn.Metadata &^= uint16(KeyCompName)
+	}
+	kn.KeyName = raw
+	kn.KeyNameLength = int16(len(raw))
+	kn.fit(HCellDataSize + KeyNodeDataSize + len(raw))
+	return nil
+}
+
+func (kn *KeyNode) Flags() KeyNodeFlag {
+	return KeyNodeFlag(kn.Metadata)
+}
+
+func (kn *KeyNode) SetFlags(flags KeyNodeFlag) {
+	kn.Metadata = uint16(flags)
+}
+
+func (kn *KeyNode) AccessFlags() KeyAccessFlag {
+	return KeyAccessFlag(kn.AccessBits)
+}
+
+// MaxSubkeyNameLength returns the largest subkey name length
+// stored in the lower 16 bits of LSubkeyNameLength
+func (kn *KeyNode) MaxSubkeyNameLength() uint16 {
+	return uint16(kn.LSubkeyNameLength)
+}
+
+func (kn *KeyNode) SetMaxSubkeyNameLength(length uint16) {
+	kn.LSubkeyNameLength = int32(uint32(kn.LSubkeyNameLength)&0xffff0000 | uint32(length))
+}
+
+func (kn *KeyNode) VirtControlFlags() KeyVirtControlFlag {
+	return KeyVirtControlFlag(uint32(kn.LSubkeyNameLength) >> 16 & 0xf)
+}
+
+func (kn *KeyNode) UserFlags() KeyUserFlag {
+	return KeyUserFlag(uint32(kn.LSubkeyNameLength) >> 20 & 0xf)
+}
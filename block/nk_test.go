@@ -1,6 +1,7 @@
 package block
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -98,3 +99,119 @@ func TestKeyNodeMarshaling(t *testing.T) {
 		t.Errorf("nk records not equals: got|want\n%+v\n%+v", got, want)
 	}
 }
+
+func TestKeyNodeName(t *testing.T) {
+	kn := &KeyNode{HCellData: HCellData{HCellSignature: [2]byte{'n', 'k'}}}
+	testCases := []struct {
+		Name      string
+		BlockSize int32
+		Flags     uint16
+	}{
+		// name fits in 88 bytes of allocated cell
+		{"Größe", -88, uint16(KeyCompName)},
+		{"日本", -88, 0},
+		{"AVeryLongKeyNameThatGrowsTheCell", -112, uint16(KeyCompName)},
+		{"Short", -112, uint16(KeyCompName)},
+	}
+	for _, tc := range testCases {
+		if err := kn.SetName(tc.Name); err != nil {
+			t.Fatalf("failed to set key name %q: %v", tc.Name, err)
+		}
+		if got, want := kn.Name(), tc.Name; got != want {
+			t.Errorf("key name mismatch: got %q, want %q", got, want)
+		}
+		if got, want := kn.BlockSize, tc.BlockSize; got != want {
+			t.Errorf("block size for %q mismatch: got %d, want %d", tc.Name, got, want)
+		}
+		if got, want := kn.Metadata&uint16(KeyCompName), tc.Flags; got != want {
+			t.Errorf("compressed flag for %q mismatch: got %#x, want %#x", tc.Name, got, want)
+		}
+
+		data, err := kn.marshal()
+		if err != nil {
+			t.Fatalf("failed marshaling nk record %v", err)
+		}
+		if got, want := int32(len(data)), kn.Size(); got != want {
+			t.Errorf("marshaled size mismatch for %q: got %d, want %d", tc.Name, got, want)
+		}
+
+		parsed := &KeyNode{}
+		if err := parsed.unmarshal(data); err != nil {
+			t.Fatalf("failed unmarshaling nk record %v", err)
+		}
+		if got, want := parsed.Name(), tc.Name; got != want {
+			t.Errorf("unmarshaled key name mismatch: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestKeyNodeNameInHBin(t *testing.T) {
+	kn := &KeyNode{HCellData: HCellData{HCellSignature: [2]byte{'n', 'k'}}}
+	if err := kn.SetName("Short"); err != nil {
+		t.Fatalf("failed to set key name: %v", err)
+	}
+	kn.setParentHBin(&HBin{})
+
+	if err := kn.SetName("AVeryLongKeyNameThatGrowsTheCell"); !errors.Is(err, ErrCellTooSmall) {
+		t.Errorf("expected ErrCellTooSmall growing a cell in hbin, got %v", err)
+	}
+	if got, want := kn.Name(), "Short"; got != want {
+		t.Errorf("failed rename should keep the name: got %q, want %q", got, want)
+	}
+	if err := kn.SetName("Tiny"); err != nil || kn.BlockSize != -88 {
+		t.Errorf("shorter name should fit the cell, got size %d, %v", kn.BlockSize, err)
+	}
+}
+
+func TestKeyNodeFlags(t *testing.T) {
+	kn := &KeyNode{
+		HCellData: HCellData{Metadata: 0x2c},
+		KeyNodeData: KeyNodeData{
+			AccessBits:        0x2,
+			LSubkeyNameLength: 0x00140020,
+		},
+	}
+
+	flags := kn.Flags()
+	for _, flag := range []KeyNodeFlag{KeyHiveEntry, KeyNoDelete, KeyCompName} {
+		if !flags.Has(flag) {
+			t.Errorf("flag %s should be set in %s", flag, flags)
+		}
+	}
+	for _, flag := range []KeyNodeFlag{KeyVolatile, KeySymLink, KeyHiveEntry | KeyVolatile} {
+		if flags.Has(flag) {
+			t.Errorf("flag %s should not be set in %s", flag, flags)
+		}
+	}
+	if got, want := flags.String(), "KEY_HIVE_ENTRY|KEY_NO_DELETE|KEY_COMP_NAME"; got != want {
+		t.Errorf("flags string mismatch: got %s, want %s", got, want)
+	}
+	if got, want := (KeyVolatile | 0x4000).String(), "KEY_VOLATILE|0x4000"; got != want {
+		t.Errorf("flags string mismatch: got %s, want %s", got, want)
+	}
+	if got, want := KeyNodeFlag(0).String(), "0"; got != want {
+		t.Errorf("flags string mismatch: got %s, want %s", got, want)
+	}
+
+	if got, want := kn.AccessFlags().String(), "ACCESSED_AFTER_INIT"; got != want {
+		t.Errorf("access flags mismatch: got %s, want %s", got, want)
+	}
+	if got, want := kn.MaxSubkeyNameLength(), uint16(0x20); got != want {
+		t.Errorf("max subkey name length mismatch: got %#x, want %#x", got, want)
+	}
+	if got, want := kn.VirtControlFlags().String(), "REG_KEY_DONT_SILENT_FAIL"; got != want {
+		t.Errorf("virtualization control flags mismatch: got %s, want %s", got, want)
+	}
+	if got, want := kn.UserFlags().String(), "KEY_USER_32BIT"; got != want {
+		t.Errorf("user flags mismatch: got %s, want %s", got, want)
+	}
+
+	kn.SetMaxSubkeyNameLength(0x1234)
+	if got, want := kn.LSubkeyNameLength, int32(0x00141234); got != want {
+		t.Errorf("subkey name length field mismatch: got %#x, want %#x", got, want)
+	}
+	kn.SetFlags(KeyVolatile)
+	if got, want := kn.Metadata, uint16(1); got != want {
+		t.Errorf("flags field mismatch: got %#x, want %#x", got, want)
+	}
+}
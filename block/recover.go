@@ -0,0 +1,123 @@
+package block
+
+import (
+	"encoding/binary"
+)
+
+// DeletedCells scans unallocated cells for key node, key value and key
+// security records starting at every 8 byte boundary, so records merged
+// into a larger unallocated cell are found as well. Records are parsed
+// from a copy of unallocated space and are not added to hbin cells,
+// their offsets point to the location where they were found
+func (hbins *HBinData) DeletedCells() []HCell {
+	var cells []HCell
+	for i := range *hbins {
+		hb := &(*hbins)[i]
+		for _, free := range hb.Cells {
+			if free.Allocated() {
+				continue
+			}
+			content, err := cellContent(free)
+			if err != nil {
+				continue
+			}
+			raw := make([]byte, HCellSizeLength, free.Size())
+			raw = append(raw, content...)
+			binary.LittleEndian.PutUint32(raw, uint32(free.Size()))
+
+			for p := 0; p+HCellDataSize <= len(raw); {
+				hc := parseDeletedRecord(raw[p:], p == 0)
+				if hc == nil {
+					p += HCellAlignment
+					continue
+				}
+				hc.setParentHBin(hb)
+				hc.setOffset(free.Offset() + int32(p))
+				cells = append(cells, hc)
+				p += int(recordLength(hc))
+			}
+		}
+	}
+	return cells
+}
+
+// ParseFragment parses a key node, key value or key security record
+// starting at data, e.g. a fragment of an old record left in cell
+// slack, the record is limited to data length and returned only if
+// it passes basic plausibility checks
+func ParseFragment(data []byte) HCell {
+	if len(data) < HCellDataSize {
+		return nil
+	}
+	return parseDeletedRecord(data, false)
+}
+
+// parseDeletedRecord parses a record of a known type at the start of
+// data, the record size is limited to available data and the record
+// is returned only if it passes basic plausibility checks
+func parseDeletedRecord(data []byte, whole bool) HCell {
+	switch string(data[4:6]) {
+	case "nk", "vk", "sk":
+	default:
+		return nil
+	}
+
+	size := int32(binary.LittleEndian.Uint32(data))
+	if size < 0 {
+		size = -size
+	}
+	if whole || size > int32(len(data)) {
+		size = int32(len(data))
+	}
+	size &^= HCellAlignment - 1
+	if size < HCellDataSize {
+		return nil
+	}
+
+	record := append([]byte{}, data[:size]...)
+	binary.LittleEndian.PutUint32(record, uint32(size))
+	hc, err := UnmarshalHCell(record)
+	if err != nil || !plausibleRecord(hc) {
+		return nil
+	}
+	return hc
+}
+
+func plausibleRecord(hc HCell) bool {
+	switch cell := hc.(type) {
+	case *KeyNode:
+		return cell.KeyNameLength > 0 && cell.SubkeysCount >= 0 && cell.KeyValuesCount >= 0 &&
+			plausibleOffset(cell.Parent) && plausibleOffset(cell.KeySecurityOffset) &&
+			plausibleOffset(cell.SubkeysListOffset) && plausibleOffset(cell.KeyValuesListOffset)
+	case *KeyValue:
+		return cell.IsDataInline() || cell.DataLength() == 0 || plausibleOffset(cell.DataOffset)
+	case *KeySecurity:
+		_, err := cell.Descriptor()
+		return err == nil
+	}
+	return false
+}
+
+// plausibleOffset reports whether the offset can reference a cell,
+// cells are aligned to 8 bytes and -1 marks a missing reference
+func plausibleOffset(offset int32) bool {
+	return offset == -1 || (offset >= 0 && offset%HCellAlignment == 0)
+}
+
+// recordLength returns the aligned length of record fields and name or
+// descriptor, the cell size of a record found at the start of an
+// unallocated cell includes cells merged into it after it was freed
+func recordLength(hc HCell) int32 {
+	var length int32
+	switch cell := hc.(type) {
+	case *KeyNode:
+		length = HCellDataSize + KeyNodeDataSize + int32(cell.KeyNameLength)
+	case *KeyValue:
+		length = HCellDataSize + KeyValueDataSize + int32(cell.Metadata)
+	case *KeySecurity:
+		length = HCellDataSize + KeySecurityDataSize + int32(cell.SecDescriptorSize)
+	default:
+		return hc.Size()
+	}
+	return alignCellSize(length)
+}
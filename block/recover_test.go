@@ -0,0 +1,64 @@
+package block
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDeletedCells(t *testing.T) {
+	kn := &KeyNode{HCellData: HCellData{HCellSignature: [2]byte{'n', 'k'}}}
+	kv := &KeyValue{HCellData: HCellData{HCellSignature: [2]byte{'v', 'k'}}}
+	if err := kn.SetName("Deleted"); err != nil {
+		t.Fatalf("failed to set key name: %v", err)
+	}
+	if err := kv.SetName("Value"); err != nil {
+		t.Fatalf("failed to set value name: %v", err)
+	}
+
+	// single unallocated cell holding a key node merged
+	// with a following value and garbage in between
+	data := make([]byte, HBinAlignment)
+	copy(data, "hbin")
+	binary.LittleEndian.PutUint32(data[8:], HBinAlignment)
+	binary.LittleEndian.PutUint32(data[32:], HBinAlignment-HBinHeaderSize)
+	knData, _ := kn.marshal()
+	copy(data[36:], knData[4:])
+	copy(data[0x100:], "\x00\x00\x00\x00nkgarbage")
+	binary.LittleEndian.PutUint32(data[0x200:], 0xffffffe0)
+	kvData, _ := kv.marshal()
+	copy(data[0x204:], kvData[4:])
+
+	hbins := &HBinData{}
+	if err := hbins.unmarshal(data); err != nil {
+		t.Fatalf("failed to unmarshal hbins: %v", err)
+	}
+
+	cells := hbins.DeletedCells()
+	if got, want := len(cells), 2; got != want {
+		t.Fatalf("deleted cell count mismatch: got %d, want %d", got, want)
+	}
+	if got, ok := cells[0].(*KeyNode); !ok || got.Name() != "Deleted" || got.AbsoluteOffset() != 0x20 {
+		t.Errorf("deleted key node mismatch: got %+v", cells[0])
+	}
+	if got, ok := cells[1].(*KeyValue); !ok || got.Name() != "Value" || got.AbsoluteOffset() != 0x200 || got.Size() != 32 {
+		t.Errorf("deleted key value mismatch: got %+v", cells[1])
+	}
+}
+
+func TestParseFragment(t *testing.T) {
+	kv := &KeyValue{HCellData: HCellData{BlockSize: -32, HCellSignature: [2]byte{'v', 'k'}}}
+	if err := kv.SetName("Old"); err != nil {
+		t.Fatalf("failed to set value name: %v", err)
+	}
+	data, _ := kv.marshal()
+
+	if got, ok := ParseFragment(data).(*KeyValue); !ok || got.Name() != "Old" {
+		t.Errorf("fragment mismatch: got %+v", got)
+	}
+	if got := ParseFragment(data[:6]); got != nil {
+		t.Errorf("short fragment should not be parsed, got %+v", got)
+	}
+	if got := ParseFragment([]byte("\x10\x00\x00\x00xxgarbage")); got != nil {
+		t.Errorf("unknown signature should not be parsed, got %+v", got)
+	}
+}
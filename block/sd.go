@@ -0,0 +1,501 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SecurityDescriptorHeaderSize = 20
+	ACLHeaderSize                = 8
+	ACEHeaderSize                = 4
+	SIDHeaderSize                = 8
+	SecurityDescriptorRevision   = 1
+	ACLRevision                  = 2
+	ACLRevisionDS                = 4
+)
+
+var (
+	ErrInvalidSID = errors.New("invalid security identifier")
+)
+
+type SecurityDescriptorControl uint16
+
+const (
+	SEOwnerDefaulted SecurityDescriptorControl = 1 << iota
+	SEGroupDefaulted
+	SEDaclPresent
+	SEDaclDefaulted
+	SESaclPresent
+	SESaclDefaulted
+	SEDaclUntrusted
+	SEServerSecurity
+	SEDaclAutoInheritReq
+	SESaclAutoInheritReq
+	SEDaclAutoInherited
+	SESaclAutoInherited
+	SEDaclProtected
+	SESaclProtected
+	SERMControlValid
+	SESelfRelative
+)
+
+func (c SecurityDescriptorControl) Has(flag SecurityDescriptorControl) bool {
+	return c&flag == flag
+}
+
+type ACEType uint8
+
+const (
+	AccessAllowedACEType ACEType = iota
+	AccessDeniedACEType
+	SystemAuditACEType
+	SystemAlarmACEType
+	AccessAllowedCompoundACEType
+	AccessAllowedObjectACEType
+	AccessDeniedObjectACEType
+	SystemAuditObjectACEType
+	SystemAlarmObjectACEType
+	AccessAllowedCallbackACEType
+	AccessDeniedCallbackACEType
+	AccessAllowedCallbackObjectACEType
+	AccessDeniedCallbackObjectACEType
+	SystemAuditCallbackACEType
+	SystemAlarmCallbackACEType
+	SystemAuditCallbackObjectACEType
+	SystemAlarmCallbackObjectACEType
+	SystemMandatoryLabelACEType
+	SystemResourceAttributeACEType
+	SystemScopedPolicyIDACEType
+)
+
+// IsObject reports whether ACEs of this type carry object type GUIDs
+func (t ACEType) IsObject() bool {
+	switch t {
+	case AccessAllowedObjectACEType, AccessDeniedObjectACEType,
+		SystemAuditObjectACEType, SystemAlarmObjectACEType,
+		AccessAllowedCallbackObjectACEType, AccessDeniedCallbackObjectACEType,
+		SystemAuditCallbackObjectACEType, SystemAlarmCallbackObjectACEType:
+		return true
+	}
+	return false
+}
+
+// hasSID reports whether ACEs of this type have the common
+// layout of an access mask followed by a SID
+func (t ACEType) hasSID() bool {
+	return t != AccessAllowedCompoundACEType && t <= SystemScopedPolicyIDACEType
+}
+
+type ACEFlag uint8
+
+const (
+	ObjectInheritACE ACEFlag = 1 << iota
+	ContainerInheritACE
+	NoPropagateInheritACE
+	InheritOnlyACE
+	InheritedACE
+	_
+	SuccessfulAccessACE
+	FailedAccessACE
+)
+
+func (f ACEFlag) Has(flag ACEFlag) bool {
+	return f&flag == flag
+}
+
+const (
+	ACEObjectTypePresent          = 0x1
+	ACEInheritedObjectTypePresent = 0x2
+)
+
+// GUID as stored on disk, first three groups are little endian
+type GUID [16]byte
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	s = strings.Trim(s, "{}")
+	parts := strings.Split(s, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 ||
+		len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return g, fmt.Errorf("invalid guid %q", s)
+	}
+
+	var raw []byte
+	for _, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 64)
+		if err != nil {
+			return g, fmt.Errorf("invalid guid %q: %v", s, err)
+		}
+		for i := len(part)/2 - 1; i >= 0; i-- {
+			raw = append(raw, byte(b>>(8*i)))
+		}
+	}
+	copy(g[:], raw)
+	binary.LittleEndian.PutUint32(g[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(g[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(g[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	return g, nil
+}
+
+// SID is a security identifier, e.g. S-1-5-32-544
+type SID struct {
+	Revision uint8
+	// 48 bit identifier authority
+	Authority      uint64
+	SubAuthorities []uint32
+}
+
+func (sid *SID) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "S-%d-", sid.Revision)
+	if sid.Authority >= 1<<32 {
+		fmt.Fprintf(&sb, "0x%012X", sid.Authority)
+	} else {
+		fmt.Fprintf(&sb, "%d", sid.Authority)
+	}
+	for _, sa := range sid.SubAuthorities {
+		fmt.Fprintf(&sb, "-%d", sa)
+	}
+	return sb.String()
+}
+
+func (sid *SID) Size() int {
+	return SIDHeaderSize + 4*len(sid.SubAuthorities)
+}
+
+func (sid *SID) Equal(other *SID) bool {
+	if sid == nil || other == nil {
+		return sid == other
+	}
+	return sid.String() == other.String()
+}
+
+func (sid *SID) marshal() []byte {
+	data := make([]byte, sid.Size())
+	data[0] = sid.Revision
+	data[1] = uint8(len(sid.SubAuthorities))
+	for i := 0; i < 6; i++ {
+		data[2+i] = byte(sid.Authority >> (8 * (5 - i)))
+	}
+	for i, sa := range sid.SubAuthorities {
+		binary.LittleEndian.PutUint32(data[SIDHeaderSize+4*i:], sa)
+	}
+	return data
+}
+
+func unmarshalSID(data []byte) (*SID, error) {
+	if len(data) < SIDHeaderSize {
+		return nil, fmt.Errorf("%w: sid header out of bounds: len %d", ErrInvalidSID, len(data))
+	}
+
+	sid := &SID{Revision: data[0]}
+	count := int(data[1])
+	if SIDHeaderSize+4*count > len(data) {
+		return nil, fmt.Errorf("%w: sid sub authorities out of bounds: count %d len %d", ErrInvalidSID, count, len(data))
+	}
+	for i := 0; i < 6; i++ {
+		sid.Authority = sid.Authority<<8 | uint64(data[2+i])
+	}
+	sid.SubAuthorities = make([]uint32, count)
+	for i := range sid.SubAuthorities {
+		sid.SubAuthorities[i] = binary.LittleEndian.Uint32(data[SIDHeaderSize+4*i:])
+	}
+	return sid, nil
+}
+
+// ParseSID parses the string form of a SID, e.g. S-1-5-18
+func ParseSID(s string) (*SID, error) {
+	parts := strings.Split(strings.ToUpper(s), "-")
+	if len(parts) < 3 || parts[0] != "S" || len(parts) > 3+255 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSID, s)
+	}
+
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSID, s, err)
+	}
+
+	var authority uint64
+	if strings.HasPrefix(parts[2], "0X") {
+		authority, err = strconv.ParseUint(parts[2][2:], 16, 48)
+	} else {
+		authority, err = strconv.ParseUint(parts[2], 10, 48)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSID, s, err)
+	}
+
+	sid := &SID{Revision: uint8(revision), Authority: authority, SubAuthorities: []uint32{}}
+	for _, part := range parts[3:] {
+		sa, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSID, s, err)
+		}
+		sid.SubAuthorities = append(sid.SubAuthorities, uint32(sa))
+	}
+	return sid, nil
+}
+
+// ACE is an access control entry, object type fields are only
+// used by object ACE types and application data holds the bytes
+// following the SID in callback, resource attribute and scoped
+// policy ACEs, or the whole ACE body for unsupported ACE types
+type ACE struct {
+	Type                ACEType
+	Flags               ACEFlag
+	Mask                uint32
+	ObjectFlags         uint32
+	ObjectType          *GUID
+	InheritedObjectType *GUID
+	SID                 *SID
+	ApplicationData     []byte
+}
+
+func (ace *ACE) marshal() []byte {
+	var body bytes.Buffer
+	if ace.Type.hasSID() {
+		binary.Write(&body, binary.LittleEndian, ace.Mask)
+		if ace.Type.IsObject() {
+			binary.Write(&body, binary.LittleEndian, ace.ObjectFlags)
+			if ace.ObjectType != nil {
+				body.Write(ace.ObjectType[:])
+			}
+			if ace.InheritedObjectType != nil {
+				body.Write(ace.InheritedObjectType[:])
+			}
+		}
+		if ace.SID != nil {
+			body.Write(ace.SID.marshal())
+		}
+	}
+	body.Write(ace.ApplicationData)
+	for body.Len()%4 != 0 {
+		body.WriteByte(0)
+	}
+
+	data := make([]byte, ACEHeaderSize, ACEHeaderSize+body.Len())
+	data[0] = byte(ace.Type)
+	data[1] = byte(ace.Flags)
+	binary.LittleEndian.PutUint16(data[2:], uint16(ACEHeaderSize+body.Len()))
+	return append(data, body.Bytes()...)
+}
+
+func unmarshalACE(data []byte) (*ACE, int, error) {
+	if len(data) < ACEHeaderSize {
+		return nil, 0, fmt.Errorf("ace header out of bounds: len %d", len(data))
+	}
+
+	ace := &ACE{Type: ACEType(data[0]), Flags: ACEFlag(data[1])}
+	size := int(binary.LittleEndian.Uint16(data[2:4]))
+	if size < ACEHeaderSize || size > len(data) {
+		return nil, 0, fmt.Errorf("ace size out of bounds: size %d len %d", size, len(data))
+	}
+
+	body := data[ACEHeaderSize:size]
+	if !ace.Type.hasSID() {
+		ace.ApplicationData = body
+		return ace, size, nil
+	}
+
+	if len(body) < 4 {
+		return nil, 0, fmt.Errorf("ace mask out of bounds: len %d", len(body))
+	}
+	ace.Mask = binary.LittleEndian.Uint32(body)
+	pos := 4
+
+	if ace.Type.IsObject() {
+		if len(body) < pos+4 {
+			return nil, 0, fmt.Errorf("ace object flags out of bounds: len %d", len(body))
+		}
+		ace.ObjectFlags = binary.LittleEndian.Uint32(body[pos:])
+		pos += 4
+		for _, field := range []struct {
+			flag uint32
+			guid **GUID
+		}{
+			{ACEObjectTypePresent, &ace.ObjectType},
+			{ACEInheritedObjectTypePresent, &ace.InheritedObjectType},
+		} {
+			if ace.ObjectFlags&field.flag == 0 {
+				continue
+			}
+			if len(body) < pos+16 {
+				return nil, 0, fmt.Errorf("ace object type out of bounds: len %d", len(body))
+			}
+			guid := GUID{}
+			copy(guid[:], body[pos:pos+16])
+			*field.guid = &guid
+			pos += 16
+		}
+	}
+
+	sid, err := unmarshalSID(body[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	ace.SID = sid
+	pos += sid.Size()
+
+	switch ace.Type {
+	case AccessAllowedCallbackACEType, AccessDeniedCallbackACEType,
+		AccessAllowedCallbackObjectACEType, AccessDeniedCallbackObjectACEType,
+		SystemAuditCallbackACEType, SystemAlarmCallbackACEType,
+		SystemAuditCallbackObjectACEType, SystemAlarmCallbackObjectACEType,
+		SystemResourceAttributeACEType, SystemScopedPolicyIDACEType:
+		ace.ApplicationData = body[pos:]
+	}
+	return ace, size, nil
+}
+
+// ACL is an access control list
+type ACL struct {
+	Revision uint8
+	ACEs     []*ACE
+}
+
+func (acl *ACL) marshal() []byte {
+	var aces bytes.Buffer
+	for _, ace := range acl.ACEs {
+		aces.Write(ace.marshal())
+	}
+
+	data := make([]byte, ACLHeaderSize, ACLHeaderSize+aces.Len())
+	data[0] = acl.Revision
+	binary.LittleEndian.PutUint16(data[2:], uint16(ACLHeaderSize+aces.Len()))
+	binary.LittleEndian.PutUint16(data[4:], uint16(len(acl.ACEs)))
+	return append(data, aces.Bytes()...)
+}
+
+func unmarshalACL(data []byte) (*ACL, error) {
+	if len(data) < ACLHeaderSize {
+		return nil, fmt.Errorf("acl header out of bounds: len %d", len(data))
+	}
+
+	acl := &ACL{Revision: data[0]}
+	size := int(binary.LittleEndian.Uint16(data[2:4]))
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if size < ACLHeaderSize || size > len(data) {
+		return nil, fmt.Errorf("acl size out of bounds: size %d len %d", size, len(data))
+	}
+
+	acl.ACEs = make([]*ACE, 0, count)
+	for pos := ACLHeaderSize; len(acl.ACEs) < count; {
+		ace, aceSize, err := unmarshalACE(data[pos:size])
+		if err != nil {
+			return nil, fmt.Errorf("acl entry %d: %w", len(acl.ACEs), err)
+		}
+		acl.ACEs = append(acl.ACEs, ace)
+		pos += aceSize
+	}
+	return acl, nil
+}
+
+// SecurityDescriptor is a parsed self-relative security descriptor,
+// a nil ACL with its present flag set is a null ACL granting all access
+type SecurityDescriptor struct {
+	Revision uint8
+	Control  SecurityDescriptorControl
+	Owner    *SID
+	Group    *SID
+	SACL     *ACL
+	DACL     *ACL
+}
+
+// ParseSecurityDescriptor parses a self-relative security descriptor
+// as stored in the SecDescriptor field of key security cells
+func ParseSecurityDescriptor(data []byte) (*SecurityDescriptor, error) {
+	if len(data) < SecurityDescriptorHeaderSize {
+		return nil, fmt.Errorf("security descriptor header out of bounds: len %d", len(data))
+	}
+
+	sd := &SecurityDescriptor{
+		Revision: data[0],
+		Control:  SecurityDescriptorControl(binary.LittleEndian.Uint16(data[2:4])),
+	}
+	if !sd.Control.Has(SESelfRelative) {
+		return nil, errors.New("security descriptor is not self-relative")
+	}
+
+	offsets := make([]uint32, 4)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint32(data[4+4*i:])
+		if offsets[i] != 0 && (offsets[i] < SecurityDescriptorHeaderSize || offsets[i] >= uint32(len(data))) {
+			return nil, fmt.Errorf("security descriptor offset out of bounds: offset %d len %d", offsets[i], len(data))
+		}
+	}
+
+	var err error
+	if offsets[0] != 0 {
+		if sd.Owner, err = unmarshalSID(data[offsets[0]:]); err != nil {
+			return nil, fmt.Errorf("owner: %w", err)
+		}
+	}
+	if offsets[1] != 0 {
+		if sd.Group, err = unmarshalSID(data[offsets[1]:]); err != nil {
+			return nil, fmt.Errorf("group: %w", err)
+		}
+	}
+	if offsets[2] != 0 && sd.Control.Has(SESaclPresent) {
+		if sd.SACL, err = unmarshalACL(data[offsets[2]:]); err != nil {
+			return nil, fmt.Errorf("sacl: %w", err)
+		}
+	}
+	if offsets[3] != 0 && sd.Control.Has(SEDaclPresent) {
+		if sd.DACL, err = unmarshalACL(data[offsets[3]:]); err != nil {
+			return nil, fmt.Errorf("dacl: %w", err)
+		}
+	}
+	return sd, nil
+}
+
+// Bytes returns the self-relative form of the security descriptor
+// with SACL, DACL, owner and group stored in that order
+func (sd *SecurityDescriptor) Bytes() []byte {
+	data := make([]byte, SecurityDescriptorHeaderSize)
+	data[0] = sd.Revision
+	binary.LittleEndian.PutUint16(data[2:], uint16(sd.Control|SESelfRelative))
+
+	parts := []struct {
+		fieldOffset int
+		data        []byte
+	}{
+		{12, nil},
+		{16, nil},
+		{4, nil},
+		{8, nil},
+	}
+	if sd.SACL != nil {
+		parts[0].data = sd.SACL.marshal()
+	}
+	if sd.DACL != nil {
+		parts[1].data = sd.DACL.marshal()
+	}
+	if sd.Owner != nil {
+		parts[2].data = sd.Owner.marshal()
+	}
+	if sd.Group != nil {
+		parts[3].data = sd.Group.marshal()
+	}
+
+	for _, part := range parts {
+		if part.data == nil {
+			continue
+		}
+		binary.LittleEndian.PutUint32(data[part.fieldOffset:], uint32(len(data)))
+		data = append(data, part.data...)
+	}
+	return data
+}
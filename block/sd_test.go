@@ -0,0 +1,175 @@
+package block
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecurityDescriptorMarshaling(t *testing.T) {
+	recordSecDescriptor := []byte{
+		// Revision, Sbz1
+		0x01, 0x00,
+		// Control: dacl present, self relative
+		0x04, 0x80,
+		// Owner offset
+		0x30, 0x00, 0x00, 0x00,
+		// Group offset
+		0x00, 0x00, 0x00, 0x00,
+		// SACL offset
+		0x00, 0x00, 0x00, 0x00,
+		// DACL offset
+		0x14, 0x00, 0x00, 0x00,
+		// DACL: revision, sbz1, size, ace count, sbz2
+		0x02, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x00,
+		// ACE: type, flags, size
+		0x00, 0x02, 0x14, 0x00,
+		// ACE access mask
+		0x3f, 0x00, 0x0f, 0x00,
+		// ACE SID S-1-1-0
+		0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		// Owner SID S-1-5-18
+		0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05,
+		0x12, 0x00, 0x00, 0x00,
+	}
+
+	sd, err := ParseSecurityDescriptor(recordSecDescriptor)
+	if err != nil {
+		t.Fatalf("failed parsing security descriptor: %v", err)
+	}
+
+	got, want := sd, &SecurityDescriptor{
+		Revision: 1,
+		Control:  SEDaclPresent | SESelfRelative,
+		Owner:    &SID{1, 5, []uint32{18}},
+		DACL: &ACL{
+			Revision: 2,
+			ACEs: []*ACE{
+				{
+					Type:  AccessAllowedACEType,
+					Flags: ContainerInheritACE,
+					Mask:  0xf003f,
+					SID:   &SID{1, 1, []uint32{0}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("security descriptor not equals: got|want\n%+v\n%+v", got, want)
+	}
+
+	if got, want := sd.Bytes(), recordSecDescriptor; !reflect.DeepEqual(got, want) {
+		t.Errorf("security descriptor bytes not equals: got|want\n%v\n%v", got, want)
+	}
+}
+
+func TestSecurityDescriptorObjectACE(t *testing.T) {
+	objectType, err := ParseGUID("bf967aba-0de6-11d0-a285-00aa003049e2")
+	if err != nil {
+		t.Fatalf("failed parsing guid: %v", err)
+	}
+	if got, want := objectType, (GUID{
+		0xba, 0x7a, 0x96, 0xbf, 0xe6, 0x0d, 0xd0, 0x11,
+		0xa2, 0x85, 0x00, 0xaa, 0x00, 0x30, 0x49, 0xe2,
+	}); got != want {
+		t.Fatalf("guid mismatch: got %v, want %v", got, want)
+	}
+
+	sd := &SecurityDescriptor{
+		Revision: 1,
+		Control:  SESaclPresent | SESelfRelative,
+		Group:    &SID{1, 5, []uint32{32, 544}},
+		SACL: &ACL{
+			Revision: 4,
+			ACEs: []*ACE{
+				{
+					Type:        SystemAuditObjectACEType,
+					Flags:       SuccessfulAccessACE | FailedAccessACE,
+					Mask:        0x10,
+					ObjectFlags: ACEObjectTypePresent,
+					ObjectType:  &objectType,
+					SID:         &SID{1, 5, []uint32{11}},
+				},
+				{
+					Type:            AccessAllowedCallbackACEType,
+					Mask:            0x1,
+					SID:             &SID{1, 1, []uint32{0}},
+					ApplicationData: []byte{'a', 'r', 't', 'x', 1, 0, 0, 0},
+				},
+			},
+		},
+	}
+
+	parsed, err := ParseSecurityDescriptor(sd.Bytes())
+	if err != nil {
+		t.Fatalf("failed parsing security descriptor: %v", err)
+	}
+	if got, want := parsed, sd; !reflect.DeepEqual(got, want) {
+		t.Errorf("security descriptor not equals: got|want\n%+v\n%+v", got, want)
+	}
+}
+
+func TestCorruptSecurityDescriptor(t *testing.T) {
+	records := [][]byte{
+		{0x01, 0x00, 0x04, 0x80},
+		// not self relative
+		{
+			0x01, 0x00, 0x04, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		},
+		// dacl offset out of bounds
+		{
+			0x01, 0x00, 0x04, 0x80,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00,
+		},
+		// ace count larger than acl
+		{
+			0x01, 0x00, 0x04, 0x80,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00,
+			0x02, 0x00, 0x08, 0x00, 0x01, 0x00, 0x00, 0x00,
+		},
+	}
+	for i, record := range records {
+		if sd, err := ParseSecurityDescriptor(record); err == nil {
+			t.Errorf("record %d should have failed parsing, got %+v", i, sd)
+		}
+	}
+}
+
+func TestSID(t *testing.T) {
+	testCases := []string{
+		"S-1-5-18",
+		"S-1-1-0",
+		"S-1-5-21-3623811015-3361044348-30300820-1013",
+		"S-1-0x123456789ABC-1",
+		"S-1-16",
+	}
+	for _, tc := range testCases {
+		sid, err := ParseSID(tc)
+		if err != nil {
+			t.Errorf("failed parsing sid %s: %v", tc, err)
+			continue
+		}
+		if got, want := sid.String(), tc; got != want {
+			t.Errorf("sid string mismatch: got %s, want %s", got, want)
+		}
+
+		parsed, err := unmarshalSID(sid.marshal())
+		if err != nil {
+			t.Errorf("failed unmarshaling sid %s: %v", tc, err)
+			continue
+		}
+		if !parsed.Equal(sid) {
+			t.Errorf("sid marshal cycle mismatch: got %s, want %s", parsed, sid)
+		}
+	}
+
+	for _, tc := range []string{"", "S-1", "X-1-5", "S-1-5-abc", "S-1-5-4294967296"} {
+		if sid, err := ParseSID(tc); err == nil {
+			t.Errorf("sid %q should have failed parsing, got %s", tc, sid)
+		}
+	}
+}
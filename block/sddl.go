@@ -0,0 +1,498 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSDDL = errors.New("invalid sddl string")
+)
+
+var sddlSIDAliases = map[string]string{
+	"AC": "S-1-15-2-1",
+	"AN": "S-1-5-7",
+	"AO": "S-1-5-32-548",
+	"AU": "S-1-5-11",
+	"BA": "S-1-5-32-544",
+	"BG": "S-1-5-32-546",
+	"BO": "S-1-5-32-551",
+	"BU": "S-1-5-32-545",
+	"CG": "S-1-3-1",
+	"CO": "S-1-3-0",
+	"ED": "S-1-5-9",
+	"HI": "S-1-16-12288",
+	"IU": "S-1-5-4",
+	"LS": "S-1-5-19",
+	"LW": "S-1-16-4096",
+	"ME": "S-1-16-8192",
+	"NO": "S-1-5-32-556",
+	"NS": "S-1-5-20",
+	"NU": "S-1-5-2",
+	"PS": "S-1-5-10",
+	"PU": "S-1-5-32-547",
+	"RC": "S-1-5-12",
+	"RD": "S-1-5-32-555",
+	"RU": "S-1-5-32-554",
+	"SI": "S-1-16-16384",
+	"SO": "S-1-5-32-549",
+	"SU": "S-1-5-6",
+	"SY": "S-1-5-18",
+	"WD": "S-1-1-0",
+	"WR": "S-1-5-33",
+}
+
+var sddlACETypes = map[ACEType]string{
+	AccessAllowedACEType:               "A",
+	AccessDeniedACEType:                "D",
+	SystemAuditACEType:                 "AU",
+	SystemAlarmACEType:                 "AL",
+	AccessAllowedObjectACEType:         "OA",
+	AccessDeniedObjectACEType:          "OD",
+	SystemAuditObjectACEType:           "OU",
+	SystemAlarmObjectACEType:           "OL",
+	AccessAllowedCallbackACEType:       "XA",
+	AccessDeniedCallbackACEType:        "XD",
+	AccessAllowedCallbackObjectACEType: "ZA",
+	SystemAuditCallbackACEType:         "XU",
+	SystemMandatoryLabelACEType:        "ML",
+	SystemScopedPolicyIDACEType:        "SP",
+}
+
+var sddlACEFlags = []struct {
+	code string
+	flag ACEFlag
+}{
+	{"OI", ObjectInheritACE},
+	{"CI", ContainerInheritACE},
+	{"NP", NoPropagateInheritACE},
+	{"IO", InheritOnlyACE},
+	{"ID", InheritedACE},
+	{"SA", SuccessfulAccessACE},
+	{"FA", FailedAccessACE},
+}
+
+// sddlRightAliases are access masks written as a single code
+var sddlRightAliases = []struct {
+	code string
+	mask uint32
+}{
+	{"FA", 0x1f01ff},
+	{"FR", 0x120089},
+	{"FW", 0x120116},
+	{"FX", 0x1200a0},
+	{"KA", 0xf003f},
+	{"KR", 0x20019},
+	{"KW", 0x20006},
+	{"KX", 0x20019},
+}
+
+// sddlRights are single bit access rights in output order
+var sddlRights = []struct {
+	code string
+	mask uint32
+}{
+	{"GA", 0x10000000},
+	{"GR", 0x80000000},
+	{"GW", 0x40000000},
+	{"GX", 0x20000000},
+	{"CC", 0x1},
+	{"DC", 0x2},
+	{"LC", 0x4},
+	{"SW", 0x8},
+	{"RP", 0x10},
+	{"WP", 0x20},
+	{"DT", 0x40},
+	{"LO", 0x80},
+	{"CR", 0x100},
+	{"SD", 0x10000},
+	{"RC", 0x20000},
+	{"WD", 0x40000},
+	{"WO", 0x80000},
+}
+
+var sddlLabelRights = []struct {
+	code string
+	mask uint32
+}{
+	{"NW", 0x1},
+	{"NR", 0x2},
+	{"NX", 0x4},
+}
+
+// SDDL converts the security descriptor to its string form,
+// conditional expressions and resource attributes of callback
+// ACEs are not supported
+func (sd *SecurityDescriptor) SDDL() (string, error) {
+	var sb strings.Builder
+	if sd.Owner != nil {
+		sb.WriteString("O:" + sddlSID(sd.Owner))
+	}
+	if sd.Group != nil {
+		sb.WriteString("G:" + sddlSID(sd.Group))
+	}
+	if sd.Control.Has(SEDaclPresent) {
+		acl, err := sddlACL(sd.DACL, sd.Control, SEDaclProtected, SEDaclAutoInheritReq, SEDaclAutoInherited)
+		if err != nil {
+			return "", fmt.Errorf("dacl: %w", err)
+		}
+		sb.WriteString("D:" + acl)
+	}
+	if sd.Control.Has(SESaclPresent) {
+		acl, err := sddlACL(sd.SACL, sd.Control, SESaclProtected, SESaclAutoInheritReq, SESaclAutoInherited)
+		if err != nil {
+			return "", fmt.Errorf("sacl: %w", err)
+		}
+		sb.WriteString("S:" + acl)
+	}
+	return sb.String(), nil
+}
+
+func sddlSID(sid *SID) string {
+	s := sid.String()
+	for alias, aliasSID := range sddlSIDAliases {
+		if s == aliasSID {
+			return alias
+		}
+	}
+	return s
+}
+
+func sddlACL(acl *ACL, control, protected, autoInheritReq, autoInherited SecurityDescriptorControl) (string, error) {
+	var sb strings.Builder
+	if control.Has(protected) {
+		sb.WriteString("P")
+	}
+	if control.Has(autoInheritReq) {
+		sb.WriteString("AR")
+	}
+	if control.Has(autoInherited) {
+		sb.WriteString("AI")
+	}
+	if acl == nil {
+		sb.WriteString("NO_ACCESS_CONTROL")
+		return sb.String(), nil
+	}
+
+	for i, ace := range acl.ACEs {
+		s, err := sddlACE(ace)
+		if err != nil {
+			return "", fmt.Errorf("entry %d: %w", i, err)
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), nil
+}
+
+func sddlACE(ace *ACE) (string, error) {
+	aceType, ok := sddlACETypes[ace.Type]
+	if !ok {
+		return "", fmt.Errorf("ace type %d not supported in sddl", ace.Type)
+	}
+	if len(ace.ApplicationData) > 0 {
+		return "", fmt.Errorf("ace type %s with application data not supported in sddl", aceType)
+	}
+
+	var flags strings.Builder
+	for _, f := range sddlACEFlags {
+		if ace.Flags.Has(f.flag) {
+			flags.WriteString(f.code)
+		}
+	}
+
+	var objectType, inheritedObjectType string
+	if ace.ObjectType != nil {
+		objectType = ace.ObjectType.String()
+	}
+	if ace.InheritedObjectType != nil {
+		inheritedObjectType = ace.InheritedObjectType.String()
+	}
+
+	var sid string
+	if ace.SID != nil {
+		sid = sddlSID(ace.SID)
+	}
+
+	fields := []string{
+		aceType,
+		flags.String(),
+		sddlRightsString(ace.Type, ace.Mask),
+		objectType,
+		inheritedObjectType,
+		sid,
+	}
+	return "(" + strings.Join(fields, ";") + ")", nil
+}
+
+func sddlRightsString(aceType ACEType, mask uint32) string {
+	if mask == 0 {
+		return ""
+	}
+
+	rights := sddlRights
+	if aceType == SystemMandatoryLabelACEType {
+		rights = sddlLabelRights
+	} else {
+		for _, alias := range sddlRightAliases {
+			if mask == alias.mask {
+				return alias.code
+			}
+		}
+	}
+
+	var sb strings.Builder
+	remaining := mask
+	for _, right := range rights {
+		if remaining&right.mask != 0 {
+			sb.WriteString(right.code)
+			remaining &^= right.mask
+		}
+	}
+	if remaining != 0 {
+		return fmt.Sprintf("0x%x", mask)
+	}
+	return sb.String()
+}
+
+// ParseSDDL parses the string form of a security descriptor
+func ParseSDDL(s string) (*SecurityDescriptor, error) {
+	sd := &SecurityDescriptor{
+		Revision: SecurityDescriptorRevision,
+		Control:  SESelfRelative,
+	}
+
+	components, err := splitSDDL(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range components {
+		switch c[0] {
+		case 'O':
+			if sd.Owner, err = parseSDDLSID(c[2:]); err != nil {
+				return nil, err
+			}
+		case 'G':
+			if sd.Group, err = parseSDDLSID(c[2:]); err != nil {
+				return nil, err
+			}
+		case 'D':
+			sd.Control |= SEDaclPresent
+			if sd.DACL, err = parseSDDLACL(c[2:], &sd.Control, SEDaclProtected, SEDaclAutoInheritReq, SEDaclAutoInherited); err != nil {
+				return nil, fmt.Errorf("dacl: %w", err)
+			}
+		case 'S':
+			sd.Control |= SESaclPresent
+			if sd.SACL, err = parseSDDLACL(c[2:], &sd.Control, SESaclProtected, SESaclAutoInheritReq, SESaclAutoInherited); err != nil {
+				return nil, fmt.Errorf("sacl: %w", err)
+			}
+		}
+	}
+	return sd, nil
+}
+
+// splitSDDL splits an sddl string to components starting with
+// one of O:, G:, D: or S: outside of ACE parentheses
+func splitSDDL(s string) ([]string, error) {
+	var components []string
+	depth, start := 0, -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+			continue
+		case ')':
+			depth--
+			continue
+		}
+		if depth == 0 && i+1 < len(s) && s[i+1] == ':' && strings.IndexByte("OGDS", s[i]) >= 0 {
+			if start >= 0 {
+				components = append(components, s[start:i])
+			}
+			start = i
+			i++
+			continue
+		}
+		if start < 0 {
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidSDDL, s[i], i)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidSDDL)
+	}
+	if start >= 0 {
+		components = append(components, s[start:])
+	}
+	return components, nil
+}
+
+func parseSDDLSID(s string) (*SID, error) {
+	if sid, ok := sddlSIDAliases[strings.ToUpper(s)]; ok {
+		s = sid
+	}
+	return ParseSID(s)
+}
+
+func parseSDDLACL(s string, control *SecurityDescriptorControl, protected, autoInheritReq, autoInherited SecurityDescriptorControl) (*ACL, error) {
+	flags := s
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		flags = s[:i]
+		s = s[i:]
+	} else {
+		s = ""
+	}
+
+	nullACL := false
+	for flags != "" {
+		switch {
+		case strings.HasPrefix(flags, "NO_ACCESS_CONTROL"):
+			nullACL = true
+			flags = flags[len("NO_ACCESS_CONTROL"):]
+		case strings.HasPrefix(flags, "P"):
+			*control |= protected
+			flags = flags[1:]
+		case strings.HasPrefix(flags, "AR"):
+			*control |= autoInheritReq
+			flags = flags[2:]
+		case strings.HasPrefix(flags, "AI"):
+			*control |= autoInherited
+			flags = flags[2:]
+		default:
+			return nil, fmt.Errorf("%w: unknown acl flags %q", ErrInvalidSDDL, flags)
+		}
+	}
+	if nullACL {
+		return nil, nil
+	}
+
+	acl := &ACL{Revision: ACLRevision, ACEs: []*ACE{}}
+	for s != "" {
+		end := strings.IndexByte(s, ')')
+		if s[0] != '(' || end < 0 {
+			return nil, fmt.Errorf("%w: malformed ace %q", ErrInvalidSDDL, s)
+		}
+		ace, err := parseSDDLACE(s[1:end])
+		if err != nil {
+			return nil, err
+		}
+		if ace.Type.IsObject() {
+			acl.Revision = ACLRevisionDS
+		}
+		acl.ACEs = append(acl.ACEs, ace)
+		s = s[end+1:]
+	}
+	return acl, nil
+}
+
+func parseSDDLACE(s string) (*ACE, error) {
+	fields := strings.Split(s, ";")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: ace %q has %d fields, expected 6", ErrInvalidSDDL, s, len(fields))
+	}
+
+	ace := &ACE{}
+	found := false
+	for aceType, code := range sddlACETypes {
+		if strings.EqualFold(fields[0], code) {
+			ace.Type, found = aceType, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown ace type %q", ErrInvalidSDDL, fields[0])
+	}
+
+	for flags := strings.ToUpper(fields[1]); flags != ""; {
+		matched := false
+		for _, f := range sddlACEFlags {
+			if strings.HasPrefix(flags, f.code) {
+				ace.Flags |= f.flag
+				flags = flags[len(f.code):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: unknown ace flags %q", ErrInvalidSDDL, flags)
+		}
+	}
+
+	mask, err := parseSDDLRights(ace.Type, fields[2])
+	if err != nil {
+		return nil, err
+	}
+	ace.Mask = mask
+
+	if fields[3] != "" || fields[4] != "" {
+		if !ace.Type.IsObject() {
+			return nil, fmt.Errorf("%w: object type set on non object ace %q", ErrInvalidSDDL, s)
+		}
+	}
+	for _, field := range []struct {
+		value string
+		flag  uint32
+		guid  **GUID
+	}{
+		{fields[3], ACEObjectTypePresent, &ace.ObjectType},
+		{fields[4], ACEInheritedObjectTypePresent, &ace.InheritedObjectType},
+	} {
+		if field.value == "" {
+			continue
+		}
+		guid, err := ParseGUID(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSDDL, err)
+		}
+		*field.guid = &guid
+		ace.ObjectFlags |= field.flag
+	}
+
+	if ace.SID, err = parseSDDLSID(fields[5]); err != nil {
+		return nil, err
+	}
+	return ace, nil
+}
+
+func parseSDDLRights(aceType ACEType, s string) (uint32, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		mask, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid rights %q", ErrInvalidSDDL, s)
+		}
+		return uint32(mask), nil
+	}
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		mask, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid rights %q", ErrInvalidSDDL, s)
+		}
+		return uint32(mask), nil
+	}
+
+	rights := append(sddlRightAliases[:len(sddlRightAliases):len(sddlRightAliases)], sddlRights...)
+	if aceType == SystemMandatoryLabelACEType {
+		rights = sddlLabelRights
+	}
+
+	var mask uint32
+	s = strings.ToUpper(s)
+	for len(s) >= 2 {
+		matched := false
+		for _, right := range rights {
+			if s[:2] == right.code {
+				mask |= right.mask
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("%w: unknown right %q", ErrInvalidSDDL, s[:2])
+		}
+		s = s[2:]
+	}
+	if s != "" {
+		return 0, fmt.Errorf("%w: unknown right %q", ErrInvalidSDDL, s)
+	}
+	return mask, nil
+}
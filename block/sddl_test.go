@@ -0,0 +1,81 @@
+package block
+
+import (
+	"testing"
+)
+
+func TestSDDLCycle(t *testing.T) {
+	testCases := []string{
+		"O:SYD:(A;CI;KA;;;WD)",
+		"O:BAG:SYD:PAI(A;CIIO;KA;;;CO)(A;CI;KR;;;BU)(A;CI;KA;;;BA)(A;CI;KA;;;SY)(A;CI;KR;;;AC)",
+		"O:S-1-5-21-1-2-3-500G:S-1-5-21-1-2-3-513D:ARAI(D;OICIID;0x1234;;;S-1-5-21-1-2-3-1000)",
+		"D:NO_ACCESS_CONTROLS:(AU;SAFA;KA;;;WD)",
+		"D:(OA;CI;RPWP;bf967aba-0de6-11d0-a285-00aa003049e2;;AU)(A;;CCDCLCSWRPSDRC;;;PS)",
+		"S:(ML;;NW;;;LW)",
+		"D:P",
+	}
+	for _, tc := range testCases {
+		sd, err := ParseSDDL(tc)
+		if err != nil {
+			t.Errorf("failed parsing sddl %s: %v", tc, err)
+			continue
+		}
+
+		parsed, err := ParseSecurityDescriptor(sd.Bytes())
+		if err != nil {
+			t.Errorf("failed parsing security descriptor of %s: %v", tc, err)
+			continue
+		}
+
+		s, err := parsed.SDDL()
+		if err != nil {
+			t.Errorf("failed converting %s to sddl: %v", tc, err)
+			continue
+		}
+		if got, want := s, tc; got != want {
+			t.Errorf("sddl mismatch: got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestSDDLRights(t *testing.T) {
+	testCases := []struct {
+		SDDL string
+		Mask uint32
+	}{
+		{"D:(A;;KR;;;BU)", 0x20019},
+		{"D:(A;;KX;;;BU)", 0x20019},
+		{"D:(A;;GAGR;;;BU)", 0x90000000},
+		{"D:(A;;0x1f01ff;;;BU)", 0x1f01ff},
+		{"D:(A;;1024;;;BU)", 0x400},
+	}
+	for _, tc := range testCases {
+		sd, err := ParseSDDL(tc.SDDL)
+		if err != nil {
+			t.Errorf("failed parsing sddl %s: %v", tc.SDDL, err)
+			continue
+		}
+		if got, want := sd.DACL.ACEs[0].Mask, tc.Mask; got != want {
+			t.Errorf("mask of %s mismatch: got %#x, want %#x", tc.SDDL, got, want)
+		}
+	}
+}
+
+func TestInvalidSDDL(t *testing.T) {
+	testCases := []string{
+		"X:BA",
+		"O:XX",
+		"D:(A;;KA;;)",
+		"D:(Q;;KA;;;BA)",
+		"D:(A;ZZ;KA;;;BA)",
+		"D:(A;;QQ;;;BA)",
+		"D:(A;;KA;;;BA",
+		"D:(A;;KA;bf967aba-0de6-11d0-a285-00aa003049e2;;BA)",
+		"D:XY(A;;KA;;;BA)",
+	}
+	for _, tc := range testCases {
+		if sd, err := ParseSDDL(tc); err == nil {
+			t.Errorf("sddl %s should have failed parsing, got %+v", tc, sd)
+		}
+	}
+}
@@ -45,11 +45,16 @@ func (ks *KeySecurity) unmarshal(data []byte) error {
 	}
 
 	const keySecurityDataEnd = HCellDataSize + KeySecurityDataSize
-	dEnd := uint32(keySecurityDataEnd) + ks.SecDescriptorSize
-	if dEnd > uint32(len(data)) {
+	dEnd := uint64(keySecurityDataEnd) + uint64(ks.SecDescriptorSize)
+	if dEnd > uint64(ks.Size()) {
 		return fmt.Errorf("sec descriptor size out of bounds: size %d len %d", ks.SecDescriptorSize, len(data))
 	}
 	ks.SecDescriptor = data[keySecurityDataEnd:dEnd]
 	ks.Padding = data[dEnd:ks.Size()]
 	return nil
 }
+
+// Descriptor parses the security descriptor stored in this cell
+func (ks *KeySecurity) Descriptor() (*SecurityDescriptor, error) {
+	return ParseSecurityDescriptor(ks.SecDescriptor)
+}
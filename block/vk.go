@@ -1,6 +1,7 @@
 package block
 
 import (
+	"encoding/binary"
 	"fmt"
 )
 
@@ -10,7 +11,6 @@ const (
 	RegExpandSz
 	RegBinary
 	RegDWord
-	RegDWordLittleEndian
 	RegDWordBigEndian
 	RegLink
 	RegMultiSz
@@ -18,14 +18,84 @@ const (
 	RegFullResourceDescriptor
 	RegResourceRequirementsList
 	RegQWord
-	RegUnknown = -1
+	RegUnknown           = -1
+	RegDWordLittleEndian = RegDWord
+	RegQWordLittleEndian = RegQWord
 )
 
+// KeyValueFlag is stored in the Flags field of key values
+type KeyValueFlag uint16
+
+const (
+	// Value name is stored in Latin-1
+	ValueCompName KeyValueFlag = 1 << iota
+	// Value is a tombstone in a layered key
+	ValueTombstone
+)
+
+var keyValueFlagNames = []flagName{
+	{uint64(ValueCompName), "VALUE_COMP_NAME"},
+	{uint64(ValueTombstone), "VALUE_TOMBSTONE"},
+}
+
+func (f KeyValueFlag) Has(flag KeyValueFlag) bool {
+	return f&flag == flag
+}
+
+func (f KeyValueFlag) String() string {
+	return flagString(uint64(f), keyValueFlagNames)
+}
+
+const (
+	// Set in DataSize when data is stored in the DataOffset field
+	DataSizeInline = 0x80000000
+	// Maximum number of bytes that can be stored inline
+	DataInlineMaxSize = 4
+)
+
+var typeNames = map[uint32]string{
+	RegNone:                     "REG_NONE",
+	RegSz:                       "REG_SZ",
+	RegExpandSz:                 "REG_EXPAND_SZ",
+	RegBinary:                   "REG_BINARY",
+	RegDWord:                    "REG_DWORD",
+	RegDWordBigEndian:           "REG_DWORD_BIG_ENDIAN",
+	RegLink:                     "REG_LINK",
+	RegMultiSz:                  "REG_MULTI_SZ",
+	RegResourceList:             "REG_RESOURCE_LIST",
+	RegFullResourceDescriptor:   "REG_FULL_RESOURCE_DESCRIPTOR",
+	RegResourceRequirementsList: "REG_RESOURCE_REQUIREMENTS_LIST",
+	RegQWord:                    "REG_QWORD",
+}
+
+// TypeName returns the name of a value data type, e.g. REG_SZ
+func TypeName(dataType uint32) string {
+	if name, ok := typeNames[dataType]; ok {
+		return name
+	}
+	return fmt.Sprintf("REG_UNKNOWN(%#x)", dataType)
+}
+
+// ParseTypeName returns the value data type of a name
+// returned by TypeName, e.g. REG_SZ or REG_UNKNOWN(0x20)
+func ParseTypeName(name string) (uint32, error) {
+	for dataType, typeName := range typeNames {
+		if typeName == name {
+			return dataType, nil
+		}
+	}
+	var dataType uint32
+	if _, err := fmt.Sscanf(name, "REG_UNKNOWN(%v)", &dataType); err != nil || TypeName(dataType) != name {
+		return 0, fmt.Errorf("unknown value data type %q", name)
+	}
+	return dataType, nil
+}
+
 type KeyValueData struct {
 	DataSize   int32
 	DataOffset int32
 	DataType   uint32
-	Flags      uint16
+	Flags      KeyValueFlag
 	Spare      uint16
 }
 
@@ -64,10 +134,62 @@ func (kv *KeyValue) unmarshal(data []byte) error {
 
 	const keyValueDataEnd = HCellDataSize + KeyValueDataSize
 	dEnd := keyValueDataEnd + int(kv.Metadata)
-	if dEnd > len(data) {
+	if dEnd > int(kv.Size()) {
 		return fmt.Errorf("name length out of bounds: name %d len %d", kv.Metadata, len(data))
 	}
 	kv.ValueName = data[keyValueDataEnd:dEnd]
 	kv.Padding = data[dEnd:kv.Size()]
 	return nil
 }
+
+// IsDataInline reports whether the value data
+// is stored directly in the DataOffset field
+func (kv *KeyValue) IsDataInline() bool {
+	return uint32(kv.DataSize)&DataSizeInline != 0
+}
+
+// DataLength returns the size of value data
+// without the inline data flag
+func (kv *KeyValue) DataLength() uint32 {
+	return uint32(kv.DataSize) &^ DataSizeInline
+}
+
+// InlineData returns the data stored in the DataOffset field
+// truncated to the data length, nil if data is not inline
+func (kv *KeyValue) InlineData() []byte {
+	if !kv.IsDataInline() {
+		return nil
+	}
+
+	data := make([]byte, DataInlineMaxSize)
+	binary.LittleEndian.PutUint32(data, uint32(kv.DataOffset))
+	if size := kv.DataLength(); size < DataInlineMaxSize {
+		data = data[:size]
+	}
+	return data
+}
+
+// Name decodes the value name which is stored either in
+// Latin-1 when ValueCompName flag is set or in UTF-16LE
+func (kv *KeyValue) Name() string {
+	return DecodeName(kv.ValueName, kv.Flags.Has(ValueCompName))
+}
+
+// SetName encodes the value name, in compressed form if possible,
+// and updates the name length and flags, cell size is kept if the
+// name fits, otherwise the cell grows unless it is placed in an hbin
+func (kv *KeyValue) SetName(name string) error {
+	raw, compressed := EncodeName(name)
+	if err := kv.canFit(HCellDataSize + KeyValueDataSize + len(raw)); err != nil {
+		return err
+	}
+	if compressed {
+		kv.Flags |= ValueCompName
+	} else {
+		kv.Flags &^= ValueCompName
+	}
+	kv.ValueName = raw
+	kv.Metadata = uint16(len(raw))
+	kv.fit(HCellDataSize + KeyValueDataSize + len(raw))
+	return nil
+}
@@ -1,6 +1,7 @@
 package block
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -58,3 +59,107 @@ func TestKeyValueMarshaling(t *testing.T) {
 		t.Errorf("vk records not equals: got|want\n%+v\n%+v", got, want)
 	}
 }
+
+func TestKeyValueInlineData(t *testing.T) {
+	testCases := []struct {
+		DataSize   int32
+		DataOffset int32
+		Inline     bool
+		Length     uint32
+		Data       []byte
+	}{
+		{-0x7ffffffc, 0x04030201, true, 4, []byte{1, 2, 3, 4}},
+		{-0x7ffffffe, 0x04030201, true, 2, []byte{1, 2}},
+		{-0x80000000, 0x04030201, true, 0, []byte{}},
+		{0x10, 0x20, false, 16, nil},
+	}
+	for _, tc := range testCases {
+		kv := &KeyValue{KeyValueData: KeyValueData{DataSize: tc.DataSize, DataOffset: tc.DataOffset}}
+		if got, want := kv.IsDataInline(), tc.Inline; got != want {
+			t.Errorf("inline flag of %#x mismatch: got %v, want %v", tc.DataSize, got, want)
+		}
+		if got, want := kv.DataLength(), tc.Length; got != want {
+			t.Errorf("data length of %#x mismatch: got %d, want %d", tc.DataSize, got, want)
+		}
+		if got, want := kv.InlineData(), tc.Data; !reflect.DeepEqual(got, want) {
+			t.Errorf("inline data of %#x mismatch: got %v, want %v", tc.DataSize, got, want)
+		}
+	}
+
+	if got, want := TypeName(RegMultiSz), "REG_MULTI_SZ"; got != want {
+		t.Errorf("type name mismatch: got %s, want %s", got, want)
+	}
+	if got, want := TypeName(0x20), "REG_UNKNOWN(0x20)"; got != want {
+		t.Errorf("type name mismatch: got %s, want %s", got, want)
+	}
+	for _, dataType := range []uint32{RegNone, RegMultiSz, RegQWord, 0x20, 0xffff0001} {
+		if got, err := ParseTypeName(TypeName(dataType)); err != nil || got != dataType {
+			t.Errorf("parsed type of %s mismatch: got %#x (%v), want %#x", TypeName(dataType), got, err, dataType)
+		}
+	}
+	for _, name := range []string{"REG_TEXT", "REG_UNKNOWN(0x1)", "REG_UNKNOWN(32)"} {
+		if _, err := ParseTypeName(name); err == nil {
+			t.Errorf("parsing type name %q should fail", name)
+		}
+	}
+}
+
+func TestKeyValueName(t *testing.T) {
+	for _, name := range []string{"", "Größe", "日本", "(Default)"} {
+		kv := &KeyValue{HCellData: HCellData{HCellSignature: [2]byte{'v', 'k'}}}
+		if err := kv.SetName(name); err != nil {
+			t.Fatalf("failed to set value name %q: %v", name, err)
+		}
+
+		data, err := kv.marshal()
+		if err != nil {
+			t.Fatalf("failed marshaling vk record %v", err)
+		}
+		if got, want := int32(len(data)), kv.Size(); got != want || got%8 != 0 {
+			t.Errorf("marshaled size mismatch for %q: got %d, want %d", name, got, want)
+		}
+
+		parsed := &KeyValue{}
+		if err := parsed.unmarshal(data); err != nil {
+			t.Fatalf("failed unmarshaling vk record %v", err)
+		}
+		if got, want := parsed.Name(), name; got != want {
+			t.Errorf("value name mismatch: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestKeyValueNameInHBin(t *testing.T) {
+	kv := &KeyValue{HCellData: HCellData{HCellSignature: [2]byte{'v', 'k'}}}
+	if err := kv.SetName("Value"); err != nil {
+		t.Fatalf("failed to set value name: %v", err)
+	}
+	kv.setParentHBin(&HBin{})
+
+	if err := kv.SetName("AVeryLongValueName"); !errors.Is(err, ErrCellTooSmall) {
+		t.Errorf("expected ErrCellTooSmall growing a cell in hbin, got %v", err)
+	}
+	if got, want := kv.Name(), "Value"; got != want {
+		t.Errorf("failed rename should keep the name: got %q, want %q", got, want)
+	}
+}
+
+func TestKeyValueFlags(t *testing.T) {
+	testCases := []struct {
+		Flags  KeyValueFlag
+		String string
+	}{
+		{0, "0"},
+		{ValueCompName, "VALUE_COMP_NAME"},
+		{ValueCompName | ValueTombstone, "VALUE_COMP_NAME|VALUE_TOMBSTONE"},
+		{0x8001, "VALUE_COMP_NAME|0x8000"},
+	}
+	for _, tc := range testCases {
+		if got, want := tc.Flags.String(), tc.String; got != want {
+			t.Errorf("flags string mismatch: got %s, want %s", got, want)
+		}
+	}
+	if !(ValueCompName | ValueTombstone).Has(ValueTombstone) {
+		t.Errorf("tombstone flag should be set")
+	}
+}
@@ -0,0 +1,209 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/turekt/winrego/block"
+)
+
+const (
+	// Base blocks and hbins are searched for at sector boundaries
+	CarveAlignment = 512
+	carveChunkSize = 1 << 20
+)
+
+// CarvedHive is a hive reassembled from a base block
+// and contiguous hbins found in an image
+type CarvedHive struct {
+	// Offset of the first hbin in the image
+	Offset int64
+	// Offset of the base block in the image, -1 when synthesized
+	BaseBlockOffset int64
+	// Base block was not found and was synthesized from hbins
+	Synthesized bool
+	// All hbins declared by the base block were found
+	Complete bool
+	// Hive loaded from the carved data, hbins are left raw
+	// in RawHiveData when they could not be unmarshaled
+	Registry *Registry
+	// Error unmarshaling hbins of the carved hive
+	Err error
+}
+
+// HBinFragment is an hbin found in an image that
+// is not part of any reassembled hive
+type HBinFragment struct {
+	// Offset of the hbin in the image
+	Offset int64
+	// Offset of the hbin from the start of hbins data of its hive
+	HBinDataOffset int32
+	Size           int32
+}
+
+// CarveResult holds hives and hbin fragments found in an image
+type CarveResult struct {
+	Hives   []*CarvedHive
+	Orphans []HBinFragment
+}
+
+type carvedHBin struct {
+	HBinFragment
+	used bool
+}
+
+// Carve scans size bytes of an image, e.g. a raw disk image, memory
+// image or unallocated clusters, for base blocks and hbin headers
+// aligned to 512 bytes. Hbins contiguous in the image are reassembled
+// into hives, a base block is synthesized when it is not found right
+// before the first hbin, hbins that do not belong to any hive starting
+// with an hbin at offset 0 are reported as orphans
+func Carve(ra io.ReaderAt, size int64) (*CarveResult, error) {
+	regfs := make(map[int64]bool)
+	var hbins []*carvedHBin
+	index := make(map[int64]*carvedHBin)
+
+	buf := make([]byte, carveChunkSize)
+	for start := int64(0); start < size; start += carveChunkSize {
+		n, err := ra.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if int64(n) > size-start {
+			n = int(size - start)
+		}
+
+		for p := 0; p+block.HBinHeaderSize <= n; p += CarveAlignment {
+			switch string(buf[p : p+4]) {
+			case "regf":
+				regfs[start+int64(p)] = true
+			case "hbin":
+				hb := &carvedHBin{HBinFragment: HBinFragment{
+					Offset:         start + int64(p),
+					HBinDataOffset: int32(binary.LittleEndian.Uint32(buf[p+4:])),
+					Size:           int32(binary.LittleEndian.Uint32(buf[p+8:])),
+				}}
+				if validCarvedHBin(hb, size) {
+					hbins = append(hbins, hb)
+					index[hb.Offset] = hb
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	res := &CarveResult{}
+	for _, hb := range hbins {
+		if hb.HBinDataOffset != 0 || hb.used {
+			continue
+		}
+
+		chain := []*carvedHBin{hb}
+		for next := index[hb.Offset+int64(hb.Size)]; next != nil && !next.used; next = index[next.Offset+int64(next.Size)] {
+			last := chain[len(chain)-1]
+			if next.HBinDataOffset != last.HBinDataOffset+last.Size {
+				break
+			}
+			chain = append(chain, next)
+		}
+
+		ch, err := carveHive(ra, chain, regfs[hb.Offset-block.BaseBlockSize])
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chain {
+			c.used = true
+		}
+		res.Hives = append(res.Hives, ch)
+	}
+
+	for _, hb := range hbins {
+		if !hb.used {
+			res.Orphans = append(res.Orphans, hb.HBinFragment)
+		}
+	}
+	return res, nil
+}
+
+func validCarvedHBin(hb *carvedHBin, size int64) bool {
+	return hb.Size >= block.HBinAlignment && hb.Size%block.HBinAlignment == 0 &&
+		hb.HBinDataOffset >= 0 && hb.HBinDataOffset%block.HBinAlignment == 0 &&
+		hb.Offset+int64(hb.Size) <= size
+}
+
+// carveHive reads the base block and hbins of a chain and loads them
+func carveHive(ra io.ReaderAt, chain []*carvedHBin, hasBaseBlock bool) (*CarvedHive, error) {
+	first, last := chain[0], chain[len(chain)-1]
+	hbinSize := last.HBinDataOffset + last.Size
+
+	data := make([]byte, block.BaseBlockSize+int64(hbinSize))
+	if _, err := ra.ReadAt(data[block.BaseBlockSize:], first.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	ch := &CarvedHive{Offset: first.Offset, BaseBlockOffset: -1, Complete: true}
+	r := &Registry{}
+	if hasBaseBlock {
+		ch.BaseBlockOffset = first.Offset - block.BaseBlockSize
+		if _, err := ra.ReadAt(data[:block.BaseBlockSize], ch.BaseBlockOffset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err := block.Unmarshal(&r.BaseBlock, data); err != nil {
+			return nil, err
+		}
+		ch.Complete = r.HBinSize == uint32(hbinSize)
+	} else {
+		ch.Synthesized = true
+		r.BaseBlock = block.BaseBlock{
+			RegfHeader:       block.BaseBlockSignature,
+			Sequence1:        1,
+			Sequence2:        1,
+			LastWTimestamp:   binary.LittleEndian.Uint64(data[block.BaseBlockSize+20:]),
+			Major:            SupportedMajorVersion,
+			Minor:            5,
+			FileType:         FileTypePrimary,
+			FileFormat:       FileFormatDirectMemoryLoad,
+			RootCellOffset:   ^uint32(0),
+			ClusteringFactor: 1,
+		}
+	}
+	r.HBinSize = uint32(hbinSize)
+
+	header, err := block.Marshal(&r.BaseBlock)
+	if err != nil {
+		return nil, err
+	}
+	copy(data, header)
+
+	if ch.Err = r.Load(data, ReadAllUnmarshal); ch.Err != nil {
+		r = &Registry{}
+		if err := r.Load(data, ReadAllRaw); err != nil {
+			return nil, err
+		}
+	} else if ch.Synthesized {
+		r.RootCellOffset = uint32(carvedRootOffset(r))
+	}
+	// hbins size of a truncated hive no longer matches the checksum
+	if ch.Synthesized || !ch.Complete {
+		if err := r.UpdateChecksum(); err != nil {
+			return nil, err
+		}
+	}
+	ch.Registry = r
+	return ch, nil
+}
+
+// carvedRootOffset returns the offset of the allocated
+// key node marked as the hive entry key
+func carvedRootOffset(r *Registry) int32 {
+	for i := range r.HBins {
+		for _, hc := range r.HBins[i].Cells {
+			if kn, ok := hc.(*block.KeyNode); ok && kn.Allocated() && kn.Flags().Has(block.KeyHiveEntry) {
+				return kn.AbsoluteOffset()
+			}
+		}
+	}
+	return NoCellOffset
+}
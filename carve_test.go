@@ -0,0 +1,93 @@
+package winrego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+func TestCarve(t *testing.T) {
+	th, root := testTreeHive()
+	kn := th.cells[0].(*block.KeyNode)
+	kn.SetFlags(kn.Flags() | block.KeyHiveEntry)
+	hive := th.bytes(root)
+	hbins := hive[block.BaseBlockSize:]
+
+	orphan := append([]byte{}, hbins...)
+	binary.LittleEndian.PutUint32(orphan[4:], 0x3000)
+
+	// junk, full hive, hbins without base block, junk, orphan hbin, junk
+	var image bytes.Buffer
+	image.Write(bytes.Repeat([]byte{0xcc}, 1024))
+	image.Write(hive)
+	image.Write(hbins)
+	image.Write(bytes.Repeat([]byte{0xcc}, 512))
+	image.Write(orphan)
+	image.Write(bytes.Repeat([]byte{0xcc}, 100))
+
+	res, err := Carve(bytes.NewReader(image.Bytes()), int64(image.Len()))
+	if err != nil {
+		t.Fatalf("failed to carve image: %v", err)
+	}
+	if got, want := len(res.Hives), 2; got != want {
+		t.Fatalf("carved hive count mismatch: got %d, want %d", got, want)
+	}
+
+	expect := []struct {
+		Offset          int64
+		BaseBlockOffset int64
+		Synthesized     bool
+	}{
+		{1024 + block.BaseBlockSize, 1024, false},
+		{1024 + 2*block.BaseBlockSize, -1, true},
+	}
+	for i, e := range expect {
+		ch := res.Hives[i]
+		if ch.Offset != e.Offset || ch.BaseBlockOffset != e.BaseBlockOffset || ch.Synthesized != e.Synthesized {
+			t.Errorf("carved hive %d mismatch: got %+v, want %+v", i, ch, e)
+		}
+		if ch.Err != nil || !ch.Complete {
+			t.Errorf("carved hive %d should be complete, got %v", i, ch.Err)
+		}
+		if _, err := ch.Registry.OpenKey(`Software\Vendor`); err != nil {
+			t.Errorf("failed to open key in carved hive %d: %v", i, err)
+		}
+	}
+	if err := res.Hives[1].Registry.ValidateChecksum(); err != nil {
+		t.Errorf("synthesized base block checksum invalid: %v", err)
+	}
+
+	want := []HBinFragment{{Offset: 1024 + 3*block.BaseBlockSize + 512, HBinDataOffset: 0x3000, Size: block.BaseBlockSize}}
+	if len(res.Orphans) != 1 || res.Orphans[0] != want[0] {
+		t.Errorf("orphans mismatch: got %+v, want %+v", res.Orphans, want)
+	}
+}
+
+func TestCarveTruncated(t *testing.T) {
+	th, root := testTreeHive()
+	hive := th.bytes(root)
+	// base block declares an hbin that is missing from the image
+	binary.LittleEndian.PutUint32(hive[40:], uint32(len(hive)))
+	binary.LittleEndian.PutUint32(hive[block.BaseBlockChecksumLength:], block.BaseBlockChecksum(hive))
+
+	image := append(append([]byte{}, hive...), bytes.Repeat([]byte{0xcc}, 100)...)
+	res, err := Carve(bytes.NewReader(image), int64(len(image)))
+	if err != nil {
+		t.Fatalf("failed to carve image: %v", err)
+	}
+	if got, want := len(res.Hives), 1; got != want {
+		t.Fatalf("carved hive count mismatch: got %d, want %d", got, want)
+	}
+	ch := res.Hives[0]
+	if ch.Complete || ch.Synthesized {
+		t.Errorf("carved hive should be incomplete with its own base block, got %+v", ch)
+	}
+	if got, want := ch.Registry.HBinSize, uint32(len(hive)-block.BaseBlockSize); got != want {
+		t.Errorf("hbins size mismatch: got %d, want %d", got, want)
+	}
+	if err := ch.Registry.ValidateChecksum(); err != nil {
+		t.Errorf("truncated base block checksum invalid: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/turekt/winrego/block"
+)
+
+// compactor copies cells reachable from the root key to new hbins
+// and rewrites offsets stored in the copies once all are placed
+type compactor struct {
+	r     *Registry
+	hbins block.HBinData
+	alloc *block.Allocator
+	// New offsets of copied cells by their old offsets
+	offsets map[int32]int32
+	// Fields and offset lists of copies referencing old offsets
+	refs  []*int32
+	lists []compactedList
+	// Copies of key security cells in order of first reference
+	security []*block.KeySecurity
+	refCount map[int32]uint32
+}
+
+// compactedList is a copied data cell holding count offsets
+type compactedList struct {
+	dr    *block.DataRecord
+	count int
+}
+
+// Compact rebuilds the hive by copying all cells reachable from the
+// root key one after another into new hbins and rewriting the offsets
+// stored in them, unreachable cells and cell slack are dropped, key
+// security cells are relinked in the order they are first referenced
+// and the base block is marked as defragmented
+func (r *Registry) Compact() error {
+	if len(r.HBins) == 0 {
+		return ErrHBinsNotLoaded
+	}
+	root, err := r.Root()
+	if err != nil {
+		return err
+	}
+
+	c := &compactor{
+		r:        r,
+		offsets:  make(map[int32]int32),
+		refCount: make(map[int32]uint32),
+	}
+	c.alloc = block.NewAllocator(nil, &c.hbins)
+	if err := c.copyKey(root, true); err != nil {
+		return err
+	}
+
+	for _, ref := range c.refs {
+		offset, ok := c.offsets[*ref]
+		if !ok {
+			return fmt.Errorf("offset %#x references a cell that was not copied", *ref)
+		}
+		*ref = offset
+	}
+	for _, list := range c.lists {
+		for i := 0; i < list.count; i++ {
+			old := int32(binary.LittleEndian.Uint32(list.dr.Data[i*4:]))
+			offset, ok := c.offsets[old]
+			if !ok {
+				return fmt.Errorf("offset %#x references a cell that was not copied", old)
+			}
+			binary.LittleEndian.PutUint32(list.dr.Data[i*4:], uint32(offset))
+		}
+	}
+	for i, ks := range c.security {
+		ks.Flink = c.security[(i+1)%len(c.security)].AbsoluteOffset()
+		ks.Blink = c.security[(i+len(c.security)-1)%len(c.security)].AbsoluteOffset()
+		ks.RefCount = c.refCount[ks.AbsoluteOffset()]
+	}
+
+	c.hbins[0].Timestamp = r.LastWTimestamp
+	r.HBins = c.hbins
+	r.HBinSize = r.HBins.TotalSize()
+	r.RootCellOffset = uint32(c.offsets[root.AbsoluteOffset()])
+	r.Flags |= block.BaseBlockDefragmented
+	r.modified = true
+	r.LastRTimestamp = block.Filetime(time.Now())&^block.ReorganizationTypeMask | block.ReorganizationDefragmented
+	return nil
+}
+
+// place appends the copy of the cell at offset old
+func (c *compactor) place(old int32, hc block.HCell) error {
+	offset, err := c.alloc.Append(hc)
+	if err != nil {
+		return err
+	}
+	c.offsets[old] = offset
+	return nil
+}
+
+// data appends a data cell holding the first size bytes of the cell
+// at offset old, offsets stored in the data are rewritten if isList
+func (c *compactor) data(old int32, size int, isList bool) error {
+	if _, ok := c.offsets[old]; ok {
+		return nil
+	}
+	payload, err := c.r.dataAt(old)
+	if err != nil {
+		return err
+	}
+	if size > len(payload) {
+		return fmt.Errorf("data cell at offset %#x holds less than %d bytes", old, size)
+	}
+
+	dr := &block.DataRecord{Data: append([]byte{}, payload[:size]...)}
+	if isList {
+		c.lists = append(c.lists, compactedList{dr, size / 4})
+	}
+	return c.place(old, dr)
+}
+
+// copyKey copies the key node followed by its class name, key security,
+// values and subkeys lists, subkeys are copied after all of its cells
+func (c *compactor) copyKey(k *Key, isRoot bool) error {
+	if _, ok := c.offsets[k.AbsoluteOffset()]; ok {
+		return fmt.Errorf("%w: key node at offset %#x", ErrCellLoop, k.AbsoluteOffset())
+	}
+	kn := *k.KeyNode
+	kn.KeyName = append([]byte{}, k.KeyName...)
+	kn.Padding = nil
+	if err := c.place(k.AbsoluteOffset(), &kn); err != nil {
+		return err
+	}
+	if !isRoot {
+		c.refs = append(c.refs, &kn.Parent)
+	}
+
+	if k.ClassNameLength != 0 {
+		if err := c.data(k.ClassNameOffset, int(uint16(k.ClassNameLength)), false); err != nil {
+			return err
+		}
+		c.refs = append(c.refs, &kn.ClassNameOffset)
+	}
+
+	if k.KeySecurityOffset != NoCellOffset {
+		if err := c.copySecurity(k.KeySecurityOffset); err != nil {
+			return err
+		}
+		c.refs = append(c.refs, &kn.KeySecurityOffset)
+	}
+
+	values, err := k.Values()
+	if err != nil {
+		return err
+	}
+	if len(values) > 0 {
+		if err := c.data(k.KeyValuesListOffset, 4*len(values), true); err != nil {
+			return err
+		}
+		c.refs = append(c.refs, &kn.KeyValuesListOffset)
+	}
+	for _, v := range values {
+		if err := c.copyValue(v); err != nil {
+			return err
+		}
+	}
+
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return err
+	}
+	if len(subkeys) > 0 {
+		if err := c.copySubkeyList(k.SubkeysListOffset); err != nil {
+			return err
+		}
+		c.refs = append(c.refs, &kn.SubkeysListOffset)
+	}
+	for _, sk := range subkeys {
+		if err := c.copyKey(sk, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySecurity copies the key security cell once and
+// counts keys referencing it
+func (c *compactor) copySecurity(old int32) error {
+	ks, err := c.r.securityAt(old)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.offsets[old]; !ok {
+		cp := *ks
+		cp.SecDescriptor = append([]byte{}, ks.SecDescriptor...)
+		cp.Padding = nil
+		if err := c.place(old, &cp); err != nil {
+			return err
+		}
+		c.security = append(c.security, &cp)
+	}
+	c.refCount[c.offsets[old]]++
+	return nil
+}
+
+// copyValue copies the key value followed by its data cells
+func (c *compactor) copyValue(v *Value) error {
+	kv := *v.KeyValue
+	kv.ValueName = append([]byte{}, v.ValueName...)
+	kv.Padding = nil
+	if err := c.place(v.AbsoluteOffset(), &kv); err != nil {
+		return err
+	}
+
+	size := kv.DataLength()
+	if kv.IsDataInline() || size == 0 {
+		return nil
+	}
+	c.refs = append(c.refs, &kv.DataOffset)
+
+	hc, err := c.r.cellAt(kv.DataOffset)
+	if err != nil {
+		return err
+	}
+	bd, ok := hc.(*block.BigData)
+	if !block.UsesBigData(c.r.Minor, size) || !ok {
+		return c.data(kv.DataOffset, int(size), false)
+	}
+
+	cp := *bd
+	cp.Padding = nil
+	if err := c.place(bd.AbsoluteOffset(), &cp); err != nil {
+		return err
+	}
+	c.refs = append(c.refs, &cp.DataOffset)
+
+	count := int(bd.SegmentCount())
+	if err := c.data(bd.DataOffset, 4*count, true); err != nil {
+		return err
+	}
+	list, err := c.r.dataAt(bd.DataOffset)
+	if err != nil {
+		return err
+	}
+	remaining := int(size)
+	for i := 0; i < count; i++ {
+		length := remaining
+		if length > block.BigDataSegmentSize {
+			length = block.BigDataSegmentSize
+		}
+		if err := c.data(int32(binary.LittleEndian.Uint32(list[i*4:])), length, false); err != nil {
+			return err
+		}
+		remaining -= length
+	}
+	return nil
+}
+
+// copySubkeyList copies the subkeys list and leaves of an index root
+func (c *compactor) copySubkeyList(old int32) error {
+	hc, err := c.r.cellAt(old)
+	if err != nil {
+		return err
+	}
+
+	var cp block.HCell
+	switch list := hc.(type) {
+	case *block.IndexRoot:
+		ir := *list
+		ir.Elements = append([]block.OffsetElement{}, list.Elements...)
+		ir.Padding = nil
+		for i := range ir.Elements {
+			c.refs = append(c.refs, (*int32)(&ir.Elements[i]))
+		}
+		if err := c.place(old, &ir); err != nil {
+			return err
+		}
+		for _, e := range list.Elements {
+			if err := c.copySubkeyList(int32(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *block.IndexLeaf:
+		il := *list
+		il.Elements = append([]block.OffsetElement{}, list.Elements...)
+		il.Padding = nil
+		for i := range il.Elements {
+			c.refs = append(c.refs, (*int32)(&il.Elements[i]))
+		}
+		cp = &il
+	case *block.FastLeaf:
+		lf := *list
+		lf.Elements = append([]block.NamedElement{}, list.Elements...)
+		lf.Padding = nil
+		for i := range lf.Elements {
+			c.refs = append(c.refs, &lf.Elements[i].Offset)
+		}
+		cp = &lf
+	case *block.HashLeaf:
+		lh := *list
+		lh.Elements = append([]block.NamedElement{}, list.Elements...)
+		lh.Padding = nil
+		for i := range lh.Elements {
+			c.refs = append(c.refs, &lh.Elements[i].Offset)
+		}
+		cp = &lh
+	default:
+		return cellTypeError(old, hc, "li, lf, lh or ri")
+	}
+	return c.place(old, cp)
+}
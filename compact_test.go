@@ -0,0 +1,139 @@
+package winrego
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testTreeContent lists paths, class names, security descriptors
+// and values of all keys in the hive
+func testTreeContent(t *testing.T, r *Registry) []string {
+	t.Helper()
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+
+	var content []string
+	err = root.Walk(func(k *Key) error {
+		path, err := k.Path()
+		if err != nil {
+			return err
+		}
+		_, class, err := k.ClassName()
+		if err != nil {
+			return err
+		}
+		sd, err := k.SecurityDescriptor()
+		if err != nil {
+			return err
+		}
+		content = append(content, fmt.Sprintf("%s class=%q sd=%x", path, class, sd.Bytes()))
+
+		values, err := k.Values()
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			data, err := v.Data()
+			if err != nil {
+				return err
+			}
+			content = append(content, fmt.Sprintf("%s:%s %s %x", path, v.Name(), block.TypeName(v.Type()), data))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk hive: %v", err)
+	}
+	return content
+}
+
+func TestCompact(t *testing.T) {
+	r := testTreeRegistry(t)
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+
+	// leave free cells and a second hbin behind
+	big := bytes.Repeat([]byte("compact"), 4000)
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		k, err := software.CreateSubkey(fmt.Sprintf("Temp%02d", i))
+		if err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+		if err := k.SetValue("Data", block.RegBinary, big[:100+i]); err != nil {
+			t.Fatalf("failed to set value: %v", err)
+		}
+	}
+	for i := 0; i < 50; i += 2 {
+		if err := software.DeleteSubkey(fmt.Sprintf("Temp%02d", i), false); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+	}
+	if err := software.SetValue("Big", block.RegBinary, big); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := software.SetValue("Small", block.RegSz, block.EncodeUTF16("small\x00")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := software.SetClassName("SoftwareClass"); err != nil {
+		t.Fatalf("failed to set class name: %v", err)
+	}
+	if err := root.DeleteSubkey("System", true); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+
+	want := testTreeContent(t, r)
+	size := r.HBinSize
+	if err := r.Compact(); err != nil {
+		t.Fatalf("failed to compact hive: %v", err)
+	}
+	if got := testTreeContent(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("hive content changed by compaction:\ngot  %q\nwant %q", got, want)
+	}
+
+	if r.HBinSize >= size || r.HBinSize != r.HBins.TotalSize() {
+		t.Errorf("hbins size %d should shrink from %d and match total size %d", r.HBinSize, size, r.HBins.TotalSize())
+	}
+	if r.Flags&block.BaseBlockDefragmented == 0 {
+		t.Errorf("base block should be marked as defragmented, flags %#x", r.Flags)
+	}
+	if got := r.LastRTimestamp & block.ReorganizationTypeMask; got != block.ReorganizationDefragmented || r.LastRTimestamp == block.ReorganizationDefragmented {
+		t.Errorf("last reorganized timestamp %#x should be set with defragmentation type", r.LastRTimestamp)
+	}
+	for _, hb := range r.HBins {
+		for i, hc := range hb.Cells {
+			if !hc.Allocated() && i != len(hb.Cells)-1 {
+				t.Errorf("unallocated cell at %#x should only end an hbin", hc.AbsoluteOffset())
+			}
+		}
+	}
+
+	reloaded := testReloadHive(t, r)
+	if got := testTreeContent(t, reloaded); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded hive content mismatch:\ngot  %q\nwant %q", got, want)
+	}
+	report, err := reloaded.Report()
+	if err != nil {
+		t.Fatalf("failed to report hive: %v", err)
+	}
+	if !report.Consistent() {
+		t.Errorf("compacted hive should be consistent, got %v", report.Issues)
+	}
+}
+
+func TestCompactNotLoaded(t *testing.T) {
+	if err := (&Registry{}).Compact(); err != ErrHBinsNotLoaded {
+		t.Errorf("expected ErrHBinsNotLoaded, got %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package winrego
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turekt/winrego/block"
+)
+
+type ChangeKind int
+
+const (
+	// Key or value exists only in the hive after the change
+	ChangeAdded ChangeKind = iota + 1
+	// Key or value exists only in the hive before the change
+	ChangeRemoved
+	// Key or value exists in both hives but differs
+	ChangeModified
+)
+
+func (c ChangeKind) String() string {
+	switch c {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	}
+	return fmt.Sprintf("change(%d)", int(c))
+}
+
+// ChangeField marks a difference of a modified key or value
+type ChangeField int
+
+const (
+	// Value data type differs
+	ChangedType ChangeField = 1 << iota
+	// Value data differs
+	ChangedData
+	// Key last written timestamp differs
+	ChangedTimestamp
+	// Key security descriptor differs
+	ChangedSecurity
+	// Key class name differs
+	ChangedClassName
+	// Key node flags differ, name compression is not compared
+	ChangedFlags
+)
+
+var changeFieldNames = []struct {
+	field ChangeField
+	name  string
+}{
+	{ChangedType, "type"},
+	{ChangedData, "data"},
+	{ChangedTimestamp, "timestamp"},
+	{ChangedSecurity, "security"},
+	{ChangedClassName, "class"},
+	{ChangedFlags, "flags"},
+}
+
+func (f ChangeField) Has(field ChangeField) bool {
+	return f&field == field
+}
+
+func (f ChangeField) String() string {
+	var names []string
+	for _, n := range changeFieldNames {
+		if f.Has(n.field) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Change is a single difference between two hives
+type Change struct {
+	Kind ChangeKind
+	// Path of the key relative to the root key, empty for the root key
+	Path string
+	// Set for value changes, Name is the name of the value
+	IsValue bool
+	Name    string
+	// Differences of a modified key or value
+	Fields ChangeField
+	// Key in the hive before and after the change, nil where it does
+	// not exist, for value changes this is the key holding the value
+	Before, After *Key
+	// Value in the hive before and after the change, nil where
+	// it does not exist or for key changes
+	BeforeValue, AfterValue *Value
+}
+
+// String renders the change on a single line, modified fields
+// are listed with their values before and after the change
+func (c Change) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Kind.String())
+	if c.IsValue {
+		fmt.Fprintf(&sb, " value %s %q", diffPath(c.Path), c.Name)
+	} else {
+		fmt.Fprintf(&sb, " key %s", diffPath(c.Path))
+	}
+	if c.Kind != ChangeModified {
+		return sb.String()
+	}
+
+	sep := ": "
+	for _, n := range changeFieldNames {
+		if !c.Fields.Has(n.field) {
+			continue
+		}
+		var before, after string
+		switch n.field {
+		case ChangedType:
+			before, after = block.TypeName(c.BeforeValue.Type()), block.TypeName(c.AfterValue.Type())
+		case ChangedData:
+			before, after = diffValueData(c.BeforeValue), diffValueData(c.AfterValue)
+		case ChangedTimestamp:
+			before, after = diffTimestamp(c.Before), diffTimestamp(c.After)
+		case ChangedSecurity:
+			before, after = diffSecurity(c.Before), diffSecurity(c.After)
+		case ChangedClassName:
+			before, after = diffClassName(c.Before), diffClassName(c.After)
+		case ChangedFlags:
+			before, after = c.Before.Flags().String(), c.After.Flags().String()
+		}
+		fmt.Fprintf(&sb, "%s%s %s -> %s", sep, n.name, before, after)
+		sep = ", "
+	}
+	return sb.String()
+}
+
+// ChangeList holds changes of keys in depth first order, a key change
+// is followed by changes of its values and then by its subkeys
+type ChangeList []Change
+
+// String renders the changes one per line
+func (cl ChangeList) String() string {
+	var sb strings.Builder
+	for _, c := range cl {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Diff walks both hives from their root keys and lists keys and values
+// added, removed or modified in after compared to before, keys and
+// values are matched by case insensitive names, all keys and values
+// of an added or removed subtree are listed
+func Diff(before, after *Registry) (ChangeList, error) {
+	b, err := before.Root()
+	if err != nil {
+		return nil, err
+	}
+	a, err := after.Root()
+	if err != nil {
+		return nil, err
+	}
+	var cl ChangeList
+	if err := cl.diffKey(b, a, ""); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// diffKey compares two keys at the same path with their values and subkeys
+func (cl *ChangeList) diffKey(before, after *Key, path string) error {
+	fields, err := diffKeyFields(before, after)
+	if err != nil {
+		return err
+	}
+	if fields != 0 {
+		*cl = append(*cl, Change{Kind: ChangeModified, Path: path, Fields: fields, Before: before, After: after})
+	}
+	if err := cl.diffValues(before, after, path); err != nil {
+		return err
+	}
+
+	beforeSubkeys, err := diffSubkeys(before)
+	if err != nil {
+		return err
+	}
+	afterSubkeys, err := diffSubkeys(after)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(beforeSubkeys)+len(afterSubkeys))
+	for name := range beforeSubkeys {
+		names = append(names, name)
+	}
+	for name := range afterSubkeys {
+		if _, ok := beforeSubkeys[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b, a := beforeSubkeys[name], afterSubkeys[name]
+		var err error
+		switch {
+		case b == nil:
+			err = cl.diffTree(ChangeAdded, a, joinPath(path, []string{a.Name()}))
+		case a == nil:
+			err = cl.diffTree(ChangeRemoved, b, joinPath(path, []string{b.Name()}))
+		default:
+			err = cl.diffKey(b, a, joinPath(path, []string{b.Name()}))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffTree lists the key with all of its values and descendants
+// as added or removed
+func (cl *ChangeList) diffTree(kind ChangeKind, k *Key, path string) error {
+	base, err := k.Path()
+	if err != nil {
+		return err
+	}
+	return k.Walk(func(key *Key) error {
+		keyPath, err := key.Path()
+		if err != nil {
+			return err
+		}
+		keyPath = path + strings.TrimPrefix(keyPath, base)
+
+		c := Change{Kind: kind, Path: keyPath}
+		if kind == ChangeAdded {
+			c.After = key
+		} else {
+			c.Before = key
+		}
+		*cl = append(*cl, c)
+
+		values, err := key.Values()
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			vc := c
+			vc.IsValue, vc.Name = true, v.Name()
+			if kind == ChangeAdded {
+				vc.AfterValue = v
+			} else {
+				vc.BeforeValue = v
+			}
+			*cl = append(*cl, vc)
+		}
+		return nil
+	})
+}
+
+// diffValues lists removed and modified values in order of the key
+// before the change followed by values added to the key after it
+func (cl *ChangeList) diffValues(before, after *Key, path string) error {
+	beforeValues, err := before.Values()
+	if err != nil {
+		return err
+	}
+	afterValues, err := after.Values()
+	if err != nil {
+		return err
+	}
+	matched := make(map[string]*Value, len(afterValues))
+	for _, v := range afterValues {
+		matched[block.UpcaseName(v.Name())] = v
+	}
+
+	seen := make(map[string]bool, len(beforeValues))
+	for _, b := range beforeValues {
+		name := block.UpcaseName(b.Name())
+		seen[name] = true
+		c := Change{Path: path, IsValue: true, Name: b.Name(), Before: before, After: after, BeforeValue: b}
+		a, ok := matched[name]
+		if !ok {
+			c.Kind = ChangeRemoved
+			*cl = append(*cl, c)
+			continue
+		}
+
+		if a.Type() != b.Type() {
+			c.Fields |= ChangedType
+		}
+		bData, err := b.Data()
+		if err != nil {
+			return err
+		}
+		aData, err := a.Data()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(bData, aData) {
+			c.Fields |= ChangedData
+		}
+		if c.Fields != 0 {
+			c.Kind, c.AfterValue = ChangeModified, a
+			*cl = append(*cl, c)
+		}
+	}
+
+	for _, a := range afterValues {
+		if !seen[block.UpcaseName(a.Name())] {
+			*cl = append(*cl, Change{Kind: ChangeAdded, Path: path, IsValue: true, Name: a.Name(), Before: before, After: after, AfterValue: a})
+		}
+	}
+	return nil
+}
+
+// diffKeyFields compares attributes of two keys other than
+// their names, values and subkeys
+func diffKeyFields(before, after *Key) (ChangeField, error) {
+	var fields ChangeField
+	if before.LastWTimestamp != after.LastWTimestamp {
+		fields |= ChangedTimestamp
+	}
+	if (before.Flags()^after.Flags())&^block.KeyCompName != 0 {
+		fields |= ChangedFlags
+	}
+
+	_, beforeClass, err := before.ClassName()
+	if err != nil {
+		return 0, err
+	}
+	_, afterClass, err := after.ClassName()
+	if err != nil {
+		return 0, err
+	}
+	if beforeClass != afterClass {
+		fields |= ChangedClassName
+	}
+
+	beforeSD, err := diffSecurityData(before)
+	if err != nil {
+		return 0, err
+	}
+	afterSD, err := diffSecurityData(after)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(beforeSD, afterSD) {
+		fields |= ChangedSecurity
+	}
+	return fields, nil
+}
+
+// diffSubkeys returns subkeys of the key by their upper case names
+func diffSubkeys(k *Key) (map[string]*Key, error) {
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+	named := make(map[string]*Key, len(subkeys))
+	for _, sk := range subkeys {
+		named[block.UpcaseName(sk.Name())] = sk
+	}
+	return named, nil
+}
+
+// diffSecurityData returns the security descriptor bytes of the key,
+// nil if the key does not reference a key security cell
+func diffSecurityData(k *Key) ([]byte, error) {
+	if k.KeySecurityOffset == NoCellOffset {
+		return nil, nil
+	}
+	ks, err := k.Security()
+	if err != nil {
+		return nil, err
+	}
+	return ks.SecDescriptor, nil
+}
+
+func diffPath(path string) string {
+	return `\` + path
+}
+
+func diffTimestamp(k *Key) string {
+	return k.LastWritten().UTC().Format(time.RFC3339Nano)
+}
+
+func diffClassName(k *Key) string {
+	_, class, err := k.ClassName()
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return fmt.Sprintf("%q", class)
+}
+
+// diffSecurity renders the security descriptor as SDDL
+// or in hex if it can not be converted
+func diffSecurity(k *Key) string {
+	data, err := diffSecurityData(k)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if data == nil {
+		return "<none>"
+	}
+	if sd, err := block.ParseSecurityDescriptor(data); err == nil {
+		if sddl, err := sd.SDDL(); err == nil {
+			return sddl
+		}
+	}
+	return fmt.Sprintf("%x", data)
+}
+
+// diffValueData renders value data decoded according to its type,
+// data that can not be decoded and binary data are rendered in hex
+func diffValueData(v *Value) string {
+	decoded, err := v.Decode()
+	if err != nil {
+		data, err := v.Data()
+		if err != nil {
+			return fmt.Sprintf("<%v>", err)
+		}
+		decoded = data
+	}
+	switch d := decoded.(type) {
+	case string, []string:
+		return fmt.Sprintf("%q", d)
+	case uint32, uint64:
+		return fmt.Sprintf("%#x", d)
+	}
+	return fmt.Sprintf("%x", decoded)
+}
@@ -0,0 +1,122 @@
+package winrego
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turekt/winrego/block"
+)
+
+func TestDiff(t *testing.T) {
+	before := testTreeRegistry(t)
+	after := testTreeRegistry(t)
+
+	if changes, err := Diff(before, after); err != nil || len(changes) != 0 {
+		t.Fatalf("identical hives should have no changes, got %v (%v)", changes, err)
+	}
+
+	software, err := after.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if err := software.SetValue("Version", block.RegSz, block.EncodeUTF16("7\x00")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := software.SetValue("Added", block.RegDWord, []byte{1, 0, 0, 0}); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	created, err := after.CreateKey(`Software\Vendor\Product\Component`)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if err := created.SetValue("Path", block.RegExpandSz, block.EncodeUTF16("%ProgramFiles%\x00")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	system, err := after.OpenKey("System")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if err := system.DeleteSubkey("Select", false); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if err := system.SetClassName("SystemClass"); err != nil {
+		t.Fatalf("failed to set class name: %v", err)
+	}
+	sd, err := block.ParseSDDL("O:BAG:SYD:(A;;KA;;;WD)")
+	if err != nil {
+		t.Fatalf("failed to parse SDDL: %v", err)
+	}
+	if err := system.SetSecurityDescriptor(sd); err != nil {
+		t.Fatalf("failed to set security descriptor: %v", err)
+	}
+
+	// only the timestamp of the ControlSet001 key is reported as changed
+	err = func() error {
+		root, err := after.Root()
+		if err != nil {
+			return err
+		}
+		return root.Walk(func(k *Key) error {
+			path, err := k.Path()
+			if err != nil {
+				return err
+			}
+			if b, err := before.OpenKey(path); err == nil {
+				k.LastWTimestamp = b.LastWTimestamp
+			}
+			return nil
+		})
+	}()
+	if err != nil {
+		t.Fatalf("failed to reset timestamps: %v", err)
+	}
+	cs, err := after.OpenKey(`System\ControlSet001`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	cs.LastWTimestamp = block.Filetime(time.Date(2024, 5, 1, 12, 30, 0, 100, time.UTC))
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("failed to diff hives: %v", err)
+	}
+	want := []string{
+		`modified value \Software "Version": type REG_DWORD -> REG_SZ, data 0x7 -> "7"`,
+		`added value \Software "Added"`,
+		`added key \Software\Vendor\Product`,
+		`added key \Software\Vendor\Product\Component`,
+		`added value \Software\Vendor\Product\Component "Path"`,
+		`modified key \System: security O:BAG:SYD:PAI(A;CI;KA;;;SY)(A;CI;KR;;;BU) -> O:BAG:SYD:(A;;KA;;;WD), class "" -> "SystemClass"`,
+		`modified key \System\ControlSet001: timestamp 1601-01-01T00:00:00Z -> 2024-05-01T12:30:00.0000001Z`,
+		`removed key \System\Select`,
+	}
+	got := strings.Split(strings.TrimSuffix(changes.String(), "\n"), "\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes mismatch:\ngot\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	c := changes[0]
+	if c.Kind != ChangeModified || !c.IsValue || c.Fields != ChangedType|ChangedData || c.BeforeValue == nil || c.AfterValue == nil {
+		t.Errorf("modified value change mismatch: %+v", c)
+	}
+	if c := changes[2]; c.Kind != ChangeAdded || c.IsValue || c.Before != nil || c.After == nil || c.After.Name() != "Product" {
+		t.Errorf("added key change mismatch: %+v", c)
+	}
+	if c := changes[5]; c.Fields.String() != "security|class" {
+		t.Errorf("modified fields mismatch: got %s", c.Fields)
+	}
+	if c := changes[7]; c.Kind != ChangeRemoved || c.Before == nil || c.After != nil {
+		t.Errorf("removed key change mismatch: %+v", c)
+	}
+
+	// reversed diff swaps added and removed
+	reversed, err := Diff(after, before)
+	if err != nil {
+		t.Fatalf("failed to diff hives: %v", err)
+	}
+	if got, want := reversed[len(reversed)-1].String(), `added key \System\Select`; got != want {
+		t.Errorf("reversed change mismatch: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,308 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/turekt/winrego/block"
+)
+
+type Severity int
+
+const (
+	// Structure can be read but lookups or tools may misbehave
+	SeverityWarning Severity = iota + 1
+	// Structure is broken and Windows would refuse or repair it
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Finding is a single problem found by Fsck
+type Finding struct {
+	// Offset of the hbin or cell from the start of hbins data
+	Offset   int32
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s at %#x: %s", f.Severity, f.Offset, f.Message)
+}
+
+// fsck collects findings while checking a registry
+type fsck struct {
+	r        *Registry
+	findings []Finding
+}
+
+func (c *fsck) add(offset int32, severity Severity, format string, args ...any) {
+	c.findings = append(c.findings, Finding{offset, severity, fmt.Sprintf(format, args...)})
+}
+
+// Fsck walks every hbin and cell of the hive and verifies hbin layout,
+// cell alignment and that every offset stored in key nodes, values,
+// key security cells, subkey lists and big data cells references an
+// allocated cell of the expected type, it also verifies counts, hashes
+// and ordering of subkey lists
+// Layout is checked over hbins bytes, a hive that can not be loaded
+// with ReadHBins can be loaded with ReadAllRaw to check its layout only
+func (r *Registry) Fsck() ([]Finding, error) {
+	data, err := r.hbinsData()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &fsck{r: r}
+	c.checkLayout(data)
+	for i := range r.HBins {
+		for _, hc := range r.HBins[i].Cells {
+			if !hc.Allocated() {
+				continue
+			}
+			switch cell := hc.(type) {
+			case *block.KeyNode:
+				c.checkKeyNode(cell)
+			case *block.KeySecurity:
+				c.checkKeySecurity(cell)
+			}
+		}
+	}
+	return c.findings, nil
+}
+
+// checkLayout walks hbin headers and cell size fields in hbins data,
+// walking stops where the following hbin or cell can not be located
+func (c *fsck) checkLayout(data []byte) {
+	for start := 0; start < len(data); {
+		offset := int32(start)
+		if len(data)-start < block.HBinHeaderSize {
+			c.add(offset, SeverityError, "%d bytes following the last hbin can not hold an hbin header", len(data)-start)
+			return
+		}
+		if sig := binary.LittleEndian.Uint32(data[start:]); sig != block.HBinHeaderSignature {
+			c.add(offset, SeverityError, "hbin signature %#x is invalid", sig)
+		}
+		if stored := int32(binary.LittleEndian.Uint32(data[start+4:])); stored != offset {
+			c.add(offset, SeverityError, "hbin offset %#x does not match its position", stored)
+		}
+		size := int32(binary.LittleEndian.Uint32(data[start+8:]))
+		if size < block.HBinAlignment || size%block.HBinAlignment != 0 {
+			c.add(offset, SeverityError, "hbin size %d is not a multiple of %d", size, block.HBinAlignment)
+		}
+		if size < block.HBinHeaderSize || int64(start)+int64(size) > int64(len(data)) {
+			c.add(offset, SeverityError, "hbin of size %d exceeds hbins data ending at %#x", size, len(data))
+			return
+		}
+		c.checkCells(data[start:start+int(size)], offset)
+		start += int(size)
+	}
+}
+
+// checkCells walks cell size fields of the hbin at offset
+func (c *fsck) checkCells(hbin []byte, offset int32) {
+	size := int32(len(hbin))
+	for next := int32(block.HBinHeaderSize); next < size; {
+		if size-next < block.HCellSizeLength {
+			c.add(offset+next, SeverityError, "%d bytes at the end of hbin can not hold a cell", size-next)
+			return
+		}
+		cellSize := int32(binary.LittleEndian.Uint32(hbin[next:]))
+		if cellSize < 0 {
+			cellSize = -cellSize
+		}
+		if cellSize < block.HCellSizeLength {
+			c.add(offset+next, SeverityError, "cell size %d is invalid", cellSize)
+			return
+		}
+		if cellSize%block.HCellAlignment != 0 {
+			c.add(offset+next, SeverityError, "cell of size %d is not aligned to %d bytes", cellSize, block.HCellAlignment)
+		}
+		if next+cellSize > size {
+			c.add(offset+next, SeverityError, "cell of size %d straddles hbin boundary at %#x", cellSize, offset+size)
+			return
+		}
+		next += cellSize
+	}
+}
+
+// ref returns the cell referenced from the cell at offset if it is
+// allocated and has one of the expected signatures, any signature
+// is accepted for data cells when none is provided
+func (c *fsck) ref(from int32, field string, offset int32, signatures ...string) block.HCell {
+	hc, err := c.r.cellAt(offset)
+	if err != nil {
+		c.add(from, SeverityError, "%s %#x does not reference a cell", field, offset)
+		return nil
+	}
+	if !hc.Allocated() {
+		c.add(from, SeverityError, "%s %#x references an unallocated cell", field, offset)
+		return nil
+	}
+	if len(signatures) == 0 {
+		return hc
+	}
+	for _, sig := range signatures {
+		if hc.Signature() == sig {
+			return hc
+		}
+	}
+	c.add(from, SeverityError, "%s %#x references a cell with signature %q, expected %v", field, offset, hc.Signature(), signatures)
+	return nil
+}
+
+func (c *fsck) checkKeyNode(kn *block.KeyNode) {
+	offset := kn.AbsoluteOffset()
+	root := offset == int32(c.r.RootCellOffset)
+
+	if !root {
+		c.ref(offset, "parent", kn.KeyNodeData.Parent, "nk")
+	}
+	c.ref(offset, "key security offset", kn.KeySecurityOffset, "sk")
+	if kn.ClassNameLength > 0 {
+		if hc := c.ref(offset, "class name offset", kn.ClassNameOffset); hc != nil && hc.Size()-block.HCellSizeLength < int32(uint16(kn.ClassNameLength)) {
+			c.add(offset, SeverityError, "class name cell holds less than %d bytes", kn.ClassNameLength)
+		}
+	}
+
+	if kn.SubkeysCount > 0 {
+		var names []string
+		c.checkSubkeyList(kn, kn.SubkeysListOffset, true, &names)
+		if int(kn.SubkeysCount) != len(names) {
+			c.add(offset, SeverityError, "subkeys count %d does not match %d keys in subkeys list", kn.SubkeysCount, len(names))
+		}
+		for i := 1; i < len(names); i++ {
+			if block.UpcaseName(names[i-1]) >= block.UpcaseName(names[i]) {
+				c.add(kn.SubkeysListOffset, SeverityError, "subkeys list is not sorted, %q follows %q", names[i], names[i-1])
+			}
+		}
+	}
+
+	if kn.KeyValuesCount > 0 {
+		c.checkValuesList(kn)
+	}
+}
+
+// checkSubkeyList verifies elements of a subkeys list and collects key
+// names in list order, index roots can only reference leaf lists
+func (c *fsck) checkSubkeyList(kn *block.KeyNode, listOffset int32, allowRoot bool, names *[]string) {
+	signatures := []string{"li", "lf", "lh"}
+	if allowRoot {
+		signatures = append(signatures, "ri")
+	}
+	hc := c.ref(kn.AbsoluteOffset(), "subkeys list offset", listOffset, signatures...)
+
+	switch list := hc.(type) {
+	case *block.IndexRoot:
+		for _, e := range list.Elements {
+			c.checkSubkeyList(kn, int32(e), false, names)
+		}
+	case *block.IndexLeaf:
+		for _, e := range list.Elements {
+			c.checkSubkey(kn, listOffset, int32(e), names)
+		}
+	case *block.FastLeaf:
+		for _, e := range list.Elements {
+			if sk := c.checkSubkey(kn, listOffset, e.Offset, names); sk != nil && !block.NameHintMatches(e.Name, sk.Name()) {
+				c.add(listOffset, SeverityWarning, "name hint %q does not match key %q", e.Name, sk.Name())
+			}
+		}
+	case *block.HashLeaf:
+		for _, e := range list.Elements {
+			sk := c.checkSubkey(kn, listOffset, e.Offset, names)
+			if hash := binary.LittleEndian.Uint32(e.Name[:]); sk != nil && hash != block.NameHash(sk.Name()) {
+				c.add(listOffset, SeverityWarning, "name hash %#x does not match key %q", hash, sk.Name())
+			}
+		}
+	}
+}
+
+func (c *fsck) checkSubkey(parent *block.KeyNode, listOffset int32, offset int32, names *[]string) *block.KeyNode {
+	sk, ok := c.ref(listOffset, "subkey offset", offset, "nk").(*block.KeyNode)
+	if !ok {
+		return nil
+	}
+	*names = append(*names, sk.Name())
+	if sk.KeyNodeData.Parent != parent.AbsoluteOffset() {
+		c.add(offset, SeverityWarning, "parent %#x does not match listing key %#x", sk.KeyNodeData.Parent, parent.AbsoluteOffset())
+	}
+	return sk
+}
+
+func (c *fsck) checkValuesList(kn *block.KeyNode) {
+	offset := kn.AbsoluteOffset()
+	hc := c.ref(offset, "values list offset", kn.KeyValuesListOffset)
+	if hc == nil {
+		return
+	}
+	data, err := cellPayload(hc)
+	if err != nil {
+		c.add(kn.KeyValuesListOffset, SeverityError, "values list: %v", err)
+		return
+	}
+	if int(kn.KeyValuesCount)*4 > len(data) {
+		c.add(offset, SeverityError, "values count %d exceeds values list of %d bytes", kn.KeyValuesCount, len(data))
+		return
+	}
+
+	for i := 0; i < int(kn.KeyValuesCount); i++ {
+		vOffset := int32(binary.LittleEndian.Uint32(data[i*4:]))
+		if kv, ok := c.ref(kn.KeyValuesListOffset, "value offset", vOffset, "vk").(*block.KeyValue); ok {
+			c.checkKeyValue(kv)
+		}
+	}
+}
+
+func (c *fsck) checkKeyValue(kv *block.KeyValue) {
+	size := kv.DataLength()
+	if kv.IsDataInline() || size == 0 {
+		return
+	}
+
+	offset := kv.AbsoluteOffset()
+	if !block.UsesBigData(c.r.Minor, size) {
+		if hc := c.ref(offset, "data offset", kv.DataOffset); hc != nil && uint32(hc.Size()-block.HCellSizeLength) < size {
+			c.add(offset, SeverityError, "data cell holds less than %d bytes", size)
+		}
+		return
+	}
+
+	bd, ok := c.ref(offset, "data offset", kv.DataOffset, "db").(*block.BigData)
+	if !ok {
+		return
+	}
+	hc := c.ref(bd.AbsoluteOffset(), "segment list offset", bd.DataOffset)
+	if hc == nil {
+		return
+	}
+	list, err := cellPayload(hc)
+	if err != nil {
+		c.add(bd.DataOffset, SeverityError, "segment list: %v", err)
+		return
+	}
+	count := int(bd.SegmentCount())
+	if count*4 > len(list) {
+		c.add(bd.AbsoluteOffset(), SeverityError, "segment count %d exceeds segment list of %d bytes", count, len(list))
+		return
+	}
+	if uint32(count)*block.BigDataSegmentSize < size {
+		c.add(bd.AbsoluteOffset(), SeverityError, "%d segments can not hold %d bytes", count, size)
+	}
+	for i := 0; i < count; i++ {
+		c.ref(bd.DataOffset, "segment offset", int32(binary.LittleEndian.Uint32(list[i*4:])))
+	}
+}
+
+func (c *fsck) checkKeySecurity(ks *block.KeySecurity) {
+	offset := ks.AbsoluteOffset()
+	c.ref(offset, "flink", ks.Flink, "sk")
+	c.ref(offset, "blink", ks.Blink, "sk")
+}
@@ -0,0 +1,206 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+func TestFsck(t *testing.T) {
+	r := testTreeRegistry(t)
+	findings, err := r.Fsck()
+	if err != nil {
+		t.Fatalf("failed to check registry: %v", err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("test hive should have no findings, got %v", findings)
+	}
+}
+
+func TestFsckFindings(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Modify   func(r *Registry, keys map[string]*Key) int32
+		Severity Severity
+	}{
+		{
+			"wrong hash",
+			func(r *Registry, keys map[string]*Key) int32 {
+				hc, _ := r.cellAt(keys["Software"].SubkeysListOffset)
+				hc.(*block.HashLeaf).Elements[0].Name[0]++
+				return keys["Software"].SubkeysListOffset
+			},
+			SeverityWarning,
+		},
+		{
+			"unsorted list",
+			func(r *Registry, keys map[string]*Key) int32 {
+				hc, _ := r.cellAt(keys["ROOT"].SubkeysListOffset)
+				e := hc.(*block.FastLeaf).Elements
+				e[0], e[1] = e[1], e[0]
+				return keys["ROOT"].SubkeysListOffset
+			},
+			SeverityError,
+		},
+		{
+			"subkeys count",
+			func(r *Registry, keys map[string]*Key) int32 {
+				keys["System"].SubkeysCount = 3
+				return keys["System"].AbsoluteOffset()
+			},
+			SeverityError,
+		},
+		{
+			"wrong signature",
+			func(r *Registry, keys map[string]*Key) int32 {
+				keys["Vendor"].KeySecurityOffset = keys["Software"].AbsoluteOffset()
+				return keys["Vendor"].AbsoluteOffset()
+			},
+			SeverityError,
+		},
+		{
+			"unallocated",
+			func(r *Registry, keys map[string]*Key) int32 {
+				values, _ := keys["Software"].Values()
+				values[0].BlockSize *= -1
+				return keys["Software"].KeyValuesListOffset
+			},
+			SeverityError,
+		},
+		{
+			"parent mismatch",
+			func(r *Registry, keys map[string]*Key) int32 {
+				keys["Select"].KeyNodeData.Parent = keys["Software"].AbsoluteOffset()
+				return keys["Select"].AbsoluteOffset()
+			},
+			SeverityWarning,
+		},
+	}
+
+	for _, tc := range testCases {
+		r := testTreeRegistry(t)
+		root, err := r.Root()
+		if err != nil {
+			t.Fatalf("failed to get root: %v", err)
+		}
+		keys := make(map[string]*Key)
+		if err := root.Walk(func(k *Key) error {
+			keys[k.Name()] = k
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to walk keys: %v", err)
+		}
+
+		offset := tc.Modify(r, keys)
+		findings, err := r.Fsck()
+		if err != nil {
+			t.Fatalf("%s: failed to check registry: %v", tc.Name, err)
+		}
+		found := false
+		for _, f := range findings {
+			found = found || (f.Offset == offset && f.Severity == tc.Severity)
+		}
+		if !found {
+			t.Errorf("%s: expected %s at %#x, got %v", tc.Name, tc.Severity, offset, findings)
+		}
+	}
+}
+
+func TestFsckLayout(t *testing.T) {
+	image, err := testTreeRegistry(t).Bytes(WriteAllMarshal)
+	if err != nil {
+		t.Fatalf("failed to marshal registry: %v", err)
+	}
+	first := int32(block.HBinHeaderSize)
+	cellSize := func(data []byte) int32 {
+		return -int32(binary.LittleEndian.Uint32(data[block.BaseBlockSize+first:]))
+	}
+
+	testCases := []struct {
+		Name    string
+		Corrupt func(hbins []byte)
+		Offset  int32
+	}{
+		{
+			"hbin size",
+			func(hbins []byte) {
+				binary.LittleEndian.PutUint32(hbins[8:], block.HBinAlignment/2)
+			},
+			0,
+		},
+		{
+			"hbin signature",
+			func(hbins []byte) {
+				copy(hbins, "xbin")
+			},
+			0,
+		},
+		{
+			"straddling cell",
+			func(hbins []byte) {
+				size := int32(-2 * block.HBinAlignment)
+				binary.LittleEndian.PutUint32(hbins[first:], uint32(size))
+			},
+			first,
+		},
+		{
+			"unaligned cell",
+			func(hbins []byte) {
+				binary.LittleEndian.PutUint32(hbins[first:], uint32(-(cellSize(image) + 4)))
+			},
+			first,
+		},
+	}
+
+	for _, tc := range testCases {
+		data := append([]byte{}, image...)
+		tc.Corrupt(data[block.BaseBlockSize:])
+
+		r := &Registry{}
+		if err := r.Load(data, ReadAllRaw); err != nil {
+			t.Fatalf("%s: failed to load raw hive: %v", tc.Name, err)
+		}
+		findings, err := r.Fsck()
+		if err != nil {
+			t.Fatalf("%s: failed to check registry: %v", tc.Name, err)
+		}
+		found := false
+		for _, f := range findings {
+			found = found || (f.Offset == tc.Offset && f.Severity == SeverityError)
+		}
+		if !found {
+			t.Errorf("%s: expected error at %#x, got %v", tc.Name, tc.Offset, findings)
+		}
+	}
+
+	// layout of an intact raw hive is clean
+	r := &Registry{}
+	if err := r.Load(image, ReadAllRaw); err != nil {
+		t.Fatalf("failed to load raw hive: %v", err)
+	}
+	if findings, err := r.Fsck(); err != nil || len(findings) != 0 {
+		t.Errorf("raw test hive should have no findings, got %v (%v)", findings, err)
+	}
+}
+
+func TestFsckNonASCIIHint(t *testing.T) {
+	th := newTestHive()
+	kn := testKeyNode("ROOT", NoCellOffset)
+	root := th.add(kn)
+	// Latin-1 characters are stored in hints of compressed names
+	apple := th.add(testKeyNode("Äpfel", root))
+	list := th.add(testFastLeaf(block.NamedElement{Offset: apple, Name: [4]byte{0xc4, 'p', 'f', 'e'}}))
+	kn.SubkeysCount, kn.SubkeysListOffset = 1, list
+	r := th.registry(t, root)
+
+	findings, err := r.Fsck()
+	if err != nil {
+		t.Fatalf("failed to check registry: %v", err)
+	}
+	for _, f := range findings {
+		if f.Offset == list {
+			t.Errorf("valid hint of non-ASCII name reported: %v", f)
+		}
+	}
+}
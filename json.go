@@ -0,0 +1,406 @@
+package winrego
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/turekt/winrego/block"
+)
+
+var (
+	ErrJSON = errors.New("invalid hive JSON")
+)
+
+// JSONKey is a key as exported to JSON, keys are listed in depth first
+// order with parents preceding their subkeys
+type JSONKey struct {
+	// Path relative to the exported key, empty for the exported key
+	Path      string            `json:"path"`
+	Name      string            `json:"name"`
+	LastWrite time.Time         `json:"lastWrite"`
+	ClassName string            `json:"className,omitempty"`
+	Flags     block.KeyNodeFlag `json:"flags"`
+	// Undecoded class name bytes instead of ClassName when
+	// decoding would lose bytes, e.g. for an odd length
+	ClassRaw []byte `json:"classRaw,omitempty"`
+	// Index to security descriptors, nil if the key references none
+	Security *int        `json:"security,omitempty"`
+	Values   []JSONValue `json:"values,omitempty"`
+}
+
+// JSONValue is a value as exported to JSON, data is decoded according
+// to its type as by Value.Decode with binary data encoded in base64,
+// Raw holds the undecoded data instead when decoding would lose bytes,
+// e.g. for a REG_DWORD value that is not 4 bytes long
+type JSONValue struct {
+	Name string          `json:"name"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+	Raw  []byte          `json:"raw,omitempty"`
+}
+
+// JSONSecurity is a security descriptor referenced by exported keys,
+// SDDL is informational and omitted when it can not be produced
+type JSONSecurity struct {
+	SDDL string `json:"sddl,omitempty"`
+	Data []byte `json:"data"`
+}
+
+// ExportJSON writes the key at path, relative to the root key, and
+// all of its descendants to w as JSON, empty path exports the whole hive
+func (r *Registry) ExportJSON(w io.Writer, path string) error {
+	key, err := r.OpenKey(path)
+	if err != nil {
+		return err
+	}
+	return key.ExportJSON(w)
+}
+
+// ExportJSON writes this key and all of its descendants to w as a JSON
+// object holding security descriptors followed by keys, one per line,
+// keys are written as they are walked without buffering the whole tree
+func (k *Key) ExportJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	base, err := k.Path()
+	if err != nil {
+		return err
+	}
+
+	// security descriptors are indexed in order of first reference
+	security := make(map[int32]int)
+	bw.WriteString("{\"securityDescriptors\":[")
+	err = k.Walk(func(key *Key) error {
+		if _, ok := security[key.KeySecurityOffset]; ok || key.KeySecurityOffset == NoCellOffset {
+			return nil
+		}
+		ks, err := key.Security()
+		if err != nil {
+			return err
+		}
+		js := JSONSecurity{Data: ks.SecDescriptor}
+		if sd, err := ks.Descriptor(); err == nil {
+			js.SDDL, _ = sd.SDDL()
+		}
+		if err := writeJSONElement(bw, js, len(security) == 0); err != nil {
+			return err
+		}
+		security[key.KeySecurityOffset] = len(security)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bw.WriteString("\n],\"keys\":[")
+	first := true
+	err = k.Walk(func(key *Key) error {
+		jk, err := key.jsonKey(base, security)
+		if err != nil {
+			return err
+		}
+		err = writeJSONElement(bw, jk, first)
+		first = false
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteString("\n]}\n")
+	return bw.Flush()
+}
+
+// writeJSONElement writes an array element on its own line
+func writeJSONElement(w *bufio.Writer, v any, first bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !first {
+		w.WriteByte(',')
+	}
+	w.WriteByte('\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// jsonKey converts the key, its path is made relative to base
+func (k *Key) jsonKey(base string, security map[int32]int) (*JSONKey, error) {
+	path, err := k.Path()
+	if err != nil {
+		return nil, err
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, base), `\`)
+	raw, class, err := k.ClassName()
+	if err != nil {
+		return nil, err
+	}
+
+	jk := &JSONKey{
+		Path:      path,
+		Name:      k.Name(),
+		LastWrite: k.LastWritten().UTC(),
+		ClassName: class,
+		Flags:     k.Flags(),
+	}
+	if !bytes.Equal(block.EncodeUTF16(class), raw) {
+		jk.ClassName, jk.ClassRaw = "", append([]byte{}, raw...)
+	}
+	if index, ok := security[k.KeySecurityOffset]; ok {
+		jk.Security = &index
+	}
+
+	values, err := k.Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		jv, err := v.jsonValue()
+		if err != nil {
+			return nil, err
+		}
+		jk.Values = append(jk.Values, *jv)
+	}
+	return jk, nil
+}
+
+// jsonValue converts the value, data that can not be decoded
+// and encoded back to the same bytes is kept raw
+func (v *Value) jsonValue() (*JSONValue, error) {
+	data, err := v.Data()
+	if err != nil {
+		return nil, err
+	}
+	jv := &JSONValue{Name: v.Name(), Type: block.TypeName(v.Type())}
+	if decoded, err := v.Decode(); err == nil {
+		if encoded, err := encodeValueData(v.Type(), decoded); err == nil && bytes.Equal(encoded, data) {
+			if jv.Data, err = json.Marshal(decoded); err != nil {
+				return nil, err
+			}
+			return jv, nil
+		}
+	}
+	jv.Raw = append([]byte{}, data...)
+	return jv, nil
+}
+
+// encodeValueData encodes data decoded by Value.Decode back to bytes
+func encodeValueData(dataType uint32, decoded any) ([]byte, error) {
+	switch d := decoded.(type) {
+	case string:
+		return block.EncodeUTF16(d + "\x00"), nil
+	case []string:
+		if len(d) == 0 {
+			return block.EncodeUTF16("\x00"), nil
+		}
+		return block.EncodeUTF16(strings.Join(d, "\x00") + "\x00\x00"), nil
+	case uint32:
+		data := make([]byte, 4)
+		if dataType == block.RegDWordBigEndian {
+			binary.BigEndian.PutUint32(data, d)
+		} else {
+			binary.LittleEndian.PutUint32(data, d)
+		}
+		return data, nil
+	case uint64:
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, d)
+		return data, nil
+	case []byte:
+		return d, nil
+	}
+	return nil, fmt.Errorf("%w: can not encode %T", ErrDataType, decoded)
+}
+
+// decodeJSONData decodes data of a JSON value into the
+// form returned by Value.Decode for its type
+func decodeJSONData(dataType uint32, data json.RawMessage) (any, error) {
+	var decoded any
+	switch dataType {
+	case block.RegSz, block.RegExpandSz, block.RegLink:
+		decoded = new(string)
+	case block.RegMultiSz:
+		decoded = new([]string)
+	case block.RegDWord, block.RegDWordBigEndian:
+		decoded = new(uint32)
+	case block.RegQWord:
+		decoded = new(uint64)
+	default:
+		decoded = new([]byte)
+	}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(decoded).Elem().Interface(), nil
+}
+
+// ImportJSON builds a new hive from JSON written by ExportJSON, the
+// first key becomes the root key and keys are created as they are read
+func ImportJSON(rd io.Reader) (*Registry, error) {
+	dec := json.NewDecoder(rd)
+	if err := expectJSONDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var r *Registry
+	var security []*block.SecurityDescriptor
+	// timestamps are set last as creating subkeys updates them
+	timestamps := make(map[int32]uint64)
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case "securityDescriptors":
+			var descriptors []JSONSecurity
+			if err := dec.Decode(&descriptors); err != nil {
+				return nil, err
+			}
+			for i, js := range descriptors {
+				sd, err := block.ParseSecurityDescriptor(js.Data)
+				if err != nil {
+					return nil, fmt.Errorf("%w: security descriptor %d: %v", ErrJSON, i, err)
+				}
+				security = append(security, sd)
+			}
+		case "keys":
+			if err := expectJSONDelim(dec, '['); err != nil {
+				return nil, err
+			}
+			for dec.More() {
+				var jk JSONKey
+				if err := dec.Decode(&jk); err != nil {
+					return nil, err
+				}
+				if r, err = importJSONKey(r, &jk, security, timestamps); err != nil {
+					return nil, fmt.Errorf("%w: key %q: %v", ErrJSON, jk.Path, err)
+				}
+			}
+			if err := expectJSONDelim(dec, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := expectJSONDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("%w: no keys", ErrJSON)
+	}
+
+	for offset, ts := range timestamps {
+		k, err := r.keyAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		k.LastWTimestamp = ts
+	}
+	root, err := r.Root()
+	if err != nil {
+		return nil, err
+	}
+	r.LastWTimestamp = root.LastWTimestamp
+	r.HBins[0].Timestamp = root.LastWTimestamp
+	return r, r.UpdateChecksum()
+}
+
+// importJSONKey creates the key in the hive, the hive is created
+// with the first key as its root key when r is nil
+func importJSONKey(r *Registry, jk *JSONKey, security []*block.SecurityDescriptor, timestamps map[int32]uint64) (*Registry, error) {
+	var sd *block.SecurityDescriptor
+	if jk.Security != nil {
+		if *jk.Security < 0 || *jk.Security >= len(security) {
+			return r, fmt.Errorf("security descriptor %d is not defined", *jk.Security)
+		}
+		sd = security[*jk.Security]
+	}
+
+	var key *Key
+	var err error
+	if r == nil {
+		if jk.Path != "" {
+			return nil, fmt.Errorf("first key should be the root key with empty path")
+		}
+		if r, err = NewRegistry(jk.Name, sd); err != nil {
+			return nil, err
+		}
+		if key, err = r.Root(); err != nil {
+			return r, err
+		}
+	} else {
+		if jk.Path == "" {
+			return r, fmt.Errorf("root key is listed more than once")
+		}
+		if key, err = r.CreateKey(jk.Path); err != nil {
+			return r, err
+		}
+		if sd != nil {
+			if err := key.SetSecurityDescriptor(sd); err != nil {
+				return r, err
+			}
+		}
+	}
+
+	// compressed name flag depends on how the name was encoded, the
+	// root of a hive is its only hive entry and can not be deleted
+	flags := jk.Flags&^(block.KeyCompName|block.KeyHiveEntry) | key.Flags()&block.KeyCompName
+	if key.IsRoot() {
+		flags |= block.KeyHiveEntry | block.KeyNoDelete
+	}
+	key.SetFlags(flags)
+	if jk.ClassRaw != nil {
+		if err := key.setClassNameData(jk.ClassRaw); err != nil {
+			return r, err
+		}
+	} else if jk.ClassName != "" {
+		if err := key.SetClassName(jk.ClassName); err != nil {
+			return r, err
+		}
+	}
+	for _, jv := range jk.Values {
+		dataType, err := block.ParseTypeName(jv.Type)
+		if err != nil {
+			return r, err
+		}
+		data := jv.Raw
+		if jv.Raw == nil && jv.Data != nil {
+			decoded, err := decodeJSONData(dataType, jv.Data)
+			if err != nil {
+				return r, fmt.Errorf("value %q: %v", jv.Name, err)
+			}
+			if data, err = encodeValueData(dataType, decoded); err != nil {
+				return r, err
+			}
+		}
+		if err := key.SetValue(jv.Name, dataType, data); err != nil {
+			return r, err
+		}
+	}
+	timestamps[key.AbsoluteOffset()] = block.Filetime(jk.LastWrite)
+	return r, nil
+}
+
+// expectJSONDelim reads the next token which has to be the delimiter
+func expectJSONDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("%w: expected %v, got %v", ErrJSON, delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package winrego
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testJSONRegistry returns the hive with values of every type, a class
+// name and a key with its own security descriptor
+func testJSONRegistry(t *testing.T) *Registry {
+	r := testRegRegistry(t)
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	root.SetFlags(root.Flags() | block.KeyHiveEntry | block.KeyNoDelete)
+	vendor, err := r.OpenKey(`Software\Vendor`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if err := vendor.SetClassName("VendorClass"); err != nil {
+		t.Fatalf("failed to set class name: %v", err)
+	}
+	sd, err := block.ParseSDDL("O:BAG:SYD:(A;;KA;;;WD)")
+	if err != nil {
+		t.Fatalf("failed to parse SDDL: %v", err)
+	}
+	if err := vendor.SetSecurityDescriptor(sd); err != nil {
+		t.Fatalf("failed to set security descriptor: %v", err)
+	}
+	return r
+}
+
+// testJSONDocument is the decoded output of ExportJSON
+type testJSONDocument struct {
+	SecurityDescriptors []JSONSecurity `json:"securityDescriptors"`
+	Keys                []JSONKey      `json:"keys"`
+}
+
+func TestExportJSON(t *testing.T) {
+	r := testJSONRegistry(t)
+
+	var buf bytes.Buffer
+	if err := r.ExportJSON(&buf, ""); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+	var doc testJSONDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode export: %v\n%s", err, buf.String())
+	}
+	if got, want := strings.Count(buf.String(), "\n"), len(doc.SecurityDescriptors)+len(doc.Keys)+3; got != want {
+		t.Errorf("each element should be on its own line, got %d lines, want %d", got, want)
+	}
+
+	var paths []string
+	for _, k := range doc.Keys {
+		paths = append(paths, k.Path)
+	}
+	want := []string{"", "Software", `Software\Vendor`, "System", `System\ControlSet001`, `System\Select`}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("key paths mismatch: got %q, want %q", paths, want)
+	}
+	if doc.Keys[0].Name != "ROOT" || doc.Keys[2].Name != "Vendor" || doc.Keys[2].ClassName != "VendorClass" {
+		t.Errorf("key names mismatch: got %q, %q with class %q", doc.Keys[0].Name, doc.Keys[2].Name, doc.Keys[2].ClassName)
+	}
+	if doc.Keys[0].LastWrite.IsZero() || doc.Keys[0].LastWrite.Location().String() != "UTC" {
+		t.Errorf("last write time should be set in UTC, got %v", doc.Keys[0].LastWrite)
+	}
+
+	if len(doc.SecurityDescriptors) != 2 {
+		t.Fatalf("security descriptors should be exported once, got %d", len(doc.SecurityDescriptors))
+	}
+	if got := doc.SecurityDescriptors[1].SDDL; got != "O:BAG:SYD:(A;;KA;;;WD)" {
+		t.Errorf("security descriptor SDDL mismatch: got %q", got)
+	}
+	for i, k := range doc.Keys {
+		index := 0
+		if k.Path == `Software\Vendor` {
+			index = 1
+		}
+		if k.Security == nil || *k.Security != index {
+			t.Errorf("key %d security reference mismatch: got %v, want %d", i, k.Security, index)
+		}
+	}
+
+	values := make(map[string]string)
+	for _, v := range doc.Keys[1].Values {
+		values[v.Name] = v.Type + " " + string(v.Data)
+		if v.Raw != nil {
+			values[v.Name] += " raw " + string(v.Raw)
+		}
+	}
+	for name, want := range map[string]string{
+		"Version":  "REG_DWORD 7",
+		"":         `REG_SZ "C:\\Program Files \"x86\""`,
+		"Expand":   `REG_EXPAND_SZ "%SystemRoot%"`,
+		"Multi":    `REG_MULTI_SZ ["a","b"]`,
+		"Quad":     "REG_QWORD 81985529216486895",
+		`Bin"ary\`: `REG_BINARY "q6urq6urq6urq6urq6urq6urq6urq6urq6urq6ur"`,
+		"None":     `REG_NONE ""`,
+		"Odd":      "REG_SZ  raw x\x00y",
+		"Short":    "REG_DWORD  raw \x01\x02",
+		"List":     `REG_RESOURCE_REQUIREMENTS_LIST "AQ=="`,
+	} {
+		if got := values[name]; got != want {
+			t.Errorf("value %q mismatch: got %q, want %q", name, got, want)
+		}
+	}
+
+	// subtree paths are relative to the exported key
+	buf.Reset()
+	if err := r.ExportJSON(&buf, "software"); err != nil {
+		t.Fatalf("failed to export subtree: %v", err)
+	}
+	doc = testJSONDocument{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	if len(doc.Keys) != 2 || doc.Keys[0].Path != "" || doc.Keys[0].Name != "Software" || doc.Keys[1].Path != "Vendor" {
+		t.Errorf("subtree keys mismatch: got %+v", doc.Keys)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	r := testJSONRegistry(t)
+	var exported bytes.Buffer
+	if err := r.ExportJSON(&exported, ""); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+
+	imported, err := ImportJSON(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import hive: %v", err)
+	}
+	if got, want := testTreeContent(t, imported), testTreeContent(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported hive mismatch:\ngot  %q\nwant %q", got, want)
+	}
+
+	// export of the imported hive is identical including timestamps
+	reloaded := testReloadHive(t, imported)
+	var buf bytes.Buffer
+	if err := reloaded.ExportJSON(&buf, ""); err != nil {
+		t.Fatalf("failed to export imported hive: %v", err)
+	}
+	if got, want := buf.String(), exported.String(); got != want {
+		t.Errorf("export of imported hive mismatch:\ngot\n%s\nwant\n%s", got, want)
+	}
+
+	// subtree is imported as a hive of its own, hive entry
+	// flag moves from an exported subkey to the new root
+	source, err := r.OpenKey(`Software\Vendor`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	source.SetFlags(source.Flags() | block.KeyHiveEntry)
+	buf.Reset()
+	if err := r.ExportJSON(&buf, "Software"); err != nil {
+		t.Fatalf("failed to export subtree: %v", err)
+	}
+	subtree, err := ImportJSON(&buf)
+	if err != nil {
+		t.Fatalf("failed to import subtree: %v", err)
+	}
+	root, err := subtree.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	if root.Name() != "Software" {
+		t.Errorf("root key name mismatch: got %q, want Software", root.Name())
+	}
+	if !root.Flags().Has(block.KeyHiveEntry | block.KeyNoDelete) {
+		t.Errorf("imported root key should be the hive entry, got flags %s", root.Flags())
+	}
+	vendor, err := subtree.OpenKey("Vendor")
+	if err != nil {
+		t.Fatalf("failed to open imported key: %v", err)
+	}
+	if vendor.Flags().Has(block.KeyHiveEntry) {
+		t.Errorf("imported subkey should not be a hive entry, got flags %s", vendor.Flags())
+	}
+}
+
+func TestJSONClassRaw(t *testing.T) {
+	r := testJSONRegistry(t)
+	raws := map[string][]byte{
+		// odd length and unpaired surrogate do not survive decoding
+		"System":               {'x', 0, 'y'},
+		`System\ControlSet001`: {0x00, 0xd8, 'a', 0},
+	}
+	for path, raw := range raws {
+		k, err := r.OpenKey(path)
+		if err != nil {
+			t.Fatalf("failed to open key: %v", err)
+		}
+		if err := k.setClassNameData(raw); err != nil {
+			t.Fatalf("failed to set class name: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.ExportJSON(&buf, ""); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+	var doc testJSONDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	for _, jk := range doc.Keys {
+		want, ok := raws[jk.Path]
+		if !ok {
+			if jk.ClassRaw != nil {
+				t.Errorf("key %q should have no raw class name, got %x", jk.Path, jk.ClassRaw)
+			}
+			continue
+		}
+		if !bytes.Equal(jk.ClassRaw, want) || jk.ClassName != "" {
+			t.Errorf("key %q class name mismatch: got %q raw %x, want raw %x", jk.Path, jk.ClassName, jk.ClassRaw, want)
+		}
+	}
+
+	imported, err := ImportJSON(&buf)
+	if err != nil {
+		t.Fatalf("failed to import hive: %v", err)
+	}
+	for path, want := range raws {
+		k, err := imported.OpenKey(path)
+		if err != nil {
+			t.Fatalf("failed to open imported key: %v", err)
+		}
+		if got, _, err := k.ClassName(); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("imported class name of %q mismatch: got %x (%v), want %x", path, got, err, want)
+		}
+	}
+}
+
+func TestImportJSONInvalid(t *testing.T) {
+	for _, text := range []string{
+		`[]`,
+		`{}`,
+		`{"keys":[]}`,
+		`{"keys":[{"path":"Software","name":"Software"}]}`,
+		`{"keys":[{"path":"","name":"ROOT","security":0}]}`,
+		`{"keys":[{"path":"","name":"ROOT","values":[{"name":"x","type":"REG_TEXT"}]}]}`,
+		`{"keys":[{"path":"","name":"ROOT","values":[{"name":"x","type":"REG_DWORD","data":"x"}]}]}`,
+		`{"securityDescriptors":[{"data":"AQ=="}],"keys":[]}`,
+	} {
+		if _, err := ImportJSON(strings.NewReader(text)); !errors.Is(err, ErrJSON) {
+			t.Errorf("expected ErrJSON for %s, got %v", text, err)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/turekt/winrego/block"
+)
+
+const (
+	// Offset value used in hive cells to mark a missing reference
+	NoCellOffset = -1
+)
+
+var (
+	ErrHBinsNotLoaded = errors.New("hbins are not loaded, open registry with ReadHBins mode")
+	ErrValueNotFound  = errors.New("value not found")
+	ErrCellLoop       = errors.New("cell is referenced more than once")
+)
+
+// Key is a high level view of a key node that resolves
+// the offsets stored in the key node against its registry
+type Key struct {
+	*block.KeyNode
+	registry *Registry
+}
+
+// KeyNotFoundError is returned when a key path lookup fails
+// because a path component does not exist in the hive
+type KeyNotFoundError struct {
+	// Path that was looked up
+	Path string
+	// Name of the first path component that was not found
+	Name string
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key %q not found in path %q", e.Name, e.Path)
+}
+
+func (r *Registry) Root() (*Key, error) {
+	return r.keyAt(int32(r.RootCellOffset))
+}
+
+// OpenKey returns the key located at a backslash separated
+// path relative to the root key, e.g. "ControlSet001\\Services"
+func (r *Registry) OpenKey(path string) (*Key, error) {
+	root, err := r.Root()
+	if err != nil {
+		return nil, err
+	}
+	return root.OpenSubkey(path)
+}
+
+func (r *Registry) cellAt(offset int32) (block.HCell, error) {
+	if len(r.HBins) == 0 {
+		return nil, ErrHBinsNotLoaded
+	}
+	return r.HBins.CellAt(offset)
+}
+
+func (r *Registry) keyAt(offset int32) (*Key, error) {
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	kn, ok := hc.(*block.KeyNode)
+	if !ok {
+		return nil, cellTypeError(offset, hc, "nk")
+	}
+	return &Key{kn, r}, nil
+}
+
+func (r *Registry) dataAt(offset int32) ([]byte, error) {
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return cellPayload(hc)
+}
+
+// allocate places the cell in the hive and keeps the hbins
+// size in the base block in sync, returns the cell offset
+func (r *Registry) allocate(hc block.HCell) (int32, error) {
+	if len(r.HBins) == 0 {
+		return 0, ErrHBinsNotLoaded
+	}
+	r.modified = true
+	return block.NewAllocator(&r.BaseBlock, &r.HBins).Allocate(hc)
+}
+
+func (r *Registry) free(offset int32) error {
+	if offset == NoCellOffset {
+		return nil
+	}
+	if len(r.HBins) == 0 {
+		return ErrHBinsNotLoaded
+	}
+	r.modified = true
+	return block.NewAllocator(&r.BaseBlock, &r.HBins).Free(offset)
+}
+
+// cellPayload returns cell bytes following the size field, data cells
+// can be parsed as other cell types when their data starts with a
+// known signature so they are marshaled back in that case
+func cellPayload(hc block.HCell) ([]byte, error) {
+	if dr, ok := hc.(*block.DataRecord); ok {
+		return dr.Data, nil
+	}
+
+	data, err := block.Marshal(hc)
+	if err != nil {
+		return nil, err
+	}
+	return data[block.HCellSizeLength:], nil
+}
+
+func (r *Registry) securityAt(offset int32) (*block.KeySecurity, error) {
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ks, ok := hc.(*block.KeySecurity)
+	if !ok {
+		return nil, cellTypeError(offset, hc, "sk")
+	}
+	return ks, nil
+}
+
+// subkeyOffsets follows the subkeys list at the provided offset,
+// descending into index roots, and returns key node offsets
+func (r *Registry) subkeyOffsets(listOffset int32) ([]int32, error) {
+	return r.listSubkeyOffsets(listOffset, make(map[int32]bool))
+}
+
+// listSubkeyOffsets expands the subkeys list, visited holds offsets
+// of lists already expanded to detect index roots forming a loop
+func (r *Registry) listSubkeyOffsets(listOffset int32, visited map[int32]bool) ([]int32, error) {
+	if visited[listOffset] {
+		return nil, fmt.Errorf("%w: subkeys list at offset %#x", ErrCellLoop, listOffset)
+	}
+	visited[listOffset] = true
+
+	hc, err := r.cellAt(listOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	var offsets []int32
+	switch list := hc.(type) {
+	case *block.IndexLeaf:
+		for _, e := range list.Elements {
+			offsets = append(offsets, int32(e))
+		}
+	case *block.FastLeaf:
+		for _, e := range list.Elements {
+			offsets = append(offsets, e.Offset)
+		}
+	case *block.HashLeaf:
+		for _, e := range list.Elements {
+			offsets = append(offsets, e.Offset)
+		}
+	case *block.IndexRoot:
+		for _, e := range list.Elements {
+			sub, err := r.listSubkeyOffsets(int32(e), visited)
+			if err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, sub...)
+		}
+	default:
+		return nil, cellTypeError(listOffset, hc, "li, lf, lh or ri")
+	}
+
+	return offsets, nil
+}
+
+func (k *Key) LastWritten() time.Time {
+	return block.ParseFiletime(k.LastWTimestamp)
+}
+
+func (k *Key) IsRoot() bool {
+	return k.AbsoluteOffset() == int32(k.registry.RootCellOffset)
+}
+
+// Parent returns the parent key or nil if this is the root key
+func (k *Key) Parent() (*Key, error) {
+	if k.IsRoot() {
+		return nil, nil
+	}
+	return k.registry.keyAt(k.KeyNodeData.Parent)
+}
+
+// Path returns the backslash separated path of this key relative to
+// the root key as accepted by OpenKey, root key path is empty
+func (k *Key) Path() (string, error) {
+	var names []string
+	visited := make(map[int32]bool)
+	for key := k; !key.IsRoot(); {
+		if visited[key.AbsoluteOffset()] {
+			return "", fmt.Errorf("key at offset %#x is its own ancestor", key.AbsoluteOffset())
+		}
+		visited[key.AbsoluteOffset()] = true
+		names = append(names, key.Name())
+
+		parent, err := key.Parent()
+		if err != nil {
+			return "", err
+		}
+		key = parent
+	}
+
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, `\`), nil
+}
+
+// Security returns the key security cell referenced by this key
+func (k *Key) Security() (*block.KeySecurity, error) {
+	return k.registry.securityAt(k.KeySecurityOffset)
+}
+
+func (k *Key) SecurityDescriptor() (*block.SecurityDescriptor, error) {
+	ks, err := k.Security()
+	if err != nil {
+		return nil, err
+	}
+	return ks.Descriptor()
+}
+
+// ClassName returns the raw class name bytes and the class name
+// decoded from UTF-16, both are empty if the key has no class name
+func (k *Key) ClassName() ([]byte, string, error) {
+	if k.ClassNameLength == 0 || k.ClassNameOffset == NoCellOffset {
+		return nil, "", nil
+	}
+
+	data, err := k.registry.dataAt(k.ClassNameOffset)
+	if err != nil {
+		return nil, "", err
+	}
+	length := int(uint16(k.ClassNameLength))
+	if length > len(data) {
+		return nil, "", fmt.Errorf("class name at offset %#x holds less than %d bytes", k.ClassNameOffset, length)
+	}
+	raw := data[:length]
+	return raw, block.DecodeUTF16(raw), nil
+}
+
+// SetClassName stores the class name encoded as UTF-16 in a new
+// data cell and releases the previous one, empty name removes it
+func (k *Key) SetClassName(name string) error {
+	return k.setClassNameData(block.EncodeUTF16(name))
+}
+
+// setClassNameData stores raw class name bytes as they are
+func (k *Key) setClassNameData(raw []byte) error {
+	if len(raw) > math.MaxInt16 {
+		return fmt.Errorf("class name of %d bytes is too long", len(raw))
+	}
+
+	offset := int32(NoCellOffset)
+	if len(raw) > 0 {
+		var err error
+		dr := &block.DataRecord{Data: raw}
+		if offset, err = k.registry.allocate(dr); err != nil {
+			return err
+		}
+	}
+	if k.ClassNameLength != 0 {
+		if err := k.registry.free(k.ClassNameOffset); err != nil {
+			return err
+		}
+	}
+	k.ClassNameOffset = offset
+	k.ClassNameLength = int16(len(raw))
+
+	parent, err := k.Parent()
+	if err != nil || parent == nil {
+		return err
+	}
+	if parent.LSubkeyClassNameLength < int32(len(raw)) {
+		parent.LSubkeyClassNameLength = int32(len(raw))
+	}
+	return nil
+}
+
+func (k *Key) Subkeys() ([]*Key, error) {
+	if k.SubkeysCount == 0 || k.SubkeysListOffset == NoCellOffset {
+		return nil, nil
+	}
+
+	offsets, err := k.registry.subkeyOffsets(k.SubkeysListOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(offsets))
+	for _, offset := range offsets {
+		sk, err := k.registry.keyAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sk)
+	}
+	return keys, nil
+}
+
+// OpenSubkey returns the key located at a backslash
+// separated path relative to this key
+func (k *Key) OpenSubkey(path string) (*Key, error) {
+	key := k
+	for _, name := range strings.Split(path, `\`) {
+		if name == "" {
+			continue
+		}
+
+		sk, err := key.Subkey(name)
+		if err != nil {
+			var notFound *KeyNotFoundError
+			if errors.As(err, &notFound) {
+				notFound.Path = path
+			}
+			return nil, err
+		}
+		key = sk
+	}
+	return key, nil
+}
+
+// Subkey returns the direct subkey with the provided name,
+// names are compared case insensitively
+func (k *Key) Subkey(name string) (*Key, error) {
+	if k.SubkeysCount != 0 && k.SubkeysListOffset != NoCellOffset {
+		sk, err := k.registry.findSubkey(k.SubkeysListOffset, name)
+		if err != nil || sk != nil {
+			return sk, err
+		}
+	}
+	return nil, &KeyNotFoundError{Path: name, Name: name}
+}
+
+// findSubkey searches the subkeys list at the provided offset for a key
+// with the provided name, hashes and hints stored in hash and fast
+// leaves are used to skip key nodes that cannot match
+func (r *Registry) findSubkey(listOffset int32, name string) (*Key, error) {
+	return r.findListSubkey(listOffset, name, make(map[int32]bool))
+}
+
+// findListSubkey searches the subkeys list, visited holds offsets of
+// lists already searched to detect index roots forming a loop
+func (r *Registry) findListSubkey(listOffset int32, name string, visited map[int32]bool) (*Key, error) {
+	if visited[listOffset] {
+		return nil, fmt.Errorf("%w: subkeys list at offset %#x", ErrCellLoop, listOffset)
+	}
+	visited[listOffset] = true
+
+	hc, err := r.cellAt(listOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	var offsets []int32
+	switch list := hc.(type) {
+	case *block.IndexLeaf:
+		for _, e := range list.Elements {
+			offsets = append(offsets, int32(e))
+		}
+	case *block.FastLeaf:
+		for _, e := range list.Elements {
+			if block.NameHintMatches(e.Name, name) {
+				offsets = append(offsets, e.Offset)
+			}
+		}
+	case *block.HashLeaf:
+		hash := block.NameHash(name)
+		for _, e := range list.Elements {
+			if binary.LittleEndian.Uint32(e.Name[:]) == hash {
+				offsets = append(offsets, e.Offset)
+			}
+		}
+	case *block.IndexRoot:
+		for _, e := range list.Elements {
+			sk, err := r.findListSubkey(int32(e), name, visited)
+			if err != nil || sk != nil {
+				return sk, err
+			}
+		}
+		return nil, nil
+	default:
+		return nil, cellTypeError(listOffset, hc, "li, lf, lh or ri")
+	}
+
+	for _, offset := range offsets {
+		sk, err := r.keyAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if block.EqualNames(sk.Name(), name) {
+			return sk, nil
+		}
+	}
+	return nil, nil
+}
+
+// Walk calls fn for this key and all of its descendants in depth
+// first order, walking stops at the first error returned and fails
+// with ErrCellLoop when a key node is reached more than once
+func (k *Key) Walk(fn func(k *Key) error) error {
+	return k.walk(fn, make(map[int32]bool))
+}
+
+func (k *Key) walk(fn func(k *Key) error, visited map[int32]bool) error {
+	if visited[k.AbsoluteOffset()] {
+		return fmt.Errorf("%w: key node at offset %#x", ErrCellLoop, k.AbsoluteOffset())
+	}
+	visited[k.AbsoluteOffset()] = true
+
+	if err := fn(k); err != nil {
+		return err
+	}
+
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return err
+	}
+	for _, sk := range subkeys {
+		if err := sk.walk(fn, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *Key) Values() ([]*Value, error) {
+	if k.KeyValuesCount == 0 || k.KeyValuesListOffset == NoCellOffset {
+		return nil, nil
+	}
+
+	data, err := k.registry.dataAt(k.KeyValuesListOffset)
+	if err != nil {
+		return nil, err
+	}
+	if int(k.KeyValuesCount)*4 > len(data) {
+		return nil, fmt.Errorf("values list at offset %#x holds less than %d values", k.KeyValuesListOffset, k.KeyValuesCount)
+	}
+
+	values := make([]*Value, 0, k.KeyValuesCount)
+	for i := 0; i < int(k.KeyValuesCount); i++ {
+		offset := int32(binary.LittleEndian.Uint32(data[i*4:]))
+		v, err := k.registry.valueAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Value returns the value with the provided name, names are compared
+// case insensitively and an empty name denotes the default value
+func (k *Key) Value(name string) (*Value, error) {
+	values, err := k.Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		if block.EqualNames(v.Name(), name) {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q in key %q", ErrValueNotFound, name, k.Name())
+}
+
+func cellTypeError(offset int32, hc block.HCell, expect string) error {
+	return fmt.Errorf("cell at offset %#x has signature %q, expected %s", offset, hc.Signature(), expect)
+}
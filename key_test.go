@@ -0,0 +1,393 @@
+package winrego
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+const testTreeSDDL = "O:BAG:SYD:PAI(A;CI;KA;;;SY)(A;CI;KR;;;BU)"
+
+// testTreeHive builds the following hive:
+//
+//	ROOT (lf list)
+//	├── Software (lh list)  [Version: REG_DWORD 7]
+//	│   └── Vendor
+//	└── System (ri list of two li lists)
+//	    ├── ControlSet001
+//	    └── Select
+//
+// All keys share a single security descriptor
+func testTreeHive() (*testHive, int32) {
+	th := newTestHive()
+	root := th.add(testKeyNode("ROOT", 0))
+	software := th.add(testKeyNode("Software", root))
+	vendor := th.add(testKeyNode("Vendor", software))
+	system := th.add(testKeyNode("System", root))
+	cs := th.add(testKeyNode("ControlSet001", system))
+	sel := th.add(testKeyNode("Select", system))
+
+	version := th.add(testKeyValue("Version", block.RegDWord, -0x7ffffffc, 7))
+	softwareValues := th.add(testOffsetList(version))
+	softwareList := th.add(testHashLeaf(testHashElement(vendor, "Vendor")))
+	rootList := th.add(testFastLeaf(
+		testHintElement(software, "Software"),
+		testHintElement(system, "System"),
+	))
+	li1 := th.add(&block.IndexLeaf{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{block.OffsetElement(cs)},
+	})
+	li2 := th.add(&block.IndexLeaf{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{block.OffsetElement(sel)},
+	})
+	systemList := th.add(&block.IndexRoot{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'r', 'i'}, Metadata: 2},
+		Elements:  []block.OffsetElement{block.OffsetElement(li1), block.OffsetElement(li2)},
+	})
+
+	sk := th.add(testKeySecurity(testTreeSDDL))
+	ks := th.cells[len(th.cells)-1].(*block.KeySecurity)
+	ks.Flink, ks.Blink, ks.RefCount = sk, sk, 6
+	for _, hc := range th.cells {
+		if kn, ok := hc.(*block.KeyNode); ok {
+			kn.KeySecurityOffset = sk
+		}
+	}
+
+	th.cells[0].(*block.KeyNode).SubkeysCount = 2
+	th.cells[0].(*block.KeyNode).SubkeysListOffset = rootList
+	th.cells[1].(*block.KeyNode).SubkeysCount = 1
+	th.cells[1].(*block.KeyNode).SubkeysListOffset = softwareList
+	th.cells[1].(*block.KeyNode).KeyValuesCount = 1
+	th.cells[1].(*block.KeyNode).KeyValuesListOffset = softwareValues
+	th.cells[3].(*block.KeyNode).SubkeysCount = 2
+	th.cells[3].(*block.KeyNode).SubkeysListOffset = systemList
+	return th, root
+}
+
+func testTreeRegistry(t *testing.T) *Registry {
+	th, root := testTreeHive()
+	return th.registry(t, root)
+}
+
+func keyNames(keys []*Key) []string {
+	var names []string
+	for _, k := range keys {
+		names = append(names, k.Name())
+	}
+	return names
+}
+
+func TestKeyTreeNavigation(t *testing.T) {
+	r := testTreeRegistry(t)
+
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	if got, want := root.Name(), "ROOT"; got != want {
+		t.Errorf("root name mismatch: got %s, want %s", got, want)
+	}
+	if parent, err := root.Parent(); parent != nil || err != nil {
+		t.Errorf("root key parent should be nil, got %v, %v", parent, err)
+	}
+
+	subkeys, err := root.Subkeys()
+	if err != nil {
+		t.Fatalf("failed to get root subkeys: %v", err)
+	}
+	if got, want := keyNames(subkeys), []string{"Software", "System"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("root subkeys mismatch: got %v, want %v", got, want)
+	}
+
+	systemKeys, err := subkeys[1].Subkeys()
+	if err != nil {
+		t.Fatalf("failed to get index root subkeys: %v", err)
+	}
+	if got, want := keyNames(systemKeys), []string{"ControlSet001", "Select"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index root subkeys mismatch: got %v, want %v", got, want)
+	}
+
+	parent, err := systemKeys[0].Parent()
+	if err != nil {
+		t.Fatalf("failed to get parent: %v", err)
+	}
+	if got, want := parent.Name(), "System"; got != want {
+		t.Errorf("parent name mismatch: got %s, want %s", got, want)
+	}
+
+	values, err := subkeys[0].Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("value count mismatch: got %d, want 1", len(values))
+	}
+	if got, want := values[0].Name(), "Version"; got != want {
+		t.Errorf("value name mismatch: got %s, want %s", got, want)
+	}
+	if got, want := values[0].Type(), uint32(block.RegDWord); got != want {
+		t.Errorf("value type mismatch: got %d, want %d", got, want)
+	}
+
+	if values, err := root.Values(); len(values) != 0 || err != nil {
+		t.Errorf("root key should have no values, got %v, %v", values, err)
+	}
+}
+
+func TestKeyTreeNotLoaded(t *testing.T) {
+	r := &Registry{}
+	if _, err := r.Root(); err != ErrHBinsNotLoaded {
+		t.Errorf("expected ErrHBinsNotLoaded, got %v", err)
+	}
+}
+
+func TestKeyTreeRealHives(t *testing.T) {
+	testFiles := testFilesList(t)
+
+	for _, testFile := range testFiles {
+		r, err := OpenRegistry(testFile, ReadAllUnmarshal)
+		if err != nil {
+			t.Fatalf("failed to open registry %s: %v", testFile, err)
+		}
+
+		root, err := r.Root()
+		if err != nil {
+			t.Fatalf("failed to get root key of %s: %v", testFile, err)
+		}
+
+		keys := []*Key{root}
+		for len(keys) > 0 {
+			k := keys[0]
+			keys = keys[1:]
+			subkeys, err := k.Subkeys()
+			if err != nil {
+				t.Fatalf("failed to list subkeys of %s in %s: %v", k.Name(), testFile, err)
+			}
+			if _, err := k.Values(); err != nil {
+				t.Fatalf("failed to list values of %s in %s: %v", k.Name(), testFile, err)
+			}
+			keys = append(keys, subkeys...)
+		}
+	}
+}
+
+func TestOpenKey(t *testing.T) {
+	r := testTreeRegistry(t)
+
+	testCases := []struct {
+		Path string
+		Name string
+	}{
+		{"", "ROOT"},
+		{`Software`, "Software"},
+		{`SOFTWARE\vendor`, "Vendor"},
+		{`\software\Vendor\`, "Vendor"},
+		{`System\controlset001`, "ControlSet001"},
+		{`system\SELECT`, "Select"},
+	}
+	for _, tc := range testCases {
+		k, err := r.OpenKey(tc.Path)
+		if err != nil {
+			t.Errorf("failed to open key %q: %v", tc.Path, err)
+			continue
+		}
+		if got, want := k.Name(), tc.Name; got != want {
+			t.Errorf("key name for %q mismatch: got %s, want %s", tc.Path, got, want)
+		}
+	}
+
+	notFoundCases := []struct {
+		Path string
+		Name string
+	}{
+		{`Softwar`, "Softwar"},
+		{`Software\Vendor\Product`, "Product"},
+		{`System\ControlSet002`, "ControlSet002"},
+	}
+	for _, tc := range notFoundCases {
+		_, err := r.OpenKey(tc.Path)
+		var notFound *KeyNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Errorf("expected KeyNotFoundError for %q, got %v", tc.Path, err)
+			continue
+		}
+		if notFound.Path != tc.Path || notFound.Name != tc.Name {
+			t.Errorf("not found error mismatch: got %+v, want path %q name %q", notFound, tc.Path, tc.Name)
+		}
+	}
+}
+
+func TestKeySecurityDescriptor(t *testing.T) {
+	r := testTreeRegistry(t)
+
+	k, err := r.OpenKey(`System\Select`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	sd, err := k.SecurityDescriptor()
+	if err != nil {
+		t.Fatalf("failed to get security descriptor: %v", err)
+	}
+	sddl, err := sd.SDDL()
+	if err != nil {
+		t.Fatalf("failed to convert security descriptor: %v", err)
+	}
+	if got, want := sddl, testTreeSDDL; got != want {
+		t.Errorf("security descriptor mismatch: got %s, want %s", got, want)
+	}
+}
+
+func TestKeyNonASCIINames(t *testing.T) {
+	th := newTestHive()
+	root := th.add(testKeyNode("ROOT", 0))
+	latin := th.add(testKeyNode("Größe", root))
+	wide := th.add(testKeyNode("日本語", root))
+	value := th.add(testKeyValue("Wert ü", block.RegNone, 0, NoCellOffset))
+	values := th.add(testOffsetList(value))
+	list := th.add(testFastLeaf(testHintElement(latin, "Größe"), testHintElement(wide, "日本語")))
+	kn := th.cells[0].(*block.KeyNode)
+	kn.SubkeysCount, kn.SubkeysListOffset = 2, list
+	kn.KeyValuesCount, kn.KeyValuesListOffset = 1, values
+
+	r := th.registry(t, root)
+	for _, path := range []string{"GRÖßE", "größe", "日本語"} {
+		if _, err := r.OpenKey(path); err != nil {
+			t.Errorf("failed to open key %q: %v", path, err)
+		}
+	}
+
+	k, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	vs, err := k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	if got, want := vs[0].Name(), "Wert ü"; got != want {
+		t.Errorf("value name mismatch: got %q, want %q", got, want)
+	}
+}
+
+func TestKeyClassName(t *testing.T) {
+	th, root := testTreeHive()
+	class := th.add(testDataRecord(block.EncodeUTF16("JD8a4f")))
+	vendor := th.cells[2].(*block.KeyNode)
+	vendor.ClassNameOffset, vendor.ClassNameLength = class, 12
+	r := th.registry(t, root)
+
+	k, err := r.OpenKey(`Software\Vendor`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	raw, name, err := k.ClassName()
+	if err != nil {
+		t.Fatalf("failed to get class name: %v", err)
+	}
+	if got, want := name, "JD8a4f"; got != want {
+		t.Errorf("class name mismatch: got %q, want %q", got, want)
+	}
+	if got, want := raw, block.EncodeUTF16("JD8a4f"); !reflect.DeepEqual(got, want) {
+		t.Errorf("raw class name mismatch: got %v, want %v", got, want)
+	}
+
+	if err := k.SetClassName("a much longer class name"); err != nil {
+		t.Fatalf("failed to set class name: %v", err)
+	}
+	data, err := r.Bytes(WriteAllMarshal)
+	if err != nil {
+		t.Fatalf("failed to marshal registry: %v", err)
+	}
+	reloaded := &Registry{}
+	if err := reloaded.Load(data, ReadAllUnmarshal); err != nil {
+		t.Fatalf("failed to load registry: %v", err)
+	}
+	if k, err = reloaded.OpenKey(`Software\Vendor`); err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if _, name, err = k.ClassName(); err != nil || name != "a much longer class name" {
+		t.Errorf("class name mismatch after write: got %q, %v", name, err)
+	}
+	parent, err := k.Parent()
+	if err != nil {
+		t.Fatalf("failed to get parent: %v", err)
+	}
+	if got, want := parent.LSubkeyClassNameLength, int32(48); got != want {
+		t.Errorf("largest subkey class name length mismatch: got %d, want %d", got, want)
+	}
+	if hc, err := reloaded.cellAt(class); err != nil || hc.(*block.DataRecord).BlockSize < 0 {
+		t.Errorf("previous class name cell should be unallocated, got %v", err)
+	}
+
+	if err := k.SetClassName(""); err != nil {
+		t.Fatalf("failed to clear class name: %v", err)
+	}
+	if raw, name, err := k.ClassName(); raw != nil || name != "" || err != nil {
+		t.Errorf("class name should be empty, got %v, %q, %v", raw, name, err)
+	}
+}
+
+func TestKeyTreeLoops(t *testing.T) {
+	th := newTestHive()
+	root := testKeyNode("ROOT", NoCellOffset)
+	rootOffset := th.add(root)
+	child := testKeyNode("Child", rootOffset)
+	childOffset := th.add(child)
+	looped := testKeyNode("Looped", rootOffset)
+	loopedOffset := th.add(looped)
+
+	// subkeys list of Child points back to its parent
+	root.SubkeysCount, root.SubkeysListOffset = 1, th.add(&block.IndexLeaf{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{block.OffsetElement(childOffset)},
+	})
+	child.SubkeysCount, child.SubkeysListOffset = 1, th.add(&block.IndexLeaf{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{block.OffsetElement(rootOffset)},
+	})
+
+	// index roots of Looped point at each other
+	first := &block.IndexRoot{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'r', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{0},
+	}
+	firstOffset := th.add(first)
+	secondOffset := th.add(&block.IndexRoot{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'r', 'i'}, Metadata: 1},
+		Elements:  []block.OffsetElement{block.OffsetElement(firstOffset)},
+	})
+	first.Elements[0] = block.OffsetElement(secondOffset)
+	looped.SubkeysCount, looped.SubkeysListOffset = 1, firstOffset
+
+	r := th.registry(t, rootOffset)
+	rk, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	visits := 0
+	if err := rk.Walk(func(*Key) error { visits++; return nil }); !errors.Is(err, ErrCellLoop) {
+		t.Errorf("expected ErrCellLoop walking back to an ancestor, got %v", err)
+	}
+	if visits != 2 {
+		t.Errorf("walk should stop at the repeated key, got %d visits", visits)
+	}
+	if _, err := r.OpenKey(`Child\ROOT\Child`); err != nil {
+		t.Errorf("lookup of a path through the loop should succeed, got %v", err)
+	}
+
+	lk, err := r.keyAt(loopedOffset)
+	if err != nil {
+		t.Fatalf("failed to get key: %v", err)
+	}
+	if _, err := lk.Subkeys(); !errors.Is(err, ErrCellLoop) {
+		t.Errorf("expected ErrCellLoop for looped index roots, got %v", err)
+	}
+	if _, err := lk.Subkey("x"); !errors.Is(err, ErrCellLoop) {
+		t.Errorf("expected ErrCellLoop searching looped index roots, got %v", err)
+	}
+}
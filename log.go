@@ -0,0 +1,232 @@
+package winrego
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/turekt/winrego/block"
+)
+
+var (
+	ErrInvalidLog = errors.New("invalid transaction log")
+)
+
+type LogFormat int
+
+const (
+	// Log of HvLE entries written since Windows 8.1
+	LogFormatEntries LogFormat = iota
+	// Legacy log holding a DIRT dirty vector and dirty sectors
+	LogFormatDirtyVector
+)
+
+// TransactionLog holds the content of a .LOG, .LOG1 or .LOG2
+// file that can be replayed onto a dirty hive
+type TransactionLog struct {
+	// Partial base block copy stored at the start of the log
+	block.BaseBlock
+	Format LogFormat
+	// Log entries in the order they are stored in the log, legacy
+	// logs hold a single entry built from the dirty vector
+	Entries []*block.LogEntry
+	// Dirty vector of legacy logs, nil for other formats
+	DirtyVector *block.DirtyVector
+}
+
+func OpenTransactionLog(filepath string) (*TransactionLog, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTransactionLog(data)
+}
+
+// ParseTransactionLog parses the base block copy followed by either
+// log entries or a dirty vector, parsing of log entries stops at the
+// first sector that does not hold a log entry
+func ParseTransactionLog(data []byte) (*TransactionLog, error) {
+	if len(data) < block.LogBaseBlockSize {
+		return nil, fmt.Errorf("%w: log of size %d is smaller than base block", ErrInvalidLog, len(data))
+	}
+
+	tl := &TransactionLog{}
+	header := make([]byte, block.BaseBlockSize)
+	copy(header, data[:block.LogBaseBlockSize])
+	if err := block.Unmarshal(&tl.BaseBlock, header); err != nil {
+		return nil, err
+	}
+	if tl.RegfHeader != block.BaseBlockSignature {
+		return nil, fmt.Errorf("%w: base block signature %#x", ErrInvalidLog, tl.RegfHeader)
+	}
+
+	if len(data) >= block.LogBaseBlockSize+4 && string(data[block.LogBaseBlockSize:block.LogBaseBlockSize+4]) == block.DirtyVectorSignature {
+		return tl, tl.parseDirtyVector(data)
+	}
+
+	for offset := block.LogBaseBlockSize; offset+block.LogEntryHeaderSize <= len(data); {
+		if string(data[offset:offset+4]) != block.LogEntrySignature {
+			break
+		}
+		le := &block.LogEntry{}
+		if err := block.Unmarshal(le, data[offset:]); err != nil {
+			break
+		}
+		tl.Entries = append(tl.Entries, le)
+		offset += int(le.EntrySize)
+	}
+	return tl, nil
+}
+
+// parseDirtyVector reads the dirty vector of a legacy log and
+// collects dirty sectors into a single log entry
+func (tl *TransactionLog) parseDirtyVector(data []byte) error {
+	tl.Format = LogFormatDirtyVector
+	tl.DirtyVector = block.NewDirtyVector(tl.HBinSize)
+	if err := block.Unmarshal(tl.DirtyVector, data[block.LogBaseBlockSize:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLog, err)
+	}
+
+	refs := tl.DirtyVector.DirtyPages()
+	le := &block.LogEntry{
+		LogEntryHeader: block.LogEntryHeader{
+			Flags:           tl.Flags,
+			Sequence:        tl.Sequence1,
+			HBinSize:        tl.HBinSize,
+			DirtyPagesCount: uint32(len(refs)),
+		},
+		Refs: refs,
+	}
+
+	start := block.LogBaseBlockSize + int(tl.DirtyVector.Size())
+	start = (start + block.LogSectorSize - 1) &^ (block.LogSectorSize - 1)
+	for _, ref := range refs {
+		end := start + int(ref.Size)
+		if end > len(data) {
+			return fmt.Errorf("%w: dirty page at offset %#x is out of bounds", ErrInvalidLog, ref.Offset)
+		}
+		le.Pages = append(le.Pages, data[start:end])
+		start = end
+	}
+	tl.Entries = []*block.LogEntry{le}
+	return nil
+}
+
+// valid reports whether the log entry can be replayed, legacy logs
+// carry no entry hashes and are valid when they were fully written
+func (tl *TransactionLog) valid(le *block.LogEntry) bool {
+	if tl.Format == LogFormatDirtyVector {
+		return tl.Sequence1 == tl.Sequence2
+	}
+	return le.Verify()
+}
+
+// ReplayLogs applies valid log entries from the provided logs of any
+// format onto the registry in sequence order and returns the number
+// of applied entries, entries older than the hive secondary sequence
+// number are skipped and replay stops at the first sequence gap, logs
+// that do not continue from the hive sequence number are rejected
+func (r *Registry) ReplayLogs(logs ...*TransactionLog) (int, error) {
+	var entries []*block.LogEntry
+	legacy := make(map[*block.LogEntry]bool)
+	for _, tl := range logs {
+		for _, le := range tl.Entries {
+			if le.Sequence < r.Sequence2 || !tl.valid(le) {
+				continue
+			}
+			if le.HBinSize == 0 || le.HBinSize%block.HBinAlignment != 0 {
+				return 0, fmt.Errorf("%w: hbins size %#x of entry %d is not a multiple of %#x", ErrInvalidLog, le.HBinSize, le.Sequence, block.HBinAlignment)
+			}
+			entries = append(entries, le)
+			legacy[le] = tl.Format == LogFormatDirtyVector
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+
+	// legacy logs are written with the primary sequence number
+	// of the write that left the hive dirty
+	start := r.Sequence2
+	if legacy[entries[0]] {
+		start++
+	}
+	if entries[0].Sequence != start {
+		return 0, fmt.Errorf("%w: log starts at sequence %d, hive continues from %d", ErrInvalidLog, entries[0].Sequence, start)
+	}
+
+	data, err := r.hbinsData()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for i, le := range entries {
+		if i > 0 && le.Sequence == entries[i-1].Sequence {
+			continue
+		}
+		if i > 0 && le.Sequence != entries[i-1].Sequence+1 {
+			break
+		}
+		if data, err = applyLogEntry(data, le); err != nil {
+			return applied, err
+		}
+		r.HBinSize = le.HBinSize
+		r.Flags = le.Flags
+		r.Sequence1, r.Sequence2 = le.Sequence, le.Sequence
+		applied++
+	}
+
+	if err := r.setHBinsData(data); err != nil {
+		return applied, err
+	}
+	r.modified = true
+	return applied, nil
+}
+
+// applyLogEntry writes dirty pages of the log entry to hbins
+// data, resized to the hbins size stored in the log entry
+func applyLogEntry(data []byte, le *block.LogEntry) ([]byte, error) {
+	size := int(le.HBinSize)
+	if size > len(data) {
+		data = append(data, make([]byte, size-len(data))...)
+	}
+	data = data[:size]
+
+	for i, ref := range le.Refs {
+		end := uint64(ref.Offset) + uint64(ref.Size)
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: dirty page at offset %#x of entry %d is out of bounds", ErrInvalidLog, ref.Offset, le.Sequence)
+		}
+		copy(data[ref.Offset:end], le.Pages[i])
+	}
+	return data, nil
+}
+
+// hbinsData returns a copy of hbins data, marshaled
+// from unmarshaled hbins if loaded or raw hive data
+func (r *Registry) hbinsData() ([]byte, error) {
+	if len(r.HBins) > 0 {
+		return block.Marshal(&r.HBins)
+	}
+	if len(r.RawHiveData) > 0 {
+		return append([]byte{}, r.RawHiveData...), nil
+	}
+	return nil, ErrHBinsNotLoaded
+}
+
+// setHBinsData replaces hbins with the provided data,
+// keeping raw hive data if it was loaded
+func (r *Registry) setHBinsData(data []byte) error {
+	if len(r.RawHiveData) > 0 {
+		r.RawHiveData = data
+	}
+	if len(r.HBins) == 0 {
+		return nil
+	}
+	return block.Unmarshal(&r.HBins, data)
+}
@@ -0,0 +1,300 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testLogEntry builds a valid log entry holding a
+// single dirty page of hbins data at the provided offset
+func testLogEntry(sequence uint32, hbinSize uint32, offset uint32, page []byte) *block.LogEntry {
+	used := block.LogEntryHeaderSize + block.DirtyPageRefSize + len(page)
+	size := (used + block.LogSectorSize - 1) &^ (block.LogSectorSize - 1)
+	le := &block.LogEntry{
+		LogEntryHeader: block.LogEntryHeader{
+			LogEntrySignature: [4]byte{'H', 'v', 'L', 'E'},
+			EntrySize:         uint32(size),
+			Sequence:          sequence,
+			HBinSize:          hbinSize,
+			DirtyPagesCount:   1,
+		},
+		Refs:    []block.DirtyPageRef{{Offset: offset, Size: uint32(len(page))}},
+		Pages:   [][]byte{page},
+		Padding: make([]byte, size-used),
+	}
+	if err := le.UpdateHashes(); err != nil {
+		panic(err)
+	}
+	return le
+}
+
+// testLogBytes serializes a transaction log with the provided entries
+func testLogBytes(entries ...*block.LogEntry) []byte {
+	bb := block.BaseBlock{RegfHeader: block.BaseBlockSignature, FileType: 6}
+	data, err := block.Marshal(&bb)
+	if err != nil {
+		panic(err)
+	}
+	data = data[:block.LogBaseBlockSize]
+	for _, le := range entries {
+		b, err := block.Marshal(le)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+func TestReplayLogs(t *testing.T) {
+	r := testTreeRegistry(t)
+	r.Sequence1, r.Sequence2 = 5, 4
+
+	k, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	values, err := k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	hbins, err := block.Marshal(&r.HBins)
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+
+	// data offset field of the inline REG_DWORD value holds its data
+	field := uint32(values[0].AbsoluteOffset()) + 12
+	pageOffset := field &^ (block.LogSectorSize - 1)
+	page := func(value uint32) []byte {
+		p := append([]byte{}, hbins[pageOffset:pageOffset+block.LogSectorSize]...)
+		binary.LittleEndian.PutUint32(p[field-pageOffset:], value)
+		return p
+	}
+
+	size := uint32(len(hbins))
+	corrupted := testLogEntry(6, size, pageOffset, page(60))
+	corrupted.Hash1++
+	log1, err := ParseTransactionLog(testLogBytes(
+		testLogEntry(3, size, pageOffset, page(30)),
+		testLogEntry(4, size, pageOffset, page(40)),
+	))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	log2, err := ParseTransactionLog(testLogBytes(
+		testLogEntry(5, size, pageOffset, page(50)),
+		corrupted,
+		testLogEntry(7, size, pageOffset, page(70)),
+	))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	if got, want := len(log2.Entries), 3; got != want {
+		t.Fatalf("log entry count mismatch: got %d, want %d", got, want)
+	}
+
+	applied, err := r.ReplayLogs(log2, log1)
+	if err != nil {
+		t.Fatalf("failed to replay logs: %v", err)
+	}
+	if got, want := applied, 2; got != want {
+		t.Errorf("applied entries mismatch: got %d, want %d", got, want)
+	}
+	if r.Sequence1 != 5 || r.Sequence2 != 5 {
+		t.Errorf("sequence numbers mismatch: got %d and %d, want 5", r.Sequence1, r.Sequence2)
+	}
+
+	k, err = r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key after replay: %v", err)
+	}
+	values, err = k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values after replay: %v", err)
+	}
+	if got, err := values[0].DataUint32(); got != 50 || err != nil {
+		t.Errorf("replayed value mismatch: got %d, %v, want 50", got, err)
+	}
+}
+
+func TestReplayLogsGrowsHive(t *testing.T) {
+	r := testTreeRegistry(t)
+	size := r.HBinSize
+
+	hbin := make([]byte, block.HBinAlignment)
+	copy(hbin, "hbin")
+	binary.LittleEndian.PutUint32(hbin[4:], size)
+	binary.LittleEndian.PutUint32(hbin[8:], block.HBinAlignment)
+	binary.LittleEndian.PutUint32(hbin[32:], block.HBinAlignment-block.HBinHeaderSize)
+
+	log, err := ParseTransactionLog(testLogBytes(testLogEntry(r.Sequence2, size+block.HBinAlignment, size, hbin)))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	if _, err := r.ReplayLogs(log); err != nil {
+		t.Fatalf("failed to replay log: %v", err)
+	}
+	if got, want := len(r.HBins), 2; got != want {
+		t.Errorf("hbin count mismatch: got %d, want %d", got, want)
+	}
+	if got, want := r.HBinSize, size+block.HBinAlignment; got != want {
+		t.Errorf("hbins size mismatch: got %d, want %d", got, want)
+	}
+}
+
+func TestReplayLogsUpdatesChecksum(t *testing.T) {
+	r := testTreeRegistry(t)
+	r.Sequence1, r.Sequence2 = 5, 4
+	if err := r.UpdateChecksum(); err != nil {
+		t.Fatalf("failed to update checksum: %v", err)
+	}
+	data, err := block.Marshal(&r.HBins)
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+	page := append([]byte{}, data[:block.LogSectorSize]...)
+	log, err := ParseTransactionLog(testLogBytes(testLogEntry(4, uint32(len(data)), 0, page)))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	if applied, err := r.ReplayLogs(log); applied != 1 || err != nil {
+		t.Fatalf("failed to replay log, got %d, %v", applied, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hive")
+	if err := r.Save(path, WriteAllMarshal); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+	saved, err := OpenRegistry(path, ReadAllUnmarshal|ReadValidateChecksum)
+	if err != nil {
+		t.Fatalf("failed to open replayed registry: %v", err)
+	}
+	if saved.Sequence1 != 4 || saved.Sequence2 != 4 {
+		t.Errorf("sequence numbers mismatch: got %d and %d, want 4", saved.Sequence1, saved.Sequence2)
+	}
+}
+
+func TestReplayLogsInvalid(t *testing.T) {
+	r := testTreeRegistry(t)
+	r.Sequence1, r.Sequence2 = 5, 4
+	data, err := block.Marshal(&r.HBins)
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+	size := uint32(len(data))
+	page := append([]byte{}, data[:block.LogSectorSize]...)
+
+	// entry 4 is missing so the log does not continue the hive
+	ahead, err := ParseTransactionLog(testLogBytes(
+		testLogEntry(5, size, 0, page),
+		testLogEntry(6, size, 0, page),
+	))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	if applied, err := r.ReplayLogs(ahead); applied != 0 || !errors.Is(err, ErrInvalidLog) {
+		t.Errorf("expected ErrInvalidLog for log past the hive sequence, got %d, %v", applied, err)
+	}
+
+	misaligned, err := ParseTransactionLog(testLogBytes(testLogEntry(4, size+block.LogSectorSize, 0, page)))
+	if err != nil {
+		t.Fatalf("failed to parse log: %v", err)
+	}
+	if applied, err := r.ReplayLogs(misaligned); applied != 0 || !errors.Is(err, ErrInvalidLog) {
+		t.Errorf("expected ErrInvalidLog for misaligned hbins size, got %d, %v", applied, err)
+	}
+
+	if r.Sequence1 != 5 || r.Sequence2 != 4 || r.HBinSize != size {
+		t.Errorf("rejected logs should leave the hive untouched, got sequences %d, %d and size %d", r.Sequence1, r.Sequence2, r.HBinSize)
+	}
+}
+
+func TestParseTransactionLogInvalid(t *testing.T) {
+	if _, err := ParseTransactionLog(make([]byte, 100)); !errors.Is(err, ErrInvalidLog) {
+		t.Errorf("expected ErrInvalidLog for short log, got %v", err)
+	}
+	if _, err := ParseTransactionLog(make([]byte, block.LogBaseBlockSize)); !errors.Is(err, ErrInvalidLog) {
+		t.Errorf("expected ErrInvalidLog for missing signature, got %v", err)
+	}
+}
+
+// testLegacyLogBytes serializes a legacy log marking
+// the provided page of hbins data as dirty
+func testLegacyLogBytes(bb block.BaseBlock, offset uint32, page []byte) []byte {
+	data, err := block.Marshal(&bb)
+	if err != nil {
+		panic(err)
+	}
+	dv := block.NewDirtyVector(bb.HBinSize)
+	dv.SetDirty(offset, uint32(len(page)))
+	vector, err := block.Marshal(dv)
+	if err != nil {
+		panic(err)
+	}
+
+	data = append(data[:block.LogBaseBlockSize], vector...)
+	data = append(data, make([]byte, block.LogSectorSize-len(vector)%block.LogSectorSize)...)
+	return append(data, page...)
+}
+
+func TestReplayLegacyLog(t *testing.T) {
+	r := testTreeRegistry(t)
+	r.Sequence1, r.Sequence2 = 3, 2
+
+	k, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	values, err := k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	hbins, err := block.Marshal(&r.HBins)
+	if err != nil {
+		t.Fatalf("failed to marshal hbins: %v", err)
+	}
+	field := uint32(values[0].AbsoluteOffset()) + 12
+	pageOffset := field &^ (block.LogSectorSize - 1)
+	page := append([]byte{}, hbins[pageOffset:pageOffset+block.LogSectorSize]...)
+	binary.LittleEndian.PutUint32(page[field-pageOffset:], 42)
+
+	bb := r.BaseBlock
+	bb.FileType = 1
+	bb.Sequence1, bb.Sequence2 = 4, 3
+	incomplete, err := ParseTransactionLog(testLegacyLogBytes(bb, pageOffset, page))
+	if err != nil {
+		t.Fatalf("failed to parse legacy log: %v", err)
+	}
+	if incomplete.Format != LogFormatDirtyVector {
+		t.Fatalf("log format mismatch: got %d, want %d", incomplete.Format, LogFormatDirtyVector)
+	}
+	if applied, err := r.ReplayLogs(incomplete); applied != 0 || err != nil {
+		t.Errorf("incomplete legacy log should not be applied, got %d, %v", applied, err)
+	}
+
+	bb.Sequence1 = 3
+	log, err := ParseTransactionLog(testLegacyLogBytes(bb, pageOffset, page))
+	if err != nil {
+		t.Fatalf("failed to parse legacy log: %v", err)
+	}
+	if applied, err := r.ReplayLogs(log); applied != 1 || err != nil {
+		t.Fatalf("failed to replay legacy log, got %d, %v", applied, err)
+	}
+
+	k, err = r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key after replay: %v", err)
+	}
+	values, err = k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values after replay: %v", err)
+	}
+	if got, err := values[0].DataUint32(); got != 42 || err != nil {
+		t.Errorf("replayed value mismatch: got %d, %v, want 42", got, err)
+	}
+}
@@ -0,0 +1,217 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/turekt/winrego/block"
+)
+
+// RecoveredKey is a deleted key node found in unallocated space
+type RecoveredKey struct {
+	*Key
+	// Path the key had in the hive, built from existing or recovered
+	// parent keys, starts at the topmost recovered key when the
+	// chain of parents is broken
+	Path string
+	// Parent chain reaches an allocated key of the hive
+	ParentFound bool
+	// Recovered values referenced by the key values list
+	Values []*RecoveredValue
+}
+
+// RecoveredValue is a deleted key value found in unallocated space
+type RecoveredValue struct {
+	*Value
+	// Value data if the data cells were not reused, nil otherwise
+	RawData []byte
+	// Data is stored inline or its cells are still unallocated
+	DataIntact bool
+}
+
+// Recovery holds records recovered from unallocated space
+type Recovery struct {
+	Keys     []*RecoveredKey
+	Values   []*RecoveredValue
+	Security []*block.KeySecurity
+}
+
+// RecoverDeleted scans unallocated cells for deleted key nodes, key
+// values and key security records, recovered keys are attached to
+// their parent paths and recovered values carry their data if the
+// data cells were not allocated again since
+func (r *Registry) RecoverDeleted() (*Recovery, error) {
+	if len(r.HBins) == 0 {
+		return nil, ErrHBinsNotLoaded
+	}
+	data, err := r.hbinsData()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &Recovery{}
+	keys := make(map[int32]*RecoveredKey)
+	values := make(map[int32]*RecoveredValue)
+	for _, hc := range r.HBins.DeletedCells() {
+		switch cell := hc.(type) {
+		case *block.KeyNode:
+			rk := &RecoveredKey{Key: &Key{cell, r}}
+			keys[cell.AbsoluteOffset()] = rk
+			rec.Keys = append(rec.Keys, rk)
+		case *block.KeyValue:
+			rv := &RecoveredValue{Value: &Value{cell, r}}
+			rv.RawData, rv.DataIntact = r.recoverData(data, cell)
+			values[cell.AbsoluteOffset()] = rv
+			rec.Values = append(rec.Values, rv)
+		case *block.KeySecurity:
+			rec.Security = append(rec.Security, cell)
+		}
+	}
+
+	for _, rk := range rec.Keys {
+		rk.Path, rk.ParentFound = r.recoveredPath(rk, keys)
+		if rk.KeyValuesCount <= 0 || rk.KeyValuesListOffset == NoCellOffset {
+			continue
+		}
+		list, ok := r.unallocatedAt(data, rk.KeyValuesListOffset)
+		if !ok {
+			continue
+		}
+		for i := 0; i < int(rk.KeyValuesCount) && (i+1)*4 <= len(list); i++ {
+			if rv, ok := values[int32(binary.LittleEndian.Uint32(list[i*4:]))]; ok {
+				rk.Values = append(rk.Values, rv)
+			}
+		}
+	}
+	return rec, nil
+}
+
+// recoveredPath follows parents of the recovered key through
+// recovered and allocated keys and builds the key path
+func (r *Registry) recoveredPath(rk *RecoveredKey, keys map[int32]*RecoveredKey) (string, bool) {
+	names := []string{rk.Name()}
+	visited := map[int32]bool{rk.AbsoluteOffset(): true}
+	for parent := rk.KeyNodeData.Parent; ; {
+		if visited[parent] {
+			return joinPath("", names), false
+		}
+		visited[parent] = true
+
+		if pk, err := r.keyAt(parent); err == nil && pk.Allocated() {
+			path, err := pk.Path()
+			return joinPath(path, names), err == nil
+		}
+		prk, ok := keys[parent]
+		if !ok {
+			return joinPath("", names), false
+		}
+		names = append(names, prk.Name())
+		parent = prk.KeyNodeData.Parent
+	}
+}
+
+// joinPath appends names collected from child to ancestor to the path
+func joinPath(path string, names []string) string {
+	parts := make([]string, 0, len(names)+1)
+	if path != "" {
+		parts = append(parts, path)
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		parts = append(parts, names[i])
+	}
+	return strings.Join(parts, `\`)
+}
+
+// recoverData returns the data of a recovered value if its cells
+// were not allocated again, big data segments are reassembled
+func (r *Registry) recoverData(data []byte, kv *block.KeyValue) ([]byte, bool) {
+	if kv.IsDataInline() {
+		return kv.InlineData(), true
+	}
+	size := kv.DataLength()
+	if size == 0 {
+		return []byte{}, true
+	}
+
+	cell, ok := r.unallocatedAt(data, kv.DataOffset)
+	if !ok {
+		return nil, false
+	}
+	if !block.UsesBigData(r.Minor, size) || len(cell) < 4 || string(cell[:2]) != "db" {
+		if uint32(len(cell)) < size {
+			return nil, false
+		}
+		return cell[:size], true
+	}
+
+	count := int(binary.LittleEndian.Uint16(cell[2:]))
+	list, ok := r.unallocatedAt(data, int32(binary.LittleEndian.Uint32(cell[4:])))
+	if !ok || count*4 > len(list) {
+		return nil, false
+	}
+	var value []byte
+	for i := 0; i < count; i++ {
+		segment, ok := r.unallocatedAt(data, int32(binary.LittleEndian.Uint32(list[i*4:])))
+		if !ok {
+			return nil, false
+		}
+		if len(segment) > block.BigDataSegmentSize {
+			segment = segment[:block.BigDataSegmentSize]
+		}
+		value = append(value, segment...)
+	}
+	if uint32(len(value)) < size {
+		return nil, false
+	}
+	return value[:size], true
+}
+
+// unallocatedAt returns bytes following the size field of a freed cell
+// at offset in hbins data, the cell may be merged into a larger
+// unallocated cell, bytes covered by any allocated cell are not returned
+func (r *Registry) unallocatedAt(data []byte, offset int32) ([]byte, bool) {
+	if offset < 0 || int(offset)+block.HCellSizeLength > len(data) {
+		return nil, false
+	}
+
+	size := int32(binary.LittleEndian.Uint32(data[offset:]))
+	if size < 0 {
+		size = -size
+	}
+	end := int(offset) + int(size)
+	if size < block.HCellSizeLength || end > len(data) {
+		return nil, false
+	}
+	if !r.unallocatedRange(offset, int32(end)) {
+		return nil, false
+	}
+	return data[int(offset)+block.HCellSizeLength : end], true
+}
+
+// unallocatedRange reports whether bytes between start and end lie in
+// a single hbin and every cell holding them is unallocated, a cell
+// allocated again may start before start and cover it
+func (r *Registry) unallocatedRange(start, end int32) bool {
+	for _, hb := range r.HBins {
+		hbEnd := hb.HBinDataOffset + hb.HBinSize
+		if start < hb.HBinDataOffset || start >= hbEnd {
+			continue
+		}
+		if end > hbEnd {
+			return false
+		}
+		covered := false
+		for _, hc := range hb.Cells {
+			cellStart := hc.AbsoluteOffset()
+			if cellStart >= end || cellStart+hc.Size() <= start {
+				continue
+			}
+			if hc.Allocated() {
+				return false
+			}
+			covered = true
+		}
+		return covered
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testFreeCells marks cells added to the test hive starting at
+// index from as unallocated
+func testFreeCells(th *testHive, from int) {
+	for _, hc := range th.cells[from:] {
+		size := reflect.ValueOf(hc).Elem().FieldByName("BlockSize")
+		size.SetInt(-size.Int())
+	}
+}
+
+func TestRecoverDeleted(t *testing.T) {
+	th, root := testTreeHive()
+	software := th.offsets[1]
+	softwareKn := th.cells[1].(*block.KeyNode)
+	from := len(th.cells)
+
+	deletedKn := testKeyNode("Deleted", software)
+	deleted := th.add(deletedKn)
+	th.add(testKeyNode("Child", deleted))
+	secretData := th.add(testDataRecord(block.EncodeUTF16("hunter2\x00")))
+	secret := th.add(testKeyValue("Secret", block.RegSz, 16, secretData))
+	deletedKn.KeyValuesCount, deletedKn.KeyValuesListOffset = 1, th.add(testOffsetList(secret))
+	th.add(testKeyValue("Orphan", block.RegDWord, -0x7ffffffc, 5))
+	th.add(testKeyValue("Reused", block.RegBinary, 4, softwareKn.KeyValuesListOffset))
+	testFreeCells(th, from)
+
+	r := th.registry(t, root)
+	rec, err := r.RecoverDeleted()
+	if err != nil {
+		t.Fatalf("failed to recover deleted records: %v", err)
+	}
+
+	if got, want := len(rec.Keys), 2; got != want {
+		t.Fatalf("recovered key count mismatch: got %d, want %d", got, want)
+	}
+	for i, want := range []string{`Software\Deleted`, `Software\Deleted\Child`} {
+		if got := rec.Keys[i].Path; got != want || !rec.Keys[i].ParentFound {
+			t.Errorf("recovered key path mismatch: got %q (parent found %v), want %q", got, rec.Keys[i].ParentFound, want)
+		}
+	}
+
+	if got, want := len(rec.Values), 3; got != want {
+		t.Fatalf("recovered value count mismatch: got %d, want %d", got, want)
+	}
+	if got := rec.Keys[0].Values; len(got) != 1 || got[0] != rec.Values[0] {
+		t.Fatalf("recovered key values mismatch: got %v", got)
+	}
+	if got, want := decodeString(rec.Values[0].RawData), "hunter2"; got != want || !rec.Values[0].DataIntact {
+		t.Errorf("recovered value data mismatch: got %q, want %q", got, want)
+	}
+	if got, want := rec.Values[1].RawData, []byte{5, 0, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered inline data mismatch: got %v, want %v", got, want)
+	}
+	if rec.Values[2].DataIntact || rec.Values[2].RawData != nil {
+		t.Errorf("data of reused cell should not be recovered, got %v", rec.Values[2].RawData)
+	}
+}
+
+func TestRecoverDeletedOverwrittenData(t *testing.T) {
+	th, root := testTreeHive()
+
+	// newer allocation starts before the old data cell and covers it,
+	// its content holds what looks like the old cell size field
+	content := make([]byte, 40)
+	binary.LittleEndian.PutUint32(content[12:], 16)
+	copy(content[16:], "old data")
+	straddling := th.add(testDataRecord(content))
+	from := len(th.cells)
+	th.add(testKeyValue("Overwritten", block.RegBinary, 8, straddling+16))
+	testFreeCells(th, from)
+
+	r := th.registry(t, root)
+	rec, err := r.RecoverDeleted()
+	if err != nil {
+		t.Fatalf("failed to recover deleted records: %v", err)
+	}
+	if got, want := len(rec.Values), 1; got != want {
+		t.Fatalf("recovered value count mismatch: got %d, want %d", got, want)
+	}
+	if v := rec.Values[0]; v.DataIntact || v.RawData != nil {
+		t.Errorf("data covered by an allocated cell should not be recovered, got %q", v.RawData)
+	}
+}
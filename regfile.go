@@ -0,0 +1,500 @@
+package winrego
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/turekt/winrego/block"
+)
+
+// RegFormat selects the version of the regedit .reg file format
+type RegFormat int
+
+const (
+	// "Windows Registry Editor Version 5.00" written in UTF-16LE with BOM
+	RegFormatV5 RegFormat = iota
+	// "REGEDIT4" written in ANSI, strings are limited to Latin-1
+	RegFormatV4
+)
+
+const (
+	RegFileHeaderV5 = "Windows Registry Editor Version 5.00"
+	RegFileHeaderV4 = "REGEDIT4"
+	// Hex encoded data lines are wrapped after this column
+	regHexLineWidth = 76
+)
+
+var (
+	ErrRegFile     = errors.New("invalid .reg file")
+	ErrRegFormatV4 = errors.New("can not be represented in a REGEDIT4 file")
+)
+
+// ExportReg writes the key at path, relative to the root key, and all
+// of its descendants to w in the .reg format, key paths are prefixed
+// with root, e.g. "HKEY_LOCAL_MACHINE\\SOFTWARE", the root key name
+// is used when root is empty
+func (r *Registry) ExportReg(w io.Writer, path string, root string, format RegFormat) error {
+	k, err := r.OpenKey(path)
+	if err != nil {
+		return err
+	}
+	if root == "" {
+		rk, err := r.Root()
+		if err != nil {
+			return err
+		}
+		root = rk.Name()
+	}
+	return k.ExportReg(w, root, format)
+}
+
+// ExportReg writes this key and all of its descendants to w in the
+// .reg format, key paths are prefixed with root
+func (k *Key) ExportReg(w io.Writer, root string, format RegFormat) error {
+	rw := &regWriter{w: bufio.NewWriter(w), format: format}
+	if format == RegFormatV5 {
+		rw.write([]byte{0xff, 0xfe})
+		rw.line(RegFileHeaderV5)
+	} else {
+		rw.line(RegFileHeaderV4)
+	}
+	rw.line("")
+
+	err := k.Walk(func(key *Key) error {
+		path, err := key.Path()
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			path = `\` + path
+		}
+		rw.line("[" + root + path + "]")
+
+		values, err := key.Values()
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			data, err := v.Data()
+			if err != nil {
+				return err
+			}
+			line, err := regValueLine(v.Name(), v.Type(), data, format)
+			if err != nil {
+				return err
+			}
+			rw.line(line)
+		}
+		rw.line("")
+		return rw.err
+	})
+	if err != nil {
+		return err
+	}
+	return rw.w.Flush()
+}
+
+// regWriter encodes lines of a .reg file and keeps the first write error
+type regWriter struct {
+	w      *bufio.Writer
+	format RegFormat
+	err    error
+}
+
+func (rw *regWriter) write(data []byte) {
+	if rw.err == nil {
+		_, rw.err = rw.w.Write(data)
+	}
+}
+
+// line writes the line, key paths and value names of REGEDIT4
+// files are limited to Latin-1 characters
+func (rw *regWriter) line(s string) {
+	if rw.format == RegFormatV5 {
+		rw.write(block.EncodeUTF16(s + "\r\n"))
+	} else if !isLatin1(s) {
+		if rw.err == nil {
+			rw.err = fmt.Errorf("%w: line %q holds characters out of Latin-1", ErrRegFormatV4, s)
+		}
+	} else {
+		rw.write(encodeLatin1(s + "\r\n"))
+	}
+}
+
+// regValueLine formats a value as "name"=data, strings and dwords are
+// written in readable form when they can be imported back unchanged,
+// all other data is hex encoded with the type when it is not REG_BINARY,
+// strings of REGEDIT4 files are stored in ANSI when they are limited to
+// Latin-1, otherwise their UTF-16 data is written unchanged
+func regValueLine(name string, dataType uint32, data []byte, format RegFormat) (string, error) {
+	prefix := "@="
+	if name != "" {
+		prefix = `"` + escapeRegString(name) + `"=`
+	}
+
+	switch dataType {
+	case block.RegSz:
+		if s, ok := regString(data); ok && (format == RegFormatV5 || isLatin1(s)) {
+			return prefix + `"` + escapeRegString(s) + `"`, nil
+		}
+	case block.RegDWord:
+		if len(data) == 4 {
+			return fmt.Sprintf("%sdword:%08x", prefix, binary.LittleEndian.Uint32(data)), nil
+		}
+	}
+
+	if format == RegFormatV4 && isRegStringType(dataType) {
+		s := block.DecodeUTF16(data)
+		if ansi := encodeLatin1(s); len(data)%2 == 0 && isLatin1(s) && bytes.Equal(block.EncodeUTF16(s), data) && isRegANSI(ansi, dataType) {
+			data = ansi
+		} else if isRegANSI(data, dataType) {
+			// data would be read back as ANSI
+			return "", fmt.Errorf("%w: data of value %q", ErrRegFormatV4, name)
+		}
+	}
+
+	kind := "hex:"
+	if dataType != block.RegBinary {
+		kind = fmt.Sprintf("hex(%x):", dataType)
+	}
+	return prefix + kind + regHex(data, utf8.RuneCountInString(prefix+kind)), nil
+}
+
+func isRegStringType(dataType uint32) bool {
+	return dataType == block.RegSz || dataType == block.RegExpandSz || dataType == block.RegMultiSz
+}
+
+// isRegANSI reports whether the data has the form strings take when
+// stored in ANSI in REGEDIT4 files, a string with a single terminating
+// null or, for REG_MULTI_SZ, non-empty null terminated strings followed
+// by a null, other data of string types is taken as UTF-16 unchanged
+func isRegANSI(data []byte, dataType uint32) bool {
+	if len(data) == 0 || data[len(data)-1] != 0 {
+		return false
+	}
+	if dataType != block.RegMultiSz {
+		return bytes.IndexByte(data, 0) == len(data)-1
+	}
+	if len(data) == 1 {
+		return true
+	}
+	return data[0] != 0 && !bytes.Contains(data[:len(data)-1], []byte{0, 0})
+}
+
+// regString decodes a null terminated UTF-16 string that fits
+// on a single line and encodes back to the same data, false if
+// data does not hold one
+func regString(data []byte) (string, bool) {
+	if len(data) == 0 {
+		return "", true
+	}
+	if len(data)%2 != 0 || data[len(data)-2] != 0 || data[len(data)-1] != 0 {
+		return "", false
+	}
+	raw := data[:len(data)-2]
+	s := block.DecodeUTF16(raw)
+	if strings.ContainsAny(s, "\x00\r\n") || !bytes.Equal(block.EncodeUTF16(s), raw) {
+		return "", false
+	}
+	return s, true
+}
+
+func escapeRegString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// regHex formats bytes as comma separated hex pairs wrapped the
+// way regedit wraps them, column is the length of the line prefix
+func regHex(data []byte, column int) string {
+	var sb strings.Builder
+	for i, b := range data {
+		fmt.Fprintf(&sb, "%02x", b)
+		if i == len(data)-1 {
+			break
+		}
+		sb.WriteByte(',')
+		column += 3
+		if column > regHexLineWidth {
+			sb.WriteString("\\\r\n  ")
+			column = 2
+		}
+	}
+	return sb.String()
+}
+
+// encodeLatin1 encodes the string to Latin-1, characters
+// out of its range have to be checked with isLatin1
+func encodeLatin1(s string) []byte {
+	data := make([]byte, 0, len(s))
+	for _, r := range s {
+		data = append(data, byte(r))
+	}
+	return data
+}
+
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportReg parses a .reg file and applies it to the hive, keys are
+// created and values set, deleted or overwritten as the file says,
+// key paths have to start with root which is the path the hive is
+// mounted at, e.g. "HKEY_LOCAL_MACHINE\\SOFTWARE", the first path
+// component is taken as the root key when root is empty, REGEDIT4 and
+// version 5.00 files in UTF-16LE with BOM, UTF-8 or ANSI are accepted
+func (r *Registry) ImportReg(rd io.Reader, root string) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+
+	lines := regLines(decodeRegText(data))
+	if len(lines) == 0 {
+		return fmt.Errorf("%w: file is empty", ErrRegFile)
+	}
+	var format RegFormat
+	switch lines[0].text {
+	case RegFileHeaderV5:
+		format = RegFormatV5
+	case RegFileHeaderV4:
+		format = RegFormatV4
+	default:
+		return fmt.Errorf("%w: unknown header %q", ErrRegFile, lines[0].text)
+	}
+
+	var key *Key
+	inKey := false
+	for _, l := range lines[1:] {
+		if strings.HasPrefix(l.text, "[") {
+			if key, err = r.importRegKey(l.text, root); err != nil {
+				return fmt.Errorf("%w: line %d: %v", ErrRegFile, l.number, err)
+			}
+			inKey = true
+			continue
+		}
+		if !inKey {
+			return fmt.Errorf("%w: line %d: value outside of a key", ErrRegFile, l.number)
+		}
+		if key == nil {
+			// values of deleted keys are ignored
+			continue
+		}
+		if err := importRegValue(key, l.text, format); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrRegFile, l.number, err)
+		}
+	}
+	return nil
+}
+
+// regLine is a logical line of a .reg file with continuations joined
+type regLine struct {
+	number int
+	text   string
+}
+
+// decodeRegText decodes a .reg file in UTF-16LE or UTF-8 with BOM,
+// files without BOM are read as UTF-8 if valid, otherwise as ANSI
+func decodeRegText(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return block.DecodeUTF16(data[2:])
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return string(data[3:])
+	case utf8.Valid(data):
+		return string(data)
+	}
+	return block.DecodeName(data, true)
+}
+
+// regLines splits the text to lines skipping empty lines and comments,
+// lines ending with a backslash continue on the next line
+func regLines(text string) []regLine {
+	var lines []regLine
+	var current *regLine
+	for i, s := range strings.Split(text, "\n") {
+		s = strings.TrimRight(s, "\r")
+		if current != nil {
+			s = strings.TrimLeft(s, " \t")
+		} else {
+			s = strings.TrimSpace(s)
+			if s == "" || strings.HasPrefix(s, ";") {
+				continue
+			}
+			lines = append(lines, regLine{number: i + 1})
+			current = &lines[len(lines)-1]
+		}
+
+		trimmed := strings.TrimRight(s, " \t")
+		if strings.HasSuffix(trimmed, `\`) && !strings.HasPrefix(current.text+trimmed, "[") {
+			current.text += strings.TrimSuffix(trimmed, `\`)
+			continue
+		}
+		current.text += trimmed
+		current = nil
+	}
+	return lines
+}
+
+// importRegKey creates the key of a [path] line or deletes the key of
+// a [-path] line, nil key is returned for deleted keys
+func (r *Registry) importRegKey(line string, root string) (*Key, error) {
+	if !strings.HasSuffix(line, "]") {
+		return nil, fmt.Errorf("key line %q is not terminated", line)
+	}
+	path := line[1 : len(line)-1]
+	deleted := strings.HasPrefix(path, "-")
+	path = strings.TrimPrefix(path, "-")
+
+	if root == "" {
+		root = strings.SplitN(path, `\`, 2)[0]
+	}
+	if !block.EqualNames(path, root) && !strings.HasPrefix(block.UpcaseName(path), block.UpcaseName(root)+`\`) {
+		return nil, fmt.Errorf("key %q is not under %q", path, root)
+	}
+	path = strings.TrimPrefix(path[len(root):], `\`)
+
+	if !deleted {
+		return r.CreateKey(path)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("root key %q cannot be deleted", root)
+	}
+	parentPath, name := "", path
+	if i := strings.LastIndex(path, `\`); i >= 0 {
+		parentPath, name = path[:i], path[i+1:]
+	}
+	parent, err := r.OpenKey(parentPath)
+	if err == nil {
+		err = parent.DeleteSubkey(name, true)
+	}
+	var notFound *KeyNotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// importRegValue sets or deletes the value of a "name"=data line
+func importRegValue(key *Key, line string, format RegFormat) error {
+	var name, rest string
+	if strings.HasPrefix(line, "@") {
+		rest = line[1:]
+	} else if strings.HasPrefix(line, `"`) {
+		var ok bool
+		if name, rest, ok = parseRegString(line); !ok {
+			return fmt.Errorf("value name is not terminated in %q", line)
+		}
+	} else {
+		return fmt.Errorf("unexpected line %q", line)
+	}
+
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "=") {
+		return fmt.Errorf("missing '=' after value name %q", name)
+	}
+	rest = strings.TrimSpace(rest[1:])
+
+	if rest == "-" {
+		err := key.DeleteValue(name)
+		if errors.Is(err, ErrValueNotFound) {
+			return nil
+		}
+		return err
+	}
+	dataType, data, err := parseRegData(rest, format)
+	if err != nil {
+		return fmt.Errorf("value %q: %v", name, err)
+	}
+	return key.SetValue(name, dataType, data)
+}
+
+// parseRegString parses a quoted string with \\ and \" escapes at the
+// start of s and returns the unescaped string and the remaining text
+func parseRegString(s string) (string, string, bool) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '\\' || s[i+1] == '"') {
+				i++
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return "", "", false
+}
+
+// parseRegData decodes value data in string, dword or hex form, strings
+// stored in ANSI in REGEDIT4 files are converted to UTF-16
+func parseRegData(s string, format RegFormat) (uint32, []byte, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		str, rest, ok := parseRegString(s)
+		if !ok || strings.TrimSpace(rest) != "" {
+			return 0, nil, fmt.Errorf("malformed string %s", s)
+		}
+		return block.RegSz, block.EncodeUTF16(str + "\x00"), nil
+	case strings.HasPrefix(s, "dword:"):
+		v, err := strconv.ParseUint(s[len("dword:"):], 16, 32)
+		if err != nil {
+			return 0, nil, err
+		}
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(v))
+		return block.RegDWord, data, nil
+	case strings.HasPrefix(s, "hex"):
+	default:
+		return 0, nil, fmt.Errorf("unknown data format %q", s)
+	}
+
+	dataType := uint32(block.RegBinary)
+	s = s[len("hex"):]
+	if strings.HasPrefix(s, "(") {
+		end := strings.Index(s, ")")
+		if end < 0 {
+			return 0, nil, fmt.Errorf("malformed hex type %q", s)
+		}
+		t, err := strconv.ParseUint(s[1:end], 16, 32)
+		if err != nil {
+			return 0, nil, err
+		}
+		dataType, s = uint32(t), s[end+1:]
+	}
+	if !strings.HasPrefix(s, ":") {
+		return 0, nil, fmt.Errorf("missing ':' in hex data %q", s)
+	}
+
+	var data []byte
+	for _, field := range strings.Split(s[1:], ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return 0, nil, err
+		}
+		data = append(data, byte(b))
+	}
+
+	// strings are stored in ANSI in REGEDIT4 files unless they
+	// were written by ExportReg as UTF-16 out of Latin-1 range
+	if format == RegFormatV4 && isRegStringType(dataType) && isRegANSI(data, dataType) {
+		data = block.EncodeUTF16(block.DecodeName(data, true))
+	}
+	return dataType, data, nil
+}
@@ -0,0 +1,306 @@
+package winrego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testRegRegistry returns the test tree hive with
+// values of every type exported to .reg files
+func testRegRegistry(t *testing.T) *Registry {
+	r := testTreeRegistry(t)
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+
+	qword := make([]byte, 8)
+	binary.LittleEndian.PutUint64(qword, 0x0123456789abcdef)
+	values := []struct {
+		Name string
+		Type uint32
+		Data []byte
+	}{
+		{"", block.RegSz, block.EncodeUTF16(`C:\Program Files "x86"` + "\x00")},
+		{"Expand", block.RegExpandSz, block.EncodeUTF16("%SystemRoot%\x00")},
+		{"Multi", block.RegMultiSz, block.EncodeUTF16("a\x00b\x00\x00")},
+		{"Quad", block.RegQWord, qword},
+		{`Bin"ary\`, block.RegBinary, bytes.Repeat([]byte{0xab}, 30)},
+		{"None", block.RegNone, nil},
+		{"Odd", block.RegSz, []byte{'x', 0, 'y'}},
+		{"Short", block.RegDWord, []byte{1, 2}},
+		{"List", block.RegResourceRequirementsList, []byte{1}},
+	}
+	for _, v := range values {
+		if err := software.SetValue(v.Name, v.Type, v.Data); err != nil {
+			t.Fatalf("failed to set value %q: %v", v.Name, err)
+		}
+	}
+	return r
+}
+
+const testRegExportV5 = "Windows Registry Editor Version 5.00\r\n" +
+	"\r\n" +
+	"[HKEY_LOCAL_MACHINE\\SOFTWARE\\Software]\r\n" +
+	"\"Version\"=dword:00000007\r\n" +
+	"@=\"C:\\\\Program Files \\\"x86\\\"\"\r\n" +
+	"\"Expand\"=hex(2):25,00,53,00,79,00,73,00,74,00,65,00,6d,00,52,00,6f,00,6f,00,74,\\\r\n" +
+	"  00,25,00,00,00\r\n" +
+	"\"Multi\"=hex(7):61,00,00,00,62,00,00,00,00,00\r\n" +
+	"\"Quad\"=hex(b):ef,cd,ab,89,67,45,23,01\r\n" +
+	"\"Bin\\\"ary\\\\\"=hex:ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,\\\r\n" +
+	"  ab,ab,ab,ab,ab,ab,ab,ab,ab,ab\r\n" +
+	"\"None\"=hex(0):\r\n" +
+	"\"Odd\"=hex(1):78,00,79\r\n" +
+	"\"Short\"=hex(4):01,02\r\n" +
+	"\"List\"=hex(a):01\r\n" +
+	"\r\n" +
+	"[HKEY_LOCAL_MACHINE\\SOFTWARE\\Software\\Vendor]\r\n" +
+	"\r\n"
+
+func TestExportReg(t *testing.T) {
+	r := testRegRegistry(t)
+
+	var buf bytes.Buffer
+	if err := r.ExportReg(&buf, "Software", `HKEY_LOCAL_MACHINE\SOFTWARE`, RegFormatV5); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte{0xff, 0xfe}) {
+		t.Fatalf("export should start with UTF-16LE BOM, got %x", data[:2])
+	}
+	if got := block.DecodeUTF16(data[2:]); got != testRegExportV5 {
+		t.Errorf("export mismatch:\ngot\n%s\nwant\n%s", got, testRegExportV5)
+	}
+}
+
+func TestExportRegV4(t *testing.T) {
+	r := testRegRegistry(t)
+
+	var buf bytes.Buffer
+	if err := r.ExportReg(&buf, "", "", RegFormatV4); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"REGEDIT4\r\n\r\n[ROOT]\r\n\r\n[ROOT\\Software]\r\n",
+		"\"Expand\"=hex(2):25,53,79,73,74,65,6d,52,6f,6f,74,25,00\r\n",
+		"\"Multi\"=hex(7):61,00,62,00,00\r\n",
+		"[ROOT\\System\\Select]\r\n\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("export should contain %q, got\n%s", want, got)
+		}
+	}
+}
+
+func TestExportRegV4RoundTrip(t *testing.T) {
+	r := testRegRegistry(t)
+	key, err := r.CreateKey(`Software\Größe`)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	values := []struct {
+		Name string
+		Type uint32
+		Data []byte
+	}{
+		{"Latin", block.RegSz, block.EncodeUTF16("Größe\x00")},
+		{"Unicode", block.RegSz, block.EncodeUTF16("日本\x00")},
+		{"Expand", block.RegExpandSz, block.EncodeUTF16("%Größe%\x00")},
+		{"ExpandUnicode", block.RegExpandSz, block.EncodeUTF16("%日本%\x00")},
+		{"MultiUnicode", block.RegMultiSz, block.EncodeUTF16("日\x00本\x00\x00")},
+		{"Embedded", block.RegSz, block.EncodeUTF16("a\x00b\x00")},
+	}
+	for _, v := range values {
+		if err := key.SetValue(v.Name, v.Type, v.Data); err != nil {
+			t.Fatalf("failed to set value %q: %v", v.Name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.ExportReg(&buf, "", `HKEY_LOCAL_MACHINE\SOFTWARE`, RegFormatV4); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+	if !strings.Contains(buf.String(), "\"Latin\"=\"Gr\xf6\xdfe\"\r\n") {
+		t.Errorf("Latin-1 string should be written in ANSI, got\n%s", buf.String())
+	}
+	imported := testTreeRegistry(t)
+	if err := imported.ImportReg(&buf, `HKEY_LOCAL_MACHINE\SOFTWARE`); err != nil {
+		t.Fatalf("failed to import .reg file: %v", err)
+	}
+	if got, want := testTreeContent(t, imported), testTreeContent(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported hive mismatch:\ngot  %q\nwant %q", got, want)
+	}
+
+	// names out of Latin-1 range are not replaced
+	if _, err := key.CreateSubkey("日本"); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if err := r.ExportReg(&buf, "", "", RegFormatV4); !errors.Is(err, ErrRegFormatV4) {
+		t.Errorf("expected ErrRegFormatV4 for key path, got %v", err)
+	}
+	if err := key.DeleteSubkey("日本", false); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if err := key.SetValue("名前", block.RegDWord, []byte{1, 0, 0, 0}); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := r.ExportReg(&buf, "", "", RegFormatV4); !errors.Is(err, ErrRegFormatV4) {
+		t.Errorf("expected ErrRegFormatV4 for value name, got %v", err)
+	}
+	if err := key.DeleteValue("名前"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+
+	// odd data that would be read back as ANSI can not be written
+	if err := key.SetValue("Ambiguous", block.RegSz, []byte{'a', 'b', 0}); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := r.ExportReg(&buf, "", "", RegFormatV4); !errors.Is(err, ErrRegFormatV4) {
+		t.Errorf("expected ErrRegFormatV4 for value data, got %v", err)
+	}
+}
+
+func TestImportReg(t *testing.T) {
+	r := testTreeRegistry(t)
+	text := "Windows Registry Editor Version 5.00\r\n" +
+		"\r\n" +
+		"; comment\r\n" +
+		"[HKEY_CURRENT_USER\\Software\\New\\Nested]\r\n" +
+		"@=\"default\"\r\n" +
+		"\"Path\"=\"C:\\\\Temp \\\"x\\\"\"\r\n" +
+		"\"Count\"=dword:0000002a\r\n" +
+		"\"Blob\"=hex:01,02,\\\r\n" +
+		"  03,04,\\\r\n" +
+		"  05\r\n" +
+		"\"Multi\"=hex(7):61,00,00,00,00,00\r\n" +
+		"\r\n" +
+		"[hkey_current_user\\software]\r\n" +
+		"\"Version\"=-\r\n" +
+		"\"Missing\"=-\r\n" +
+		"\r\n" +
+		"[-HKEY_CURRENT_USER\\System]\r\n" +
+		"\"Ignored\"=dword:00000001\r\n" +
+		"[-HKEY_CURRENT_USER\\Missing]\r\n"
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xfe})
+	buf.Write(block.EncodeUTF16(text))
+	if err := r.ImportReg(&buf, "HKEY_CURRENT_USER"); err != nil {
+		t.Fatalf("failed to import .reg file: %v", err)
+	}
+
+	reloaded := testReloadHive(t, r)
+	content := testTreeContent(t, reloaded)
+	var values []string
+	for _, line := range content {
+		if i := strings.Index(line, " class="); i >= 0 {
+			line = line[:i]
+		}
+		values = append(values, line)
+	}
+	want := []string{
+		"",
+		`Software`,
+		`Software\New`,
+		`Software\New\Nested`,
+		`Software\New\Nested: REG_SZ 640065006600610075006c0074000000`,
+		`Software\New\Nested:Path REG_SZ 43003a005c00540065006d00700020002200780022000000`,
+		`Software\New\Nested:Count REG_DWORD 2a000000`,
+		`Software\New\Nested:Blob REG_BINARY 0102030405`,
+		`Software\New\Nested:Multi REG_MULTI_SZ 610000000000`,
+		`Software\Vendor`,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("imported hive mismatch:\ngot  %q\nwant %q", values, want)
+	}
+}
+
+func TestImportRegEncodings(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Data []byte
+		Want []byte
+	}{
+		{"ANSI", []byte("REGEDIT4\r\n\r\n[ROOT\\Software]\r\n\"Name\"=\"caf\xe9\"\r\n\"Exp\"=hex(2):25,78,25,00\r\n"), block.EncodeUTF16("caf\u00e9\x00")},
+		{"UTF-8", []byte("\xef\xbb\xbfWindows Registry Editor Version 5.00\n[ROOT\\Software]\n\"Name\"=\"caf\u00e9\"\n"), block.EncodeUTF16("caf\u00e9\x00")},
+		{"UTF-8 without BOM", []byte("REGEDIT4\n[ROOT\\Software]\n\"Name\"=\"\u03a9\"\n"), block.EncodeUTF16("\u03a9\x00")},
+	}
+	for _, tc := range testCases {
+		r := testTreeRegistry(t)
+		if err := r.ImportReg(bytes.NewReader(tc.Data), ""); err != nil {
+			t.Errorf("%s: failed to import .reg file: %v", tc.Name, err)
+			continue
+		}
+		software, err := r.OpenKey("Software")
+		if err != nil {
+			t.Fatalf("failed to open key: %v", err)
+		}
+		v, err := software.Value("Name")
+		if err != nil {
+			t.Errorf("%s: failed to get value: %v", tc.Name, err)
+			continue
+		}
+		if data, err := v.Data(); err != nil || !bytes.Equal(data, tc.Want) {
+			t.Errorf("%s: value data mismatch: got %x (%v), want %x", tc.Name, data, err, tc.Want)
+		}
+	}
+
+	// REGEDIT4 stores expandable strings in ANSI
+	r := testTreeRegistry(t)
+	if err := r.ImportReg(bytes.NewReader(testCases[0].Data), ""); err != nil {
+		t.Fatalf("failed to import .reg file: %v", err)
+	}
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	v, err := software.Value("Exp")
+	if err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	}
+	if data, err := v.Data(); err != nil || !bytes.Equal(data, block.EncodeUTF16("%x%\x00")) {
+		t.Errorf("expandable string should be converted to UTF-16, got %x (%v)", data, err)
+	}
+}
+
+func TestImportRegRoundTrip(t *testing.T) {
+	r := testRegRegistry(t)
+	var buf bytes.Buffer
+	if err := r.ExportReg(&buf, "", `HKEY_LOCAL_MACHINE\SOFTWARE`, RegFormatV5); err != nil {
+		t.Fatalf("failed to export hive: %v", err)
+	}
+
+	imported := testTreeRegistry(t)
+	if err := imported.ImportReg(&buf, `HKEY_LOCAL_MACHINE\SOFTWARE`); err != nil {
+		t.Fatalf("failed to import .reg file: %v", err)
+	}
+	if got, want := testTreeContent(t, imported), testTreeContent(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported hive mismatch:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestImportRegInvalid(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"REGEDIT5\r\n",
+		"REGEDIT4\r\n\"Name\"=dword:00000001\r\n",
+		"REGEDIT4\r\n[OTHER\\Software]\r\n",
+		"REGEDIT4\r\n[ROOT\\Software\r\n",
+		"REGEDIT4\r\n[ROOT]\r\n\"Name\"=dword:xyz\r\n",
+		"REGEDIT4\r\n[ROOT]\r\n\"Name\"=hex(2:00\r\n",
+		"REGEDIT4\r\n[ROOT]\r\n\"Name=\"x\"\r\n",
+		"REGEDIT4\r\n[-ROOT]\r\n",
+	} {
+		if err := testTreeRegistry(t).ImportReg(strings.NewReader(text), "ROOT"); !errors.Is(err, ErrRegFile) {
+			t.Errorf("expected ErrRegFile for %q, got %v", text, err)
+		}
+	}
+}
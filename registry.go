@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/turekt/winrego/block"
 )
@@ -22,6 +24,8 @@ const (
 	ReadHBinsRaw
 	// Reads padding data to Registry struct
 	ReadRemData
+	// Fails loading if base block checksum does not match its content
+	ReadValidateChecksum
 	// Reads and unmarshals all bytes to Registry struct fields
 	ReadAllUnmarshal = ReadHeader | ReadHBins | ReadRemData
 	// Reads base block header and sets the remaining bytes to raw fields
@@ -42,12 +46,25 @@ const (
 	WriteHBinsRaw
 	// Writes padding data to bytes
 	WriteRemData
+	// Recomputes base block checksum before writing, always done
+	// when the header of a hive modified by the write API is written
+	WriteChecksum
 	// Writes the unmarshaled data to bytes, with padding
 	WriteAllMarshal = WriteHeader | WriteHBins | WriteRemData
 	// Writes base block header and raw data including padding
 	WriteAllRaw = WriteHeader | WriteHBinsRaw | WriteRemData
 )
 
+const (
+	// Security descriptor of the root key of hives created by NewRegistry,
+	// full control for SYSTEM and Administrators inherited by subkeys
+	DefaultSecurityDescriptor = "O:BAG:SYD:P(A;CI;KA;;;SY)(A;CI;KA;;;BA)"
+)
+
+var (
+	ErrChecksum = errors.New("base block checksum does not match its content")
+)
+
 type Registry struct {
 	// Unmarshaled base block data
 	block.BaseBlock
@@ -59,6 +76,75 @@ type Registry struct {
 	File *os.File
 	// Raw hive data, base block not included
 	RawHiveData []byte
+	// Set when cells are changed through the write API, the base
+	// block checksum of a modified hive is recomputed on writing
+	modified bool
+}
+
+// NewRegistry returns an empty version 1.5 hive holding only the root
+// key with the provided name and security descriptor, the descriptor
+// parsed from DefaultSecurityDescriptor is used when sd is nil
+func NewRegistry(rootName string, sd *block.SecurityDescriptor) (*Registry, error) {
+	if sd == nil {
+		var err error
+		if sd, err = block.ParseSDDL(DefaultSecurityDescriptor); err != nil {
+			return nil, err
+		}
+	}
+
+	now := block.Filetime(time.Now())
+	r := &Registry{
+		BaseBlock: block.BaseBlock{
+			RegfHeader:       block.BaseBlockSignature,
+			Sequence1:        1,
+			Sequence2:        1,
+			LastWTimestamp:   now,
+			Major:            1,
+			Minor:            5,
+			FileFormat:       1,
+			ClusteringFactor: 1,
+		},
+	}
+
+	kn := &block.KeyNode{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'n', 'k'}},
+		KeyNodeData: block.KeyNodeData{
+			LastWTimestamp:      now,
+			SubkeysListOffset:   NoCellOffset,
+			VSubkeysListOffset:  NoCellOffset,
+			KeyValuesListOffset: NoCellOffset,
+			ClassNameOffset:     NoCellOffset,
+		},
+	}
+	if err := kn.SetName(rootName); err != nil {
+		return nil, err
+	}
+	kn.SetFlags(kn.Flags() | block.KeyHiveEntry | block.KeyNoDelete)
+	alloc := block.NewAllocator(&r.BaseBlock, &r.HBins)
+	rootOffset, err := alloc.Allocate(kn)
+	if err != nil {
+		return nil, err
+	}
+
+	data := sd.Bytes()
+	ks := &block.KeySecurity{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'s', 'k'}},
+		KeySecurityData: block.KeySecurityData{
+			RefCount:          1,
+			SecDescriptorSize: uint32(len(data)),
+		},
+		SecDescriptor: data,
+	}
+	skOffset, err := alloc.Allocate(ks)
+	if err != nil {
+		return nil, err
+	}
+	ks.Flink, ks.Blink = skOffset, skOffset
+	kn.KeySecurityOffset = skOffset
+
+	r.RootCellOffset = uint32(rootOffset)
+	r.HBins[0].Timestamp = now
+	return r, r.UpdateChecksum()
 }
 
 func OpenRegistry(filepath string, mode RegRModeFlag) (*Registry, error) {
@@ -106,6 +192,13 @@ func (r *Registry) Load(data []byte, mode RegRModeFlag) error {
 		return errors.New("not enough data supplied to load reg file header")
 	}
 
+	if (mode & ReadValidateChecksum) != 0 {
+		stored := binary.LittleEndian.Uint32(data[block.BaseBlockChecksumLength:])
+		if computed := block.BaseBlockChecksum(data); stored != computed {
+			return fmt.Errorf("%w: stored %#x, computed %#x", ErrChecksum, stored, computed)
+		}
+	}
+
 	hbSize := binary.LittleEndian.Uint32(data[40:44])
 	if (mode & ReadHeader) != 0 {
 		if err := block.Unmarshal(&r.BaseBlock, data[:block.BaseBlockSize]); err != nil {
@@ -142,6 +235,12 @@ func (r *Registry) Load(data []byte, mode RegRModeFlag) error {
 func (r *Registry) Bytes(mode RegWModeFlag) ([]byte, error) {
 	var buf bytes.Buffer
 
+	if (mode&WriteChecksum) != 0 || (r.modified && (mode&WriteHeader) != 0) {
+		if err := r.UpdateChecksum(); err != nil {
+			return nil, err
+		}
+	}
+
 	if (mode & WriteHeader) != 0 {
 		b, err := block.Marshal(&r.BaseBlock)
 		if err != nil {
@@ -168,3 +267,16 @@ func (r *Registry) Bytes(mode RegWModeFlag) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// ValidateChecksum returns ErrChecksum if the base block
+// checksum does not match the base block content
+func (r *Registry) ValidateChecksum() error {
+	computed, err := r.ComputeChecksum()
+	if err != nil {
+		return err
+	}
+	if computed != r.Checksum {
+		return fmt.Errorf("%w: stored %#x, computed %#x", ErrChecksum, r.Checksum, computed)
+	}
+	return nil
+}
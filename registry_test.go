@@ -1,6 +1,9 @@
 package winrego
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"os"
 	"path/filepath"
@@ -365,6 +368,38 @@ func TestWriteFlags(t *testing.T) {
 	}
 }
 
+func TestChecksumFlags(t *testing.T) {
+	th, root := testTreeHive()
+	data := th.bytes(root)
+
+	r := &Registry{}
+	if err := r.Load(data, ReadAllUnmarshal|ReadValidateChecksum); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected ErrChecksum loading hive without checksum, got %v", err)
+	}
+	if err := r.Load(data, ReadAllUnmarshal); err != nil {
+		t.Fatalf("failed to load hive: %v", err)
+	}
+	if err := r.ValidateChecksum(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected ErrChecksum validating hive without checksum, got %v", err)
+	}
+
+	data, err := r.Bytes(WriteAllMarshal | WriteChecksum)
+	if err != nil {
+		t.Fatalf("failed to marshal registry: %v", err)
+	}
+	if err := r.ValidateChecksum(); err != nil {
+		t.Errorf("checksum should be updated on write: %v", err)
+	}
+	if err := r.Load(data, ReadAllUnmarshal|ReadValidateChecksum); err != nil {
+		t.Errorf("failed to load hive with updated checksum: %v", err)
+	}
+
+	r.Sequence1++
+	if err := r.ValidateChecksum(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected ErrChecksum after modification, got %v", err)
+	}
+}
+
 func TestHBinHeaderMarshalCycle(t *testing.T) {
 	testFiles := testFilesList(t)
 
@@ -459,3 +494,229 @@ func TestRegistryMarshalHBinContent(t *testing.T) {
 		}
 	}
 }
+
+func TestNewRegistry(t *testing.T) {
+	r, err := NewRegistry("NEWHIVE", nil)
+	if err != nil {
+		t.Fatalf("failed to create registry: %v", err)
+	}
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	if root.Name() != "NEWHIVE" || !root.Flags().Has(block.KeyHiveEntry|block.KeyNoDelete) {
+		t.Errorf("root key mismatch: name %q, flags %s", root.Name(), root.Flags())
+	}
+	sd, err := root.SecurityDescriptor()
+	if err != nil {
+		t.Fatalf("failed to get security descriptor: %v", err)
+	}
+	if sddl, err := sd.SDDL(); err != nil || sddl != DefaultSecurityDescriptor {
+		t.Errorf("security descriptor mismatch: got %q (%v), want %q", sddl, err, DefaultSecurityDescriptor)
+	}
+	if err := r.ValidateChecksum(); err != nil {
+		t.Errorf("checksum of new registry should be valid: %v", err)
+	}
+
+	if _, err := r.CreateKey(`Software\Vendor`); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	reloaded := testReloadHive(t, r)
+	if reloaded.Major != 1 || reloaded.Minor != 5 || reloaded.HBinSize != block.HBinAlignment {
+		t.Errorf("base block mismatch: version %d.%d, hbins size %d", reloaded.Major, reloaded.Minor, reloaded.HBinSize)
+	}
+	if _, err := reloaded.OpenKey(`software\vendor`); err != nil {
+		t.Errorf("failed to open created key: %v", err)
+	}
+}
+
+// testHive builds a single hbin hive in memory from cells added
+// in order, cell fields can be changed after adding as long as
+// the marshaled cell size stays the same
+type testHive struct {
+	cells   []block.HCell
+	offsets []int32
+	next    int32
+}
+
+func newTestHive() *testHive {
+	return &testHive{next: block.HBinHeaderSize}
+}
+
+func (th *testHive) add(hc block.HCell) int32 {
+	data, err := block.Marshal(hc)
+	if err != nil {
+		panic(err)
+	}
+
+	size := int32(len(data)+7) &^ 7
+	pad := make([]byte, size-int32(len(data)))
+	cell := reflect.ValueOf(hc).Elem()
+	if dr, ok := hc.(*block.DataRecord); ok {
+		dr.Data = append(dr.Data, pad...)
+	} else {
+		padding := cell.FieldByName("Padding")
+		padding.SetBytes(append(padding.Bytes(), pad...))
+	}
+	cell.FieldByName("BlockSize").SetInt(int64(-size))
+
+	offset := th.next
+	th.cells = append(th.cells, hc)
+	th.offsets = append(th.offsets, offset)
+	th.next += size
+	return offset
+}
+
+func (th *testHive) bytes(rootOffset int32) []byte {
+	var cells bytes.Buffer
+	for _, hc := range th.cells {
+		data, err := block.Marshal(hc)
+		if err != nil {
+			panic(err)
+		}
+		cells.Write(data)
+	}
+
+	hbSize := (th.next + block.BaseBlockSize - 1) &^ (block.BaseBlockSize - 1)
+	if hbSize == th.next {
+		hbSize += block.BaseBlockSize
+	}
+	free := make([]byte, hbSize-th.next)
+	binary.LittleEndian.PutUint32(free, uint32(len(free)))
+	cells.Write(free)
+
+	hb := &block.HBin{
+		HBinHeader: block.HBinHeader{
+			HBinSignature: 0x6e696268,
+			HBinSize:      hbSize,
+		},
+	}
+	hbHeader, err := block.Marshal(&hb.HBinHeader)
+	if err != nil {
+		panic(err)
+	}
+
+	bb := &block.BaseBlock{
+		RegfHeader:       0x66676572,
+		Sequence1:        1,
+		Sequence2:        1,
+		Major:            1,
+		Minor:            5,
+		FileFormat:       1,
+		RootCellOffset:   uint32(rootOffset),
+		HBinSize:         uint32(hbSize),
+		ClusteringFactor: 1,
+	}
+	header, err := block.Marshal(bb)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.Write(hbHeader)
+	buf.Write(cells.Bytes())
+	return buf.Bytes()
+}
+
+func (th *testHive) registry(t *testing.T, rootOffset int32) *Registry {
+	r := &Registry{}
+	if err := r.Load(th.bytes(rootOffset), ReadAllUnmarshal); err != nil {
+		t.Fatalf("failed loading test hive: %v", err)
+	}
+	return r
+}
+
+func testKeyNode(name string, parent int32) *block.KeyNode {
+	kn := &block.KeyNode{
+		HCellData: block.HCellData{
+			HCellSignature: [2]byte{'n', 'k'},
+		},
+		KeyNodeData: block.KeyNodeData{
+			Parent:              parent,
+			SubkeysListOffset:   NoCellOffset,
+			VSubkeysListOffset:  NoCellOffset,
+			KeyValuesListOffset: NoCellOffset,
+			KeySecurityOffset:   NoCellOffset,
+			ClassNameOffset:     NoCellOffset,
+		},
+	}
+	if err := kn.SetName(name); err != nil {
+		panic(err)
+	}
+	return kn
+}
+
+func testKeyValue(name string, dataType uint32, dataSize int32, dataOffset int32) *block.KeyValue {
+	kv := &block.KeyValue{
+		HCellData: block.HCellData{
+			HCellSignature: [2]byte{'v', 'k'},
+		},
+		KeyValueData: block.KeyValueData{
+			DataSize:   dataSize,
+			DataOffset: dataOffset,
+			DataType:   dataType,
+		},
+	}
+	if err := kv.SetName(name); err != nil {
+		panic(err)
+	}
+	return kv
+}
+
+func testDataRecord(data []byte) *block.DataRecord {
+	return &block.DataRecord{Data: append([]byte{}, data...)}
+}
+
+func testKeySecurity(sddl string) *block.KeySecurity {
+	sd, err := block.ParseSDDL(sddl)
+	if err != nil {
+		panic(err)
+	}
+	data := sd.Bytes()
+	return &block.KeySecurity{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'s', 'k'}},
+		KeySecurityData: block.KeySecurityData{
+			SecDescriptorSize: uint32(len(data)),
+		},
+		SecDescriptor: data,
+	}
+}
+
+func testOffsetList(offsets ...int32) *block.DataRecord {
+	data := make([]byte, 4*len(offsets))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint32(data[i*4:], uint32(offset))
+	}
+	return &block.DataRecord{Data: data}
+}
+
+func testHashElement(offset int32, name string) block.NamedElement {
+	e := block.NamedElement{Offset: offset}
+	binary.LittleEndian.PutUint32(e.Name[:], block.NameHash(name))
+	return e
+}
+
+func testHintElement(offset int32, name string) block.NamedElement {
+	return block.NamedElement{Offset: offset, Name: block.NameHint(name)}
+}
+
+func testFastLeaf(elements ...block.NamedElement) *block.FastLeaf {
+	return &block.FastLeaf{
+		HCellData: block.HCellData{
+			HCellSignature: [2]byte{'l', 'f'},
+			Metadata:       uint16(len(elements)),
+		},
+		Elements: elements,
+	}
+}
+
+func testHashLeaf(elements ...block.NamedElement) *block.HashLeaf {
+	return &block.HashLeaf{
+		HCellData: block.HCellData{
+			HCellSignature: [2]byte{'l', 'h'},
+			Metadata:       uint16(len(elements)),
+		},
+		Elements: elements,
+	}
+}
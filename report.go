@@ -0,0 +1,102 @@
+package winrego
+
+import (
+	"fmt"
+)
+
+const (
+	// Only major version of the hive format
+	SupportedMajorVersion = 1
+	// Minor versions written from Windows NT 3.5 up to Windows 10
+	MinSupportedMinorVersion = 2
+	MaxSupportedMinorVersion = 6
+	// File type of primary hive files
+	FileTypePrimary = 0
+	// File format of hives that can be loaded directly into memory
+	FileFormatDirectMemoryLoad = 1
+)
+
+// HiveReport describes the base block consistency
+// and dirty state of a loaded hive
+type HiveReport struct {
+	// Sequence numbers differ, the hive was not fully
+	// written and has to be recovered from transaction logs
+	Dirty bool
+	// HBinSize from the base block matches the sum of hbin sizes
+	HBinSizeValid bool
+	// Sum of sizes of loaded hbins
+	HBinsTotalSize uint32
+	// RootCellOffset points at an allocated key node
+	RootValid bool
+	// Major and Minor version are supported
+	VersionSupported bool
+	// FileType denotes a primary file
+	FileTypeSupported bool
+	// FileFormat denotes a hive that can be loaded directly to memory
+	FileFormatSupported bool
+	// Timestamp of the first hbin equals the base block last written timestamp
+	TimestampValid bool
+	// Base block checksum matches its content
+	ChecksumValid bool
+	// Description of every failed check
+	Issues []string
+}
+
+// Consistent reports whether all checks passed
+func (hr *HiveReport) Consistent() bool {
+	return len(hr.Issues) == 0
+}
+
+func (hr *HiveReport) addIssue(format string, args ...any) {
+	hr.Issues = append(hr.Issues, fmt.Sprintf(format, args...))
+}
+
+// Report checks the base block of a loaded hive against its hbins
+// and returns the result of every check, it does not walk the tree
+func (r *Registry) Report() (*HiveReport, error) {
+	if len(r.HBins) == 0 {
+		return nil, ErrHBinsNotLoaded
+	}
+
+	hr := &HiveReport{HBinsTotalSize: r.HBins.TotalSize()}
+
+	if hr.Dirty = r.Sequence1 != r.Sequence2; hr.Dirty {
+		hr.addIssue("sequence numbers %d and %d differ, hive is dirty", r.Sequence1, r.Sequence2)
+	}
+
+	if hr.HBinSizeValid = r.HBinSize == hr.HBinsTotalSize; !hr.HBinSizeValid {
+		hr.addIssue("hbins size %d does not match hbins total size %d", r.HBinSize, hr.HBinsTotalSize)
+	}
+
+	if root, err := r.Root(); err != nil {
+		hr.addIssue("root cell offset %#x: %v", r.RootCellOffset, err)
+	} else if root.BlockSize >= 0 {
+		hr.addIssue("root cell at offset %#x is not allocated", r.RootCellOffset)
+	} else {
+		hr.RootValid = true
+	}
+
+	hr.VersionSupported = r.Major == SupportedMajorVersion &&
+		r.Minor >= MinSupportedMinorVersion && r.Minor <= MaxSupportedMinorVersion
+	if !hr.VersionSupported {
+		hr.addIssue("version %d.%d is not supported", r.Major, r.Minor)
+	}
+
+	if hr.FileTypeSupported = r.FileType == FileTypePrimary; !hr.FileTypeSupported {
+		hr.addIssue("file type %d is not a primary file", r.FileType)
+	}
+
+	if hr.FileFormatSupported = r.FileFormat == FileFormatDirectMemoryLoad; !hr.FileFormatSupported {
+		hr.addIssue("file format %d is not supported", r.FileFormat)
+	}
+
+	if hr.TimestampValid = r.HBins[0].Timestamp == r.LastWTimestamp; !hr.TimestampValid {
+		hr.addIssue("first hbin timestamp %#x does not match last written timestamp %#x", r.HBins[0].Timestamp, r.LastWTimestamp)
+	}
+
+	if hr.ChecksumValid = r.ValidChecksum(); !hr.ChecksumValid {
+		hr.addIssue("base block checksum %#x does not match its content", r.Checksum)
+	}
+
+	return hr, nil
+}
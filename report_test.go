@@ -0,0 +1,54 @@
+package winrego
+
+import (
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	r := testTreeRegistry(t)
+	if err := r.UpdateChecksum(); err != nil {
+		t.Fatalf("failed to update checksum: %v", err)
+	}
+
+	hr, err := r.Report()
+	if err != nil {
+		t.Fatalf("failed to create report: %v", err)
+	}
+	if !hr.Consistent() {
+		t.Errorf("test hive should be consistent, got issues %v", hr.Issues)
+	}
+	if got, want := hr.HBinsTotalSize, r.HBinSize; got != want {
+		t.Errorf("hbins total size mismatch: got %d, want %d", got, want)
+	}
+
+	ks, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root: %v", err)
+	}
+	r.Sequence1++
+	r.HBinSize += 4096
+	r.RootCellOffset = uint32(ks.KeySecurityOffset)
+	r.Minor = 9
+	r.FileType = 1
+	r.FileFormat = 2
+	r.HBins[0].Timestamp = 1
+
+	hr, err = r.Report()
+	if err != nil {
+		t.Fatalf("failed to create report: %v", err)
+	}
+	if !hr.Dirty || hr.HBinSizeValid || hr.RootValid || hr.VersionSupported ||
+		hr.FileTypeSupported || hr.FileFormatSupported || hr.TimestampValid || hr.ChecksumValid {
+		t.Errorf("all checks should fail, got %+v", hr)
+	}
+	if got, want := len(hr.Issues), 8; got != want {
+		t.Errorf("issue count mismatch: got %d, want %d: %v", got, want, hr.Issues)
+	}
+}
+
+func TestReportNotLoaded(t *testing.T) {
+	r := &Registry{}
+	if _, err := r.Report(); err != ErrHBinsNotLoaded {
+		t.Errorf("expected ErrHBinsNotLoaded, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package winrego
+
+import (
+	"fmt"
+
+	"github.com/turekt/winrego/block"
+)
+
+// SecurityEntry is a key security cell together with
+// the keys referencing it
+type SecurityEntry struct {
+	*block.KeySecurity
+	// Keys that reference this key security cell
+	Keys []*Key
+	// Problems found with this cell, e.g. broken
+	// list links or a wrong reference count
+	Issues []string
+}
+
+// SecurityList holds all key security cells of a hive in
+// the order they are linked, starting from the root key
+type SecurityList struct {
+	Entries []*SecurityEntry
+}
+
+// Valid reports whether no issues were found in any entry
+func (sl *SecurityList) Valid() bool {
+	for _, e := range sl.Entries {
+		if len(e.Issues) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Entry returns the entry of the key security cell at offset
+func (sl *SecurityList) Entry(offset int32) *SecurityEntry {
+	for _, e := range sl.Entries {
+		if e.AbsoluteOffset() == offset {
+			return e
+		}
+	}
+	return nil
+}
+
+// SecurityList walks the circular list of key security cells starting
+// from the cell referenced by the root key, verifies Flink and Blink
+// links and compares reference counts against the keys in the hive
+func (r *Registry) SecurityList() (*SecurityList, error) {
+	root, err := r.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := r.securityAt(root.KeySecurityOffset)
+	if err != nil {
+		return nil, fmt.Errorf("root key security: %w", err)
+	}
+
+	sl := &SecurityList{}
+	visited := make(map[int32]*SecurityEntry)
+	for ks := first; ; {
+		e := &SecurityEntry{KeySecurity: ks}
+		offset := ks.AbsoluteOffset()
+		sl.Entries = append(sl.Entries, e)
+		visited[offset] = e
+
+		if ks.Flink == first.AbsoluteOffset() {
+			if first.Blink != offset {
+				sl.Entries[0].addIssue("blink %#x does not point to last cell %#x", first.Blink, offset)
+			}
+			break
+		}
+		if _, ok := visited[ks.Flink]; ok {
+			e.addIssue("flink %#x loops back without reaching first cell %#x", ks.Flink, first.AbsoluteOffset())
+			break
+		}
+
+		next, err := r.securityAt(ks.Flink)
+		if err != nil {
+			e.addIssue("flink %#x is broken: %v", ks.Flink, err)
+			break
+		}
+		if next.Blink != offset {
+			e.addIssue("flink %#x points to cell with blink %#x", ks.Flink, next.Blink)
+		}
+		ks = next
+	}
+
+	err = root.Walk(func(k *Key) error {
+		offset := k.KeySecurityOffset
+		e, ok := visited[offset]
+		if !ok {
+			ks, err := r.securityAt(offset)
+			if err != nil {
+				return fmt.Errorf("key %s security: %w", k.Name(), err)
+			}
+			e = &SecurityEntry{KeySecurity: ks}
+			e.addIssue("cell is referenced by keys but not linked in the list")
+			sl.Entries = append(sl.Entries, e)
+			visited[offset] = e
+		}
+		e.Keys = append(e.Keys, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range sl.Entries {
+		if e.RefCount != uint32(len(e.Keys)) {
+			e.addIssue("reference count %d does not match %d referencing keys", e.RefCount, len(e.Keys))
+		}
+	}
+	return sl, nil
+}
+
+func (e *SecurityEntry) addIssue(format string, args ...any) {
+	e.Issues = append(e.Issues, fmt.Sprintf(format, args...))
+}
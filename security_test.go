@@ -0,0 +1,105 @@
+package winrego
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+func TestSecurityList(t *testing.T) {
+	r := testTreeRegistry(t)
+
+	sl, err := r.SecurityList()
+	if err != nil {
+		t.Fatalf("failed to walk security list: %v", err)
+	}
+	if !sl.Valid() {
+		t.Errorf("security list should be valid: %+v", sl.Entries[0].Issues)
+	}
+	if got, want := len(sl.Entries), 1; got != want {
+		t.Fatalf("security entries mismatch: got %d, want %d", got, want)
+	}
+	if got, want := len(sl.Entries[0].Keys), 6; got != want {
+		t.Errorf("referencing keys mismatch: got %d, want %d", got, want)
+	}
+	if e := sl.Entry(sl.Entries[0].AbsoluteOffset()); e != sl.Entries[0] {
+		t.Errorf("entry lookup mismatch: got %v", e)
+	}
+}
+
+func TestSecurityListIssues(t *testing.T) {
+	th := newTestHive()
+	root := th.add(testKeyNode("ROOT", 0))
+	child := th.add(testKeyNode("Child", root))
+	grandchild := th.add(testKeyNode("Grandchild", child))
+	th.add(testKeyNode("Orphan", child))
+	sk1 := th.add(testKeySecurity("O:SYD:(A;;KA;;;SY)"))
+	sk2 := th.add(testKeySecurity("O:BAD:(A;;KA;;;BA)"))
+	sk3 := th.add(testKeySecurity("O:BUD:(A;;KR;;;BU)"))
+	rootList := th.add(testHashLeaf(testHashElement(child, "Child")))
+	childList := th.add(testHashLeaf(testHashElement(grandchild, "Grandchild")))
+
+	keys := []*block.KeyNode{
+		th.cells[0].(*block.KeyNode),
+		th.cells[1].(*block.KeyNode),
+		th.cells[2].(*block.KeyNode),
+		th.cells[3].(*block.KeyNode),
+	}
+	keys[0].SubkeysCount, keys[0].SubkeysListOffset = 1, rootList
+	keys[1].SubkeysCount, keys[1].SubkeysListOffset = 1, childList
+	keys[0].KeySecurityOffset = sk1
+	keys[1].KeySecurityOffset = sk2
+	keys[2].KeySecurityOffset = sk3
+	// orphan key is not reachable and is not counted
+	keys[3].KeySecurityOffset = sk2
+
+	cells := []*block.KeySecurity{
+		th.cells[4].(*block.KeySecurity),
+		th.cells[5].(*block.KeySecurity),
+		th.cells[6].(*block.KeySecurity),
+	}
+	cells[0].Flink, cells[0].Blink, cells[0].RefCount = sk2, sk2, 1
+	cells[1].Flink, cells[1].Blink, cells[1].RefCount = sk1, root, 2
+	cells[2].Flink, cells[2].Blink, cells[2].RefCount = sk3, sk3, 1
+
+	r := th.registry(t, root)
+	sl, err := r.SecurityList()
+	if err != nil {
+		t.Fatalf("failed to walk security list: %v", err)
+	}
+	if sl.Valid() {
+		t.Fatalf("security list should not be valid")
+	}
+	if got, want := len(sl.Entries), 3; got != want {
+		t.Fatalf("security entries mismatch: got %d, want %d", got, want)
+	}
+
+	expect := []struct {
+		Offset int32
+		Keys   int
+		Issues []string
+	}{
+		{sk1, 1, []string{"points to cell with blink"}},
+		{sk2, 1, []string{"reference count 2 does not match 1"}},
+		{sk3, 1, []string{"not linked in the list"}},
+	}
+	for i, e := range expect {
+		entry := sl.Entries[i]
+		if got, want := entry.AbsoluteOffset(), e.Offset; got != want {
+			t.Errorf("entry %d offset mismatch: got %#x, want %#x", i, got, want)
+		}
+		if got, want := len(entry.Keys), e.Keys; got != want {
+			t.Errorf("entry %d keys mismatch: got %d, want %d", i, got, want)
+		}
+		if got, want := len(entry.Issues), len(e.Issues); got != want {
+			t.Errorf("entry %d issue count mismatch: got %v, want %v", i, entry.Issues, e.Issues)
+			continue
+		}
+		for j, issue := range e.Issues {
+			if !strings.Contains(entry.Issues[j], issue) {
+				t.Errorf("entry %d issue mismatch: got %q, want %q", i, entry.Issues[j], issue)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/turekt/winrego/block"
+)
+
+const (
+	// Runs of zero bytes at least this long separate slack regions
+	SlackZeroGap = 16
+	// Minimal number of characters of a string found in slack
+	SlackMinStringLength = 4
+)
+
+// Slack is a region of non-zero bytes left in cell padding
+// or in remnant data following the last hbin
+type Slack struct {
+	// Offset of the region from the start of hbins data
+	Offset int32
+	// Cell whose padding holds the region, nil for remnant data
+	Owner block.HCell
+	Data  []byte
+	// UTF-16 strings found in the region
+	Strings []SlackString
+	// Key node and key value record fragments found in the region
+	Fragments []SlackFragment
+}
+
+// SlackString is a UTF-16 string found in slack
+type SlackString struct {
+	// Offset of the string from the start of hbins data
+	Offset int32
+	Value  string
+}
+
+// SlackFragment is a record parsed from slack, the record is parsed
+// from a copy of slack and its cell offsets are not meaningful
+type SlackFragment struct {
+	// Offset of the record from the start of hbins data
+	Offset int32
+	Cell   block.HCell
+}
+
+// Slack enumerates non-zero regions of cell padding and remnant data
+// and interprets them as leftover UTF-16 strings and fragments of old
+// key node and key value records
+func (r *Registry) Slack() ([]*Slack, error) {
+	if len(r.HBins) == 0 {
+		return nil, ErrHBinsNotLoaded
+	}
+
+	used := r.dataCellsUsed()
+	var slack []*Slack
+	for i := range r.HBins {
+		for _, hc := range r.HBins[i].Cells {
+			padding := cellPadding(hc, used)
+			if len(padding) == 0 {
+				continue
+			}
+			start := hc.AbsoluteOffset() + hc.Size() - int32(len(padding))
+			slack = append(slack, slackRegions(padding, start, hc)...)
+		}
+	}
+	slack = append(slack, slackRegions(r.RemnantData, int32(r.HBins.TotalSize()), nil)...)
+	return slack, nil
+}
+
+// dataCellsUsed maps offsets of data cells referenced by allocated key
+// nodes and key values to the number of bytes the referencing record uses
+func (r *Registry) dataCellsUsed() map[int32]int {
+	used := make(map[int32]int)
+	for i := range r.HBins {
+		for _, hc := range r.HBins[i].Cells {
+			if !hc.Allocated() {
+				continue
+			}
+			switch cell := hc.(type) {
+			case *block.KeyNode:
+				if cell.KeyValuesCount > 0 {
+					used[cell.KeyValuesListOffset] = int(cell.KeyValuesCount) * 4
+				}
+				if cell.ClassNameLength > 0 {
+					used[cell.ClassNameOffset] = int(cell.ClassNameLength)
+				}
+			case *block.KeyValue:
+				size := cell.DataLength()
+				if cell.IsDataInline() || size == 0 || block.UsesBigData(r.Minor, size) {
+					continue
+				}
+				used[cell.DataOffset] = int(size)
+			}
+		}
+	}
+	return used
+}
+
+// cellPadding returns padding of cells that hold a record, data cells
+// are padded past the number of bytes used by the record referencing
+// them and unreferenced data cells have no padding of their own
+func cellPadding(hc block.HCell, used map[int32]int) []byte {
+	switch cell := hc.(type) {
+	case *block.KeyNode:
+		return cell.Padding
+	case *block.KeyValue:
+		return cell.Padding
+	case *block.KeySecurity:
+		return cell.Padding
+	case *block.BigData:
+		return cell.Padding
+	case *block.IndexLeaf:
+		return cell.Padding
+	case *block.FastLeaf:
+		return cell.Padding
+	case *block.HashLeaf:
+		return cell.Padding
+	case *block.IndexRoot:
+		return cell.Padding
+	case *block.DataRecord:
+		if n, ok := used[cell.AbsoluteOffset()]; ok && n < len(cell.Data) {
+			return cell.Data[n:]
+		}
+	}
+	return nil
+}
+
+// slackRegions splits data at runs of zero bytes and interprets
+// each non-zero region, offset is the offset of data
+func slackRegions(data []byte, offset int32, owner block.HCell) []*Slack {
+	var regions []*Slack
+	for start := 0; start < len(data); {
+		if data[start] == 0 {
+			start++
+			continue
+		}
+
+		end, zeros := start, 0
+		for i := start; i < len(data) && zeros < SlackZeroGap; i++ {
+			if data[i] == 0 {
+				zeros++
+			} else {
+				end, zeros = i+1, 0
+			}
+		}
+		// keep the terminating byte of UTF-16 characters
+		if (end-start)%2 != 0 && end < len(data) {
+			end++
+		}
+
+		s := &Slack{Offset: offset + int32(start), Owner: owner, Data: data[start:end]}
+		s.Strings = slackStrings(s.Data, s.Offset)
+		s.Fragments = slackFragments(data, start, end, offset)
+		regions = append(regions, s)
+		start = end
+	}
+	return regions
+}
+
+// slackStrings finds printable UTF-16 strings at both byte alignments,
+// characters from U+2000 up are ignored as misaligned ASCII decodes to them
+func slackStrings(data []byte, offset int32) []SlackString {
+	var strings []SlackString
+	for align := 0; align < 2; align++ {
+		var units []uint16
+		start := align
+		flush := func(end int) {
+			if len(units) >= SlackMinStringLength {
+				strings = append(strings, SlackString{offset + int32(start), string(utf16.Decode(units))})
+			}
+			units, start = nil, end
+		}
+
+		for i := align; i+2 <= len(data); i += 2 {
+			u := binary.LittleEndian.Uint16(data[i:])
+			if u >= 0x2000 || !unicode.IsPrint(rune(u)) {
+				flush(i + 2)
+				continue
+			}
+			units = append(units, u)
+		}
+		flush(len(data))
+	}
+	return strings
+}
+
+// slackFragments parses key node and key value records starting in
+// data between start and end, records may extend past the region as
+// they can contain zero bytes, offset is the offset of data
+func slackFragments(data []byte, start, end int, offset int32) []SlackFragment {
+	var fragments []SlackFragment
+	for i := start; i < end && i+block.HCellDataSize <= len(data); i++ {
+		sig := string(data[i+4 : i+6])
+		if sig != "nk" && sig != "vk" {
+			continue
+		}
+		if hc := block.ParseFragment(data[i:]); hc != nil {
+			fragments = append(fragments, SlackFragment{offset + int32(i), hc})
+		}
+	}
+	return fragments
+}
@@ -0,0 +1,115 @@
+package winrego
+
+import (
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+func TestSlack(t *testing.T) {
+	th := newTestHive()
+	kn := testKeyNode("ROOT", NoCellOffset)
+	kn.Padding = append([]byte{0, 0}, block.EncodeUTF16("secret")...)
+	root := th.add(kn)
+	th.add(testKeyValue("Clean", block.RegDWord, -0x7ffffffc, 1))
+
+	oldKv := testKeyValue("Old", block.RegDWord, -0x7ffffffc, 7)
+	oldKv.BlockSize = 32
+	old, err := block.Marshal(oldKv)
+	if err != nil {
+		t.Fatalf("failed to marshal old record: %v", err)
+	}
+	hive := append(th.bytes(root), old...)
+
+	r := &Registry{}
+	if err := r.Load(hive, ReadAllUnmarshal); err != nil {
+		t.Fatalf("failed loading test hive: %v", err)
+	}
+	slack, err := r.Slack()
+	if err != nil {
+		t.Fatalf("failed to extract slack: %v", err)
+	}
+	if got, want := len(slack), 2; got != want {
+		t.Fatalf("slack region count mismatch: got %d, want %d", got, want)
+	}
+
+	owned := slack[0]
+	if owned.Owner == nil || owned.Owner.AbsoluteOffset() != root {
+		t.Errorf("slack owner mismatch: got %v, want cell at %#x", owned.Owner, root)
+	}
+	paddingOffset := root + int32(kn.Size()) - int32(len(kn.Padding))
+	if got, want := owned.Offset, paddingOffset+2; got != want {
+		t.Errorf("slack offset mismatch: got %#x, want %#x", got, want)
+	}
+	if len(owned.Strings) != 1 || owned.Strings[0].Value != "secret" || owned.Strings[0].Offset != owned.Offset {
+		t.Errorf("slack strings mismatch: got %+v", owned.Strings)
+	}
+
+	remnant := slack[1]
+	if remnant.Owner != nil {
+		t.Errorf("remnant data should have no owner, got %v", remnant.Owner)
+	}
+	if got, want := remnant.Offset, int32(r.HBinSize); got != want {
+		t.Errorf("remnant offset mismatch: got %#x, want %#x", got, want)
+	}
+	if len(remnant.Fragments) != 1 {
+		t.Fatalf("remnant fragment count mismatch: got %d, want 1", len(remnant.Fragments))
+	}
+	kv, ok := remnant.Fragments[0].Cell.(*block.KeyValue)
+	if !ok || kv.Name() != "Old" || remnant.Fragments[0].Offset != remnant.Offset {
+		t.Errorf("remnant fragment mismatch: got %+v", remnant.Fragments[0])
+	}
+}
+
+func TestSlackHBinsNotLoaded(t *testing.T) {
+	if _, err := (&Registry{}).Slack(); err != ErrHBinsNotLoaded {
+		t.Errorf("expected ErrHBinsNotLoaded, got %v", err)
+	}
+}
+
+func TestSlackDataCells(t *testing.T) {
+	th := newTestHive()
+	// shorter data overwrote the longer one in place
+	longer := block.EncodeUTF16(`C:\Program Files\Vendor` + "\x00")
+	shorter := block.EncodeUTF16(`C:\x` + "\x00")
+	data := th.add(&block.DataRecord{Data: append(append([]byte{}, shorter...), longer[len(shorter):]...)})
+	kv := th.add(testKeyValue("Path", block.RegSz, int32(len(shorter)), data))
+	// second value was deleted leaving its offset in the list
+	stale := th.add(testKeyValue("Stale", block.RegDWord, -0x7ffffffc, 1))
+	values := th.add(testOffsetList(kv, stale))
+
+	kn := testKeyNode("ROOT", NoCellOffset)
+	kn.KeyValuesCount, kn.KeyValuesListOffset = 1, values
+	root := th.add(kn)
+	r := th.registry(t, root)
+
+	slack, err := r.Slack()
+	if err != nil {
+		t.Fatalf("failed to extract slack: %v", err)
+	}
+	owners := make(map[int32]*Slack)
+	for _, s := range slack {
+		if s.Owner != nil {
+			owners[s.Owner.AbsoluteOffset()] = s
+		}
+	}
+
+	s, ok := owners[data]
+	if !ok {
+		t.Fatalf("value data cell should hold slack, got %+v", slack)
+	}
+	if got, want := s.Offset, data+4+int32(len(shorter)); got != want {
+		t.Errorf("value data slack offset mismatch: got %#x, want %#x", got, want)
+	}
+	if len(s.Strings) != 1 || s.Strings[0].Value != `ogram Files\Vendor` {
+		t.Errorf("value data slack strings mismatch: got %+v", s.Strings)
+	}
+
+	s, ok = owners[values]
+	if !ok {
+		t.Fatalf("values list cell should hold slack, got %+v", slack)
+	}
+	if got, want := s.Offset, values+4+4; got != want {
+		t.Errorf("values list slack offset mismatch: got %#x, want %#x", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+package winrego
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/turekt/winrego/block"
+)
+
+var (
+	ErrDataType = errors.New("value data type mismatch")
+	ErrDataSize = errors.New("value data size mismatch")
+)
+
+// Value is a high level view of a key value that resolves
+// the offsets stored in the key value against its registry
+type Value struct {
+	*block.KeyValue
+	registry *Registry
+}
+
+func (r *Registry) valueAt(offset int32) (*Value, error) {
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	kv, ok := hc.(*block.KeyValue)
+	if !ok {
+		return nil, cellTypeError(offset, hc, "vk")
+	}
+	return &Value{kv, r}, nil
+}
+
+func (v *Value) Type() uint32 {
+	return v.DataType
+}
+
+// Data returns raw value data, either stored inline
+// or in a separate data cell
+func (v *Value) Data() ([]byte, error) {
+	if v.IsDataInline() {
+		return v.InlineData(), nil
+	}
+
+	size := v.DataLength()
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	var data []byte
+	var err error
+	if block.UsesBigData(v.registry.Minor, size) {
+		data, err = v.registry.bigDataAt(v.DataOffset)
+	} else {
+		data, err = v.registry.dataAt(v.DataOffset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(data)) < size {
+		return nil, fmt.Errorf("%w: data cell at offset %#x holds %d bytes, value size is %d", ErrDataSize, v.DataOffset, len(data), size)
+	}
+	return data[:size], nil
+}
+
+// bigDataAt reassembles data stored in segments
+// referenced by the big data cell at offset
+func (r *Registry) bigDataAt(offset int32) ([]byte, error) {
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	bd, ok := hc.(*block.BigData)
+	if !ok {
+		// data not exceeding a single segment can be stored directly
+		return cellPayload(hc)
+	}
+
+	list, err := r.dataAt(bd.DataOffset)
+	if err != nil {
+		return nil, err
+	}
+	count := int(bd.SegmentCount())
+	if count*4 > len(list) {
+		return nil, fmt.Errorf("segment list at offset %#x holds less than %d segments", bd.DataOffset, count)
+	}
+
+	data := make([]byte, 0, count*block.BigDataSegmentSize)
+	for i := 0; i < count; i++ {
+		segOffset := int32(binary.LittleEndian.Uint32(list[i*4:]))
+		segment, err := r.dataAt(segOffset)
+		if err != nil {
+			return nil, err
+		}
+		if len(segment) > block.BigDataSegmentSize {
+			segment = segment[:block.BigDataSegmentSize]
+		}
+		data = append(data, segment...)
+	}
+	return data, nil
+}
+
+// DataString decodes REG_SZ, REG_EXPAND_SZ and REG_LINK data,
+// the string ends at the first null character if present
+func (v *Value) DataString() (string, error) {
+	if err := v.assertType(block.RegSz, block.RegExpandSz, block.RegLink); err != nil {
+		return "", err
+	}
+
+	data, err := v.Data()
+	if err != nil {
+		return "", err
+	}
+	return decodeString(data), nil
+}
+
+// DataStrings decodes REG_MULTI_SZ data, the list ends
+// at the first empty string
+func (v *Value) DataStrings() ([]string, error) {
+	if err := v.assertType(block.RegMultiSz); err != nil {
+		return nil, err
+	}
+
+	data, err := v.Data()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMultiString(data), nil
+}
+
+// DataUint32 decodes REG_DWORD and REG_DWORD_BIG_ENDIAN data
+func (v *Value) DataUint32() (uint32, error) {
+	if err := v.assertType(block.RegDWord, block.RegDWordBigEndian); err != nil {
+		return 0, err
+	}
+
+	data, err := v.Data()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, fmt.Errorf("%w: %s value %q has %d bytes, expected 4", ErrDataSize, block.TypeName(v.DataType), v.Name(), len(data))
+	}
+
+	if v.DataType == block.RegDWordBigEndian {
+		return binary.BigEndian.Uint32(data), nil
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// DataUint64 decodes REG_QWORD data
+func (v *Value) DataUint64() (uint64, error) {
+	if err := v.assertType(block.RegQWord); err != nil {
+		return 0, err
+	}
+
+	data, err := v.Data()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("%w: %s value %q has %d bytes, expected 8", ErrDataSize, block.TypeName(v.DataType), v.Name(), len(data))
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// Decode returns value data converted according to its type:
+//   - string for REG_SZ, REG_EXPAND_SZ and REG_LINK
+//   - []string for REG_MULTI_SZ
+//   - uint32 for REG_DWORD and REG_DWORD_BIG_ENDIAN
+//   - uint64 for REG_QWORD
+//   - []byte for all other types
+func (v *Value) Decode() (any, error) {
+	switch v.DataType {
+	case block.RegSz, block.RegExpandSz, block.RegLink:
+		return v.DataString()
+	case block.RegMultiSz:
+		return v.DataStrings()
+	case block.RegDWord, block.RegDWordBigEndian:
+		return v.DataUint32()
+	case block.RegQWord:
+		return v.DataUint64()
+	default:
+		return v.Data()
+	}
+}
+
+func (v *Value) assertType(types ...uint32) error {
+	for _, t := range types {
+		if v.DataType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: value %q is of type %s", ErrDataType, v.Name(), block.TypeName(v.DataType))
+}
+
+func decodeString(data []byte) string {
+	s := block.DecodeUTF16(data)
+	if i := strings.IndexRune(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func decodeMultiString(data []byte) []string {
+	strs := make([]string, 0)
+	for _, s := range strings.Split(block.DecodeUTF16(data), "\x00") {
+		if s == "" {
+			break
+		}
+		strs = append(strs, s)
+	}
+	return strs
+}
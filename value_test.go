@@ -0,0 +1,151 @@
+package winrego
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testRootValues attaches values to the root key of the test hive,
+// loads the hive and returns the values as listed by the root key
+func testRootValues(t *testing.T, th *testHive, values ...*block.KeyValue) []*Value {
+	root := th.add(testKeyNode("ROOT", 0))
+	kn := th.cells[len(th.cells)-1].(*block.KeyNode)
+
+	var offsets []int32
+	for _, kv := range values {
+		offsets = append(offsets, th.add(kv))
+	}
+	kn.KeyValuesCount = int32(len(offsets))
+	kn.KeyValuesListOffset = th.add(testOffsetList(offsets...))
+
+	r := th.registry(t, root)
+	k, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	vs, err := k.Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	return vs
+}
+
+// testValueWithData adds a data cell to the test hive and
+// returns a value referencing it
+func testValueWithData(th *testHive, name string, dataType uint32, data []byte) *block.KeyValue {
+	offset := th.add(testDataRecord(data))
+	return testKeyValue(name, dataType, int32(len(data)), offset)
+}
+
+func TestValueDecode(t *testing.T) {
+	th := newTestHive()
+	values := []*block.KeyValue{
+		testValueWithData(th, "sz", block.RegSz, block.EncodeUTF16("hello\x00")),
+		testValueWithData(th, "unterminated", block.RegExpandSz, block.EncodeUTF16("%SystemRoot%")),
+		testValueWithData(th, "garbage", block.RegSz, append(block.EncodeUTF16("ab\x00cd"), 0x41)),
+		testValueWithData(th, "link", block.RegLink, block.EncodeUTF16(`\Registry\Machine`)),
+		testValueWithData(th, "multi", block.RegMultiSz, block.EncodeUTF16("a\x00bc\x00\x00")),
+		testValueWithData(th, "qword", block.RegQWord, []byte{1, 2, 3, 4, 5, 6, 7, 8}),
+		testValueWithData(th, "binary", block.RegBinary, []byte{0xde, 0xad, 0xbe, 0xef, 0x01}),
+		testKeyValue("dword", block.RegDWord, -0x7ffffffc, 0x01020304),
+		testKeyValue("bigendian", block.RegDWordBigEndian, -0x7ffffffc, 0x01020304),
+		testKeyValue("inline", block.RegBinary, -0x7ffffffd, 0x01020304),
+		testKeyValue("empty", block.RegSz, -0x80000000, 0),
+		testKeyValue("none", block.RegNone, 0, NoCellOffset),
+	}
+	vs := testRootValues(t, th, values...)
+
+	expect := []any{
+		"hello",
+		"%SystemRoot%",
+		"ab",
+		`\Registry\Machine`,
+		[]string{"a", "bc"},
+		uint64(0x0807060504030201),
+		[]byte{0xde, 0xad, 0xbe, 0xef, 0x01},
+		uint32(0x01020304),
+		uint32(0x04030201),
+		[]byte{0x04, 0x03, 0x02},
+		"",
+		[]byte{},
+	}
+	for i, v := range vs {
+		got, err := v.Decode()
+		if err != nil {
+			t.Errorf("failed to decode value %s: %v", v.Name(), err)
+			continue
+		}
+		if want := expect[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("value %s mismatch: got %#v, want %#v", v.Name(), got, want)
+		}
+	}
+}
+
+func TestValueDecodeErrors(t *testing.T) {
+	th := newTestHive()
+	values := []*block.KeyValue{
+		testKeyValue("shortdword", block.RegDWord, -0x7ffffffe, 0x0102),
+		testValueWithData(th, "longdword", block.RegDWord, []byte{1, 2, 3, 4, 5, 6}),
+		testValueWithData(th, "shortqword", block.RegQWord, []byte{1, 2, 3, 4}),
+		testValueWithData(th, "overflow", block.RegBinary, []byte{1, 2, 3, 4}),
+		testValueWithData(th, "sz", block.RegSz, block.EncodeUTF16("text")),
+	}
+	values[3].DataSize = 0x100
+	vs := testRootValues(t, th, values...)
+
+	for _, v := range vs[:4] {
+		if _, err := v.Decode(); !errors.Is(err, ErrDataSize) {
+			t.Errorf("expected ErrDataSize for %s, got %v", v.Name(), err)
+		}
+	}
+	if _, err := vs[4].DataUint32(); !errors.Is(err, ErrDataType) {
+		t.Errorf("expected ErrDataType for %s, got %v", vs[4].Name(), err)
+	}
+	if _, err := vs[0].DataStrings(); !errors.Is(err, ErrDataType) {
+		t.Errorf("expected ErrDataType for %s, got %v", vs[0].Name(), err)
+	}
+}
+
+func TestValueBigData(t *testing.T) {
+	data := make([]byte, 2*block.BigDataSegmentSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	th := newTestHive()
+	seg1 := th.add(testDataRecord(data[:block.BigDataSegmentSize]))
+	seg2 := th.add(testDataRecord(data[block.BigDataSegmentSize : 2*block.BigDataSegmentSize]))
+	// last segment is followed by unrelated bytes
+	seg3 := th.add(testDataRecord(append(data[2*block.BigDataSegmentSize:], 0xff, 0xff)))
+	segList := th.add(testOffsetList(seg1, seg2, seg3))
+	db := th.add(&block.BigData{
+		HCellData:  block.HCellData{HCellSignature: [2]byte{'d', 'b'}, Metadata: 3},
+		DataOffset: segList,
+	})
+	small := th.add(testDataRecord([]byte{1, 2, 3}))
+	vs := testRootValues(t, th,
+		testKeyValue("big", block.RegBinary, int32(len(data)), db),
+		testKeyValue("small", block.RegBinary, 3, small),
+	)
+
+	got, err := vs[0].Data()
+	if err != nil {
+		t.Fatalf("failed to read big data: %v", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("big data mismatch: got %d bytes, want %d bytes", len(got), len(data))
+	}
+	if got, err := vs[1].Data(); err != nil || !reflect.DeepEqual(got, []byte{1, 2, 3}) {
+		t.Errorf("small data mismatch: got %v, %v", got, err)
+	}
+
+	// hives before 1.4 store data in a single cell, the big data
+	// cell is then just the start of value data
+	vs[0].registry.Minor = 3
+	if _, err := vs[0].Data(); !errors.Is(err, ErrDataSize) {
+		t.Errorf("expected ErrDataSize for 1.3 hive, got %v", err)
+	}
+}
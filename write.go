@@ -0,0 +1,641 @@
+package winrego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/turekt/winrego/block"
+)
+
+const (
+	// Maximum number of characters in key and value names
+	MaxKeyNameLength   = 255
+	MaxValueNameLength = 16383
+	// Number of elements after which subkeys leaves are split under
+	// an index root, as many as Windows fits in a single hbin
+	MaxIndexLeafElements = 1012
+	MaxFastLeafElements  = 505
+	// Minor versions of the format that introduced fast and hash leaves
+	FastLeafMinorVersion = 3
+	HashLeafMinorVersion = 5
+)
+
+var (
+	ErrInvalidName   = errors.New("invalid key or value name")
+	ErrKeyHasSubkeys = errors.New("key has subkeys")
+)
+
+// CreateKey opens the key at a backslash separated path relative
+// to the root key, creating missing keys along the path
+func (r *Registry) CreateKey(path string) (*Key, error) {
+	key, err := r.Root()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(path, `\`) {
+		if name == "" {
+			continue
+		}
+		if key, err = key.CreateSubkey(name); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// CreateSubkey creates a direct subkey with the provided name which
+// inherits the key security of this key, the existing subkey is
+// returned if there is one, names are compared case insensitively
+func (k *Key) CreateSubkey(name string) (*Key, error) {
+	if name == "" || strings.Contains(name, `\`) || utf16Length(name) > MaxKeyNameLength {
+		return nil, fmt.Errorf("%w: key name %q", ErrInvalidName, name)
+	}
+	var notFound *KeyNotFoundError
+	if sk, err := k.Subkey(name); err == nil {
+		return sk, nil
+	} else if !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := block.Filetime(time.Now())
+	kn := &block.KeyNode{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'n', 'k'}},
+		KeyNodeData: block.KeyNodeData{
+			LastWTimestamp:      now,
+			Parent:              k.AbsoluteOffset(),
+			SubkeysListOffset:   NoCellOffset,
+			VSubkeysListOffset:  NoCellOffset,
+			KeyValuesListOffset: NoCellOffset,
+			KeySecurityOffset:   k.KeySecurityOffset,
+			ClassNameOffset:     NoCellOffset,
+		},
+	}
+	if err := kn.SetName(name); err != nil {
+		return nil, err
+	}
+
+	var ks *block.KeySecurity
+	if k.KeySecurityOffset != NoCellOffset {
+		if ks, err = k.Security(); err != nil {
+			return nil, err
+		}
+	}
+	offset, err := k.registry.allocate(kn)
+	if err != nil {
+		return nil, err
+	}
+
+	sk := &Key{kn, k.registry}
+	if err := k.writeSubkeys(append(subkeys, sk)); err != nil {
+		return nil, k.registry.freeOnError(offset, err)
+	}
+	// the key security is referenced only once the key is linked
+	if ks != nil {
+		ks.RefCount++
+	}
+	if length := uint16(utf16Length(name) * 2); length > k.MaxSubkeyNameLength() {
+		k.SetMaxSubkeyNameLength(length)
+	}
+	k.LastWTimestamp = now
+	return sk, nil
+}
+
+// DeleteSubkey deletes the direct subkey with the provided name together
+// with its values, subkeys are deleted only when recursive is set,
+// otherwise ErrKeyHasSubkeys is returned for a key with subkeys
+func (k *Key) DeleteSubkey(name string, recursive bool) error {
+	sk, err := k.Subkey(name)
+	if err != nil {
+		return err
+	}
+	if sk.SubkeysCount > 0 && !recursive {
+		return fmt.Errorf("%w: %q has %d subkeys", ErrKeyHasSubkeys, sk.Name(), sk.SubkeysCount)
+	}
+
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return err
+	}
+	remaining := make([]*Key, 0, len(subkeys))
+	for _, key := range subkeys {
+		if key.AbsoluteOffset() != sk.AbsoluteOffset() {
+			remaining = append(remaining, key)
+		}
+	}
+
+	if err := sk.deleteTree(make(map[int32]bool)); err != nil {
+		return err
+	}
+	if err := k.writeSubkeys(remaining); err != nil {
+		return err
+	}
+	k.LastWTimestamp = block.Filetime(time.Now())
+	return nil
+}
+
+// deleteTree releases all cells of this key and its descendants,
+// visited holds offsets of key nodes already reached
+func (k *Key) deleteTree(visited map[int32]bool) error {
+	if visited[k.AbsoluteOffset()] {
+		return fmt.Errorf("%w: key node at offset %#x", ErrCellLoop, k.AbsoluteOffset())
+	}
+	visited[k.AbsoluteOffset()] = true
+
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return err
+	}
+	for _, sk := range subkeys {
+		if err := sk.deleteTree(visited); err != nil {
+			return err
+		}
+	}
+
+	values, err := k.Values()
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := k.registry.freeValue(v.KeyValue); err != nil {
+			return err
+		}
+	}
+
+	r := k.registry
+	if k.KeyValuesCount > 0 {
+		if err := r.free(k.KeyValuesListOffset); err != nil {
+			return err
+		}
+	}
+	if k.SubkeysCount > 0 {
+		if err := r.freeSubkeyList(k.SubkeysListOffset); err != nil {
+			return err
+		}
+	}
+	if k.ClassNameLength != 0 {
+		if err := r.free(k.ClassNameOffset); err != nil {
+			return err
+		}
+	}
+	if err := r.releaseSecurity(k.KeySecurityOffset); err != nil {
+		return err
+	}
+	return r.free(k.AbsoluteOffset())
+}
+
+// SetValue stores the value data under the provided name, an existing
+// value is overwritten and its previous data released, data is stored
+// inline, in a data cell or in big data segments depending on its size
+func (k *Key) SetValue(name string, dataType uint32, data []byte) error {
+	if utf16Length(name) > MaxValueNameLength {
+		return fmt.Errorf("%w: value name of %d characters", ErrInvalidName, utf16Length(name))
+	}
+	if uint32(len(data)) >= block.DataSizeInline {
+		return fmt.Errorf("%w: %d bytes can not be stored", ErrDataSize, len(data))
+	}
+
+	values, err := k.Values()
+	if err != nil {
+		return err
+	}
+	var kv *block.KeyValue
+	for _, v := range values {
+		if block.EqualNames(v.Name(), name) {
+			kv = v.KeyValue
+			break
+		}
+	}
+
+	exists := kv != nil
+	if exists {
+		if err := k.registry.freeValueData(kv); err != nil {
+			return err
+		}
+	} else {
+		kv = &block.KeyValue{
+			HCellData: block.HCellData{HCellSignature: [2]byte{'v', 'k'}},
+		}
+		if err := kv.SetName(name); err != nil {
+			return err
+		}
+	}
+	if err := k.registry.storeValueData(kv, data); err != nil {
+		return err
+	}
+	kv.DataType = dataType
+
+	if !exists {
+		offset, err := k.registry.allocate(kv)
+		if err != nil {
+			return err
+		}
+		offsets := make([]int32, 0, len(values)+1)
+		for _, v := range values {
+			offsets = append(offsets, v.AbsoluteOffset())
+		}
+		if err := k.writeValues(append(offsets, offset)); err != nil {
+			return err
+		}
+	}
+
+	if length := int32(utf16Length(name) * 2); length > k.LValueNameLength {
+		k.LValueNameLength = length
+	}
+	if int32(len(data)) > k.LValueDataSize {
+		k.LValueDataSize = int32(len(data))
+	}
+	k.LastWTimestamp = block.Filetime(time.Now())
+	k.registry.modified = true
+	return nil
+}
+
+// DeleteValue deletes the value with the provided name and its data
+func (k *Key) DeleteValue(name string) error {
+	values, err := k.Values()
+	if err != nil {
+		return err
+	}
+
+	var deleted *Value
+	offsets := make([]int32, 0, len(values))
+	for _, v := range values {
+		if deleted == nil && block.EqualNames(v.Name(), name) {
+			deleted = v
+			continue
+		}
+		offsets = append(offsets, v.AbsoluteOffset())
+	}
+	if deleted == nil {
+		return fmt.Errorf("%w: %q in key %q", ErrValueNotFound, name, k.Name())
+	}
+
+	if err := k.registry.freeValue(deleted.KeyValue); err != nil {
+		return err
+	}
+	if err := k.writeValues(offsets); err != nil {
+		return err
+	}
+	k.LastWTimestamp = block.Filetime(time.Now())
+	return nil
+}
+
+// SetSecurityDescriptor makes the key reference a key security cell
+// holding the descriptor, an existing cell with the same descriptor is
+// shared, otherwise a new cell is linked after the one of the root key,
+// the previously referenced cell is released
+func (k *Key) SetSecurityDescriptor(sd *block.SecurityDescriptor) error {
+	data := sd.Bytes()
+	ks, err := k.registry.findSecurity(data)
+	if err != nil {
+		return err
+	}
+	if ks != nil && ks.AbsoluteOffset() == k.KeySecurityOffset {
+		return nil
+	}
+
+	if ks == nil {
+		ks = &block.KeySecurity{
+			HCellData: block.HCellData{HCellSignature: [2]byte{'s', 'k'}},
+			KeySecurityData: block.KeySecurityData{
+				SecDescriptorSize: uint32(len(data)),
+			},
+			SecDescriptor: data,
+		}
+		offset, err := k.registry.allocate(ks)
+		if err != nil {
+			return err
+		}
+		if err := k.registry.linkSecurity(ks, offset); err != nil {
+			return k.registry.freeOnError(offset, err)
+		}
+	}
+
+	old := k.KeySecurityOffset
+	ks.RefCount++
+	k.KeySecurityOffset = ks.AbsoluteOffset()
+	k.registry.modified = true
+	return k.registry.releaseSecurity(old)
+}
+
+// freeOnError releases the cell at offset allocated by a write that
+// failed with err, failure to release it is returned along with err
+func (r *Registry) freeOnError(offset int32, err error) error {
+	if ferr := r.free(offset); ferr != nil {
+		return fmt.Errorf("%w, releasing cell at offset %#x failed: %v", err, offset, ferr)
+	}
+	return err
+}
+
+// writeSubkeys replaces the subkeys list with a new list of the keys
+// sorted by name, leaves are split under an index root when they
+// exceed the number of elements Windows keeps in a single leaf
+func (k *Key) writeSubkeys(keys []*Key) error {
+	r := k.registry
+	if k.SubkeysCount > 0 {
+		if err := r.freeSubkeyList(k.SubkeysListOffset); err != nil {
+			return err
+		}
+	}
+	k.SubkeysCount, k.SubkeysListOffset = 0, NoCellOffset
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return block.UpcaseName(keys[i].Name()) < block.UpcaseName(keys[j].Name())
+	})
+
+	limit := MaxFastLeafElements
+	if r.Minor < FastLeafMinorVersion {
+		limit = MaxIndexLeafElements
+	}
+	var leaves []block.OffsetElement
+	for start := 0; start < len(keys); start += limit {
+		end := start + limit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		offset, err := r.allocate(r.subkeysLeaf(keys[start:end]))
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, block.OffsetElement(offset))
+	}
+
+	offset := int32(leaves[0])
+	if len(leaves) > 1 {
+		var err error
+		offset, err = r.allocate(&block.IndexRoot{
+			HCellData: block.HCellData{HCellSignature: [2]byte{'r', 'i'}, Metadata: uint16(len(leaves))},
+			Elements:  leaves,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	k.SubkeysCount, k.SubkeysListOffset = int32(len(keys)), offset
+	return nil
+}
+
+// subkeysLeaf builds a leaf of the type used by the hive format version,
+// hash leaves from 1.5, fast leaves from 1.3 and index leaves before
+func (r *Registry) subkeysLeaf(keys []*Key) block.HCell {
+	metadata := uint16(len(keys))
+	if r.Minor < FastLeafMinorVersion {
+		elements := make([]block.OffsetElement, len(keys))
+		for i, sk := range keys {
+			elements[i] = block.OffsetElement(sk.AbsoluteOffset())
+		}
+		return &block.IndexLeaf{
+			HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'i'}, Metadata: metadata},
+			Elements:  elements,
+		}
+	}
+
+	elements := make([]block.NamedElement, len(keys))
+	for i, sk := range keys {
+		elements[i].Offset = sk.AbsoluteOffset()
+		if r.Minor >= HashLeafMinorVersion {
+			binary.LittleEndian.PutUint32(elements[i].Name[:], block.NameHash(sk.Name()))
+		} else {
+			elements[i].Name = block.NameHint(sk.Name())
+		}
+	}
+	if r.Minor >= HashLeafMinorVersion {
+		return &block.HashLeaf{
+			HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'h'}, Metadata: metadata},
+			Elements:  elements,
+		}
+	}
+	return &block.FastLeaf{
+		HCellData: block.HCellData{HCellSignature: [2]byte{'l', 'f'}, Metadata: metadata},
+		Elements:  elements,
+	}
+}
+
+// freeSubkeyList releases the subkeys list and leaves of an index root
+func (r *Registry) freeSubkeyList(offset int32) error {
+	if offset == NoCellOffset {
+		return nil
+	}
+	hc, err := r.cellAt(offset)
+	if err != nil {
+		return err
+	}
+	if ir, ok := hc.(*block.IndexRoot); ok {
+		for _, e := range ir.Elements {
+			if err := r.free(int32(e)); err != nil {
+				return err
+			}
+		}
+	}
+	return r.free(offset)
+}
+
+// writeValues replaces the values list with a new list of the offsets
+func (k *Key) writeValues(offsets []int32) error {
+	r := k.registry
+	if k.KeyValuesCount > 0 {
+		if err := r.free(k.KeyValuesListOffset); err != nil {
+			return err
+		}
+	}
+	k.KeyValuesCount, k.KeyValuesListOffset = 0, NoCellOffset
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	list := make([]byte, 4*len(offsets))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint32(list[i*4:], uint32(offset))
+	}
+	offset, err := r.allocate(&block.DataRecord{Data: list})
+	if err != nil {
+		return err
+	}
+	k.KeyValuesCount, k.KeyValuesListOffset = int32(len(offsets)), offset
+	return nil
+}
+
+// storeValueData places data inline, in a data cell or in big data
+// segments and updates data size and offset of the key value
+func (r *Registry) storeValueData(kv *block.KeyValue, data []byte) error {
+	size := uint32(len(data))
+	if size <= block.DataInlineMaxSize {
+		inline := make([]byte, block.DataInlineMaxSize)
+		copy(inline, data)
+		kv.DataSize = int32(size | block.DataSizeInline)
+		kv.DataOffset = int32(binary.LittleEndian.Uint32(inline))
+		return nil
+	}
+
+	kv.DataSize = int32(size)
+	if !block.UsesBigData(r.Minor, size) {
+		offset, err := r.allocate(&block.DataRecord{Data: append([]byte{}, data...)})
+		kv.DataOffset = offset
+		return err
+	}
+
+	var segments []int32
+	for start := 0; start < len(data); start += block.BigDataSegmentSize {
+		end := start + block.BigDataSegmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		offset, err := r.allocate(&block.DataRecord{Data: append([]byte{}, data[start:end]...)})
+		if err != nil {
+			return err
+		}
+		segments = append(segments, offset)
+	}
+
+	list := make([]byte, 4*len(segments))
+	for i, offset := range segments {
+		binary.LittleEndian.PutUint32(list[i*4:], uint32(offset))
+	}
+	listOffset, err := r.allocate(&block.DataRecord{Data: list})
+	if err != nil {
+		return err
+	}
+	kv.DataOffset, err = r.allocate(&block.BigData{
+		HCellData:  block.HCellData{HCellSignature: [2]byte{'d', 'b'}, Metadata: uint16(len(segments))},
+		DataOffset: listOffset,
+	})
+	return err
+}
+
+// freeValue releases the key value cell and its data
+func (r *Registry) freeValue(kv *block.KeyValue) error {
+	if err := r.freeValueData(kv); err != nil {
+		return err
+	}
+	return r.free(kv.AbsoluteOffset())
+}
+
+// freeValueData releases data cells of the key value, big
+// data cells are released together with their segments
+func (r *Registry) freeValueData(kv *block.KeyValue) error {
+	size := kv.DataLength()
+	if kv.IsDataInline() || size == 0 {
+		return nil
+	}
+
+	if block.UsesBigData(r.Minor, size) {
+		hc, err := r.cellAt(kv.DataOffset)
+		if err != nil {
+			return err
+		}
+		if bd, ok := hc.(*block.BigData); ok {
+			list, err := r.dataAt(bd.DataOffset)
+			if err != nil {
+				return err
+			}
+			count := int(bd.SegmentCount())
+			if count*4 > len(list) {
+				return fmt.Errorf("segment list at offset %#x holds less than %d segments", bd.DataOffset, count)
+			}
+			for i := 0; i < count; i++ {
+				if err := r.free(int32(binary.LittleEndian.Uint32(list[i*4:]))); err != nil {
+					return err
+				}
+			}
+			if err := r.free(bd.DataOffset); err != nil {
+				return err
+			}
+		}
+	}
+	return r.free(kv.DataOffset)
+}
+
+// releaseSecurity drops a reference to the key security cell, the cell
+// is unlinked from the list of key security cells and released when
+// no key references it anymore
+func (r *Registry) releaseSecurity(offset int32) error {
+	if offset == NoCellOffset {
+		return nil
+	}
+	ks, err := r.securityAt(offset)
+	if err != nil {
+		return err
+	}
+	if ks.RefCount > 1 {
+		ks.RefCount--
+		return nil
+	}
+
+	if ks.Flink != offset {
+		prev, err := r.securityAt(ks.Blink)
+		if err != nil {
+			return err
+		}
+		next, err := r.securityAt(ks.Flink)
+		if err != nil {
+			return err
+		}
+		prev.Flink, next.Blink = ks.Flink, ks.Blink
+	}
+	return r.free(offset)
+}
+
+// findSecurity returns the key security cell linked in the list of the
+// root key which holds the descriptor data or nil if there is none
+func (r *Registry) findSecurity(data []byte) (*block.KeySecurity, error) {
+	root, err := r.Root()
+	if err != nil || root.KeySecurityOffset == NoCellOffset {
+		return nil, err
+	}
+	visited := make(map[int32]bool)
+	for offset := root.KeySecurityOffset; !visited[offset]; {
+		visited[offset] = true
+		ks, err := r.securityAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(ks.SecDescriptor, data) {
+			return ks, nil
+		}
+		offset = ks.Flink
+	}
+	return nil, nil
+}
+
+// linkSecurity inserts the key security cell at offset into the list
+// after the cell of the root key, the cell is linked to itself when
+// the root key does not reference one
+func (r *Registry) linkSecurity(ks *block.KeySecurity, offset int32) error {
+	root, err := r.Root()
+	if err != nil {
+		return err
+	}
+	if root.KeySecurityOffset == NoCellOffset {
+		ks.Flink, ks.Blink = offset, offset
+		return nil
+	}
+	first, err := r.securityAt(root.KeySecurityOffset)
+	if err != nil {
+		return err
+	}
+	next, err := r.securityAt(first.Flink)
+	if err != nil {
+		return err
+	}
+	ks.Flink, ks.Blink = first.Flink, first.AbsoluteOffset()
+	next.Blink, first.Flink = offset, offset
+	return nil
+}
+
+// utf16Length returns the number of UTF-16 code units of the name
+func utf16Length(name string) int {
+	return len(utf16.Encode([]rune(name)))
+}
@@ -0,0 +1,408 @@
+package winrego
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/turekt/winrego/block"
+)
+
+// testReloadHive verifies the modified hive has no fsck findings and
+// a valid security list and returns the hive loaded from its bytes
+func testReloadHive(t *testing.T, r *Registry) *Registry {
+	t.Helper()
+	findings, err := r.Fsck()
+	if err != nil {
+		t.Fatalf("failed to check registry: %v", err)
+	}
+	if len(findings) != 0 {
+		t.Fatalf("modified hive should have no findings, got %v", findings)
+	}
+	sl, err := r.SecurityList()
+	if err != nil {
+		t.Fatalf("failed to get security list: %v", err)
+	}
+	if !sl.Valid() {
+		t.Fatalf("security list of modified hive should be valid")
+	}
+
+	data, err := r.Bytes(WriteAllMarshal | WriteChecksum)
+	if err != nil {
+		t.Fatalf("failed to marshal modified hive: %v", err)
+	}
+	reloaded := &Registry{}
+	if err := reloaded.Load(data, ReadAllUnmarshal|ReadValidateChecksum); err != nil {
+		t.Fatalf("failed to load modified hive: %v", err)
+	}
+	return reloaded
+}
+
+func TestCreateSubkey(t *testing.T) {
+	r := testTreeRegistry(t)
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+
+	alpha, err := root.CreateSubkey("Alpha")
+	if err != nil {
+		t.Fatalf("failed to create subkey: %v", err)
+	}
+	if alpha.LastWTimestamp == 0 || root.LastWTimestamp != alpha.LastWTimestamp {
+		t.Errorf("timestamps should be set, got %#x and %#x", alpha.LastWTimestamp, root.LastWTimestamp)
+	}
+	if got, want := root.MaxSubkeyNameLength(), uint16(len("Alpha")*2); got != want {
+		t.Errorf("max subkey name length mismatch: got %d, want %d", got, want)
+	}
+	if existing, err := root.CreateSubkey("ALPHA"); err != nil || existing.AbsoluteOffset() != alpha.AbsoluteOffset() {
+		t.Errorf("existing subkey should be returned, got %v, %v", existing, err)
+	}
+	if _, err := alpha.CreateSubkey("Ω-Ключ"); err != nil {
+		t.Fatalf("failed to create non-ASCII subkey: %v", err)
+	}
+	for _, name := range []string{"", `a\b`, string(bytes.Repeat([]byte{'x'}, MaxKeyNameLength+1))} {
+		if _, err := root.CreateSubkey(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected ErrInvalidName for %q, got %v", name, err)
+		}
+	}
+
+	reloaded := testReloadHive(t, r)
+	newRoot, err := reloaded.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	subkeys, err := newRoot.Subkeys()
+	if err != nil {
+		t.Fatalf("failed to get subkeys: %v", err)
+	}
+	if got, want := keyNames(subkeys), []string{"Alpha", "Software", "System"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("subkeys mismatch: got %v, want %v", got, want)
+	}
+	if _, err := reloaded.OpenKey(`alpha\Ω-ключ`); err != nil {
+		t.Errorf("failed to open created key: %v", err)
+	}
+	ks, err := subkeys[0].Security()
+	if err != nil {
+		t.Fatalf("failed to get key security: %v", err)
+	}
+	if got, want := ks.RefCount, uint32(8); got != want {
+		t.Errorf("security reference count mismatch: got %d, want %d", got, want)
+	}
+}
+
+func TestSaveModifiedUpdatesChecksum(t *testing.T) {
+	r := testTreeRegistry(t)
+
+	// checksum of an unmodified hive is written as loaded
+	data, err := r.Bytes(WriteAllMarshal)
+	if err != nil {
+		t.Fatalf("failed to marshal registry: %v", err)
+	}
+	if err := (&Registry{}).Load(data, ReadAllUnmarshal|ReadValidateChecksum); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected ErrChecksum for unmodified test hive, got %v", err)
+	}
+
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if err := software.SetValue("Big", block.RegBinary, make([]byte, 3*block.HBinAlignment)); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "hive")
+	if err := r.Save(path, WriteAllMarshal); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+	saved, err := OpenRegistry(path, ReadAllUnmarshal|ReadValidateChecksum)
+	if err != nil {
+		t.Fatalf("failed to open saved registry: %v", err)
+	}
+	if saved.HBinSize != r.HBins.TotalSize() {
+		t.Errorf("hbins size mismatch: got %d, want %d", saved.HBinSize, r.HBins.TotalSize())
+	}
+}
+
+func TestCreateSubkeySplitsLeaves(t *testing.T) {
+	testCases := []struct {
+		Minor     uint32
+		Leaf      string
+		LeafLimit int
+	}{
+		{2, "li", MaxIndexLeafElements},
+		{3, "lf", MaxFastLeafElements},
+		{5, "lh", MaxFastLeafElements},
+	}
+	for _, tc := range testCases {
+		r := testTreeRegistry(t)
+		r.Minor = tc.Minor
+		system, err := r.OpenKey("System")
+		if err != nil {
+			t.Fatalf("failed to open key: %v", err)
+		}
+
+		count := tc.LeafLimit + 10
+		for i := 0; i < count; i++ {
+			if _, err := system.CreateSubkey(fmt.Sprintf("Key%04d", count-i)); err != nil {
+				t.Fatalf("failed to create subkey %d: %v", i, err)
+			}
+		}
+
+		hc, err := r.cellAt(system.SubkeysListOffset)
+		if err != nil {
+			t.Fatalf("failed to get subkeys list: %v", err)
+		}
+		ir, ok := hc.(*block.IndexRoot)
+		if !ok || len(ir.Elements) != 2 {
+			t.Fatalf("version 1.%d: subkeys list should be an index root of two leaves, got %s", tc.Minor, hc.Signature())
+		}
+		leaf, err := r.cellAt(int32(ir.Elements[0]))
+		if err != nil || leaf.Signature() != tc.Leaf {
+			t.Errorf("version 1.%d: leaf signature mismatch: got %v (%v), want %s", tc.Minor, leaf, err, tc.Leaf)
+		}
+
+		reloaded := testReloadHive(t, r)
+		if _, err := reloaded.OpenKey(fmt.Sprintf(`System\Key%04d`, count)); err != nil {
+			t.Errorf("version 1.%d: failed to open created key: %v", tc.Minor, err)
+		}
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	r := testTreeRegistry(t)
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 2000)
+	testCases := []struct {
+		Name string
+		Type uint32
+		Data []byte
+	}{
+		{"Version", block.RegDWord, []byte{9, 0, 0, 0}},
+		{"", block.RegSz, block.EncodeUTF16("default\x00")},
+		{"Empty", block.RegBinary, []byte{}},
+		{"Big", block.RegBinary, big},
+		{"Větší", block.RegBinary, big[:block.BigDataSegmentSize]},
+	}
+	for _, tc := range testCases {
+		if err := software.SetValue(tc.Name, tc.Type, tc.Data); err != nil {
+			t.Fatalf("failed to set value %q: %v", tc.Name, err)
+		}
+	}
+	if got, want := software.LValueDataSize, int32(len(big)); got != want {
+		t.Errorf("max value data size mismatch: got %d, want %d", got, want)
+	}
+	if got, want := software.LValueNameLength, int32(len("Version")*2); got != want {
+		t.Errorf("max value name length mismatch: got %d, want %d", got, want)
+	}
+
+	// overwriting releases big data cells of the previous value
+	if err := software.SetValue("big", block.RegBinary, big[:100]); err != nil {
+		t.Fatalf("failed to overwrite value: %v", err)
+	}
+	testCases[3].Data = big[:100]
+
+	reloaded := testReloadHive(t, r)
+	key, err := reloaded.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if got, want := key.KeyValuesCount, int32(len(testCases)); got != want {
+		t.Errorf("values count mismatch: got %d, want %d", got, want)
+	}
+	for _, tc := range testCases {
+		v, err := key.Value(tc.Name)
+		if err != nil {
+			t.Errorf("failed to get value %q: %v", tc.Name, err)
+			continue
+		}
+		data, err := v.Data()
+		if err != nil || v.Type() != tc.Type || !bytes.Equal(data, tc.Data) {
+			t.Errorf("value %q mismatch: got type %d, %d bytes (%v), want type %d, %d bytes", tc.Name, v.Type(), len(data), err, tc.Type, len(tc.Data))
+		}
+	}
+	if v, err := key.Value("Version"); err == nil && v.Name() != "Version" {
+		t.Errorf("value name should be kept, got %q", v.Name())
+	}
+}
+
+func TestDeleteValue(t *testing.T) {
+	r := testTreeRegistry(t)
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	if err := software.SetValue("Data", block.RegBinary, make([]byte, 64)); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+
+	if err := software.DeleteValue("version"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if err := software.DeleteValue("Missing"); !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("expected ErrValueNotFound, got %v", err)
+	}
+	values, err := software.Values()
+	if err != nil {
+		t.Fatalf("failed to get values: %v", err)
+	}
+	if len(values) != 1 || values[0].Name() != "Data" {
+		t.Fatalf("values mismatch after delete: got %v", values)
+	}
+
+	if err := software.DeleteValue("Data"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if software.KeyValuesCount != 0 || software.KeyValuesListOffset != NoCellOffset {
+		t.Errorf("values list should be removed, got count %d at %#x", software.KeyValuesCount, software.KeyValuesListOffset)
+	}
+	testReloadHive(t, r)
+}
+
+func TestDeleteSubkey(t *testing.T) {
+	r := testTreeRegistry(t)
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+
+	if err := root.DeleteSubkey("System", false); !errors.Is(err, ErrKeyHasSubkeys) {
+		t.Fatalf("expected ErrKeyHasSubkeys, got %v", err)
+	}
+	if err := root.DeleteSubkey("Missing", true); err == nil {
+		t.Errorf("deleting missing key should fail")
+	}
+
+	// key with its own security descriptor is unlinked from the list
+	vendor, err := r.OpenKey(`Software\Vendor`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	ks, err := vendor.Security()
+	if err != nil {
+		t.Fatalf("failed to get key security: %v", err)
+	}
+	own := testKeySecurity("O:BAG:SYD:(A;;KA;;;WD)")
+	own.Flink, own.Blink, own.RefCount = ks.AbsoluteOffset(), ks.AbsoluteOffset(), 1
+	offset, err := r.allocate(own)
+	if err != nil {
+		t.Fatalf("failed to allocate key security: %v", err)
+	}
+	ks.Flink, ks.Blink, ks.RefCount = offset, offset, ks.RefCount-1
+	vendor.KeySecurityOffset = offset
+	if err := vendor.SetValue("Data", block.RegBinary, make([]byte, 64)); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := vendor.SetClassName("VendorClass"); err != nil {
+		t.Fatalf("failed to set class name: %v", err)
+	}
+
+	for _, name := range []string{"System", "Software"} {
+		if err := root.DeleteSubkey(name, true); err != nil {
+			t.Fatalf("failed to delete %s: %v", name, err)
+		}
+	}
+	if root.SubkeysCount != 0 || root.SubkeysListOffset != NoCellOffset {
+		t.Errorf("subkeys list should be removed, got count %d at %#x", root.SubkeysCount, root.SubkeysListOffset)
+	}
+	if ks.RefCount != 1 || ks.Flink != ks.AbsoluteOffset() || ks.Blink != ks.AbsoluteOffset() {
+		t.Errorf("key security should be referenced by root only, got %+v", ks.KeySecurityData)
+	}
+
+	reloaded := testReloadHive(t, r)
+	for _, hc := range reloaded.HBins[0].Cells {
+		if hc.Allocated() && hc.AbsoluteOffset() != int32(reloaded.RootCellOffset) && hc.AbsoluteOffset() != ks.AbsoluteOffset() {
+			t.Errorf("cell %s at %#x should be released", hc.Signature(), hc.AbsoluteOffset())
+		}
+	}
+}
+
+func TestSetSecurityDescriptor(t *testing.T) {
+	r := testTreeRegistry(t)
+	vendor, err := r.OpenKey(`Software\Vendor`)
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	software, err := r.OpenKey("Software")
+	if err != nil {
+		t.Fatalf("failed to open key: %v", err)
+	}
+	shared := vendor.KeySecurityOffset
+
+	sd, err := block.ParseSDDL("O:BAG:SYD:(A;;KA;;;WD)")
+	if err != nil {
+		t.Fatalf("failed to parse SDDL: %v", err)
+	}
+	for _, k := range []*Key{vendor, software} {
+		if err := k.SetSecurityDescriptor(sd); err != nil {
+			t.Fatalf("failed to set security descriptor: %v", err)
+		}
+	}
+	if vendor.KeySecurityOffset == shared || vendor.KeySecurityOffset != software.KeySecurityOffset {
+		t.Errorf("keys should share a new key security cell, got %#x and %#x", vendor.KeySecurityOffset, software.KeySecurityOffset)
+	}
+	ks, err := vendor.Security()
+	if err != nil {
+		t.Fatalf("failed to get key security: %v", err)
+	}
+	if ks.RefCount != 2 {
+		t.Errorf("reference count mismatch: got %d, want 2", ks.RefCount)
+	}
+
+	// setting the descriptor of the shared cell again is a no-op
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("failed to get root key: %v", err)
+	}
+	rootSD, err := root.SecurityDescriptor()
+	if err != nil {
+		t.Fatalf("failed to get security descriptor: %v", err)
+	}
+	if err := root.SetSecurityDescriptor(rootSD); err != nil || root.KeySecurityOffset != shared {
+		t.Errorf("root key security should be kept at %#x, got %#x (%v)", shared, root.KeySecurityOffset, err)
+	}
+
+	// replacing the last reference releases the cell
+	for _, k := range []*Key{vendor, software} {
+		if err := k.SetSecurityDescriptor(rootSD); err != nil {
+			t.Fatalf("failed to set security descriptor: %v", err)
+		}
+	}
+	sl, err := r.SecurityList()
+	if err != nil {
+		t.Fatalf("failed to get security list: %v", err)
+	}
+	if len(sl.Entries) != 1 {
+		t.Errorf("security list should hold a single cell, got %d", len(sl.Entries))
+	}
+	testReloadHive(t, r)
+}
+
+func TestFreeOnError(t *testing.T) {
+	r := testTreeRegistry(t)
+	errWrite := errors.New("write failed")
+	offset, err := r.allocate(testDataRecord(make([]byte, 12)))
+	if err != nil {
+		t.Fatalf("failed to allocate cell: %v", err)
+	}
+
+	if err := r.freeOnError(offset, errWrite); err != errWrite {
+		t.Errorf("expected the write error, got %v", err)
+	}
+	if hc, err := r.cellAt(offset); err != nil || hc.Allocated() {
+		t.Errorf("cell should be released, got %v", err)
+	}
+
+	// releasing the cell again fails and is reported with the write error
+	err = r.freeOnError(offset, errWrite)
+	if !errors.Is(err, errWrite) || !strings.Contains(err.Error(), "releasing cell") {
+		t.Errorf("expected the write error with the release failure, got %v", err)
+	}
+}